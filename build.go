@@ -63,7 +63,7 @@ func main() {
 	for i, t := range selectedTargets {
 		fmt.Printf("[%d/%d] 正在构建 %s/%s -> %s ... ", i+1, len(selectedTargets), t.os, t.arch, t.output)
 
-		cmd := exec.Command("go", "build", "-o", filepath.Join(outputDir, t.output), "-ldflags", ldflags, mainPath)
+		cmd := exec.Command("go", "build", "-tags", "goolm", "-o", filepath.Join(outputDir, t.output), "-ldflags", ldflags, mainPath)
 		cmd.Env = append(os.Environ(), "GOOS="+t.os, "GOARCH="+t.arch, "CGO_ENABLED=0")
 
 		if out, err := cmd.CombinedOutput(); err != nil {
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"Relify/internal"
+	"Relify/internal/driver/matrix"
+)
+
+// errReported 表示错误信息已经输出，只需以状态码 1 退出。
+var errReported = errors.New("已报告的错误")
+
+// usageError 表示命令行用法错误，输出帮助信息并以状态码 2 退出。
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+// options 是所有子命令共用的参数。
+type options struct {
+	dataDir string
+	config  string
+}
+
+// configPath 返回配置文件路径，未指定时使用数据目录下的 config.yaml。
+func (o *options) configPath() string {
+	if o.config != "" {
+		return o.config
+	}
+	return filepath.Join(o.dataDir, "config.yaml")
+}
+
+// parseFlags 解析通用参数及 extra 注册的子命令参数，返回剩余的位置参数。
+func parseFlags(name string, args []string, extra func(*flag.FlagSet)) (*options, []string, error) {
+	opts := &options{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opts.dataDir, "data-dir", "data", "数据目录")
+	fs.StringVar(&opts.config, "config", "", "配置文件（默认 <数据目录>/config.yaml）")
+	if extra != nil {
+		extra(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, nil, err
+		}
+		return nil, nil, usageError{err.Error()}
+	}
+	internal.DataDir = opts.dataDir
+	return opts, fs.Args(), nil
+}
+
+// subcommand 根据第一个参数选择二级子命令。
+func subcommand(args []string, cmds map[string]func([]string) error) error {
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) == 0 {
+		return usageError{fmt.Sprintf("缺少子命令（可选 %s）", strings.Join(names, "、"))}
+	}
+	cmd, ok := cmds[args[0]]
+	if !ok {
+		return usageError{fmt.Sprintf("未知子命令: %s（可选 %s）", args[0], strings.Join(names, "、"))}
+	}
+	return cmd(args[1:])
+}
+
+// positional 检查位置参数的数量。
+func positional(args []string, n int, names string) error {
+	if len(args) != n {
+		return usageError{fmt.Sprintf("需要 %d 个参数: %s", n, names)}
+	}
+	return nil
+}
+
+// openCore 加载配置并打开不加载驱动的 Core，日志仅输出警告以上级别到标准错误。
+// 离线命令仅需要平台列表和保留天数，因此不对配置做完整校验。
+func openCore(opts *options) (*internal.Core, error) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	config, err := internal.LoadConfig(opts.configPath())
+	if err != nil {
+		return nil, err
+	}
+	return internal.OpenCore(config, opts.dataDir)
+}
+
+// cmdInit 在数据目录中生成默认配置文件，已存在时需要 --force 才会覆盖。
+func cmdInit(args []string) error {
+	var force bool
+	opts, _, err := parseFlags("init", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&force, "force", false, "覆盖已存在的配置文件")
+	})
+	if err != nil {
+		return err
+	}
+
+	path := opts.configPath()
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("配置文件已存在: %s（使用 --force 覆盖）", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := internal.SaveConfig(path, internal.DefaultConfig()); err != nil {
+		return err
+	}
+	fmt.Printf("已生成默认配置: %s\n", path)
+	return nil
+}
+
+// cmdConfigCheck 加载并校验配置文件，列出全部问题及其所在行。
+func cmdConfigCheck(args []string) error {
+	opts, _, err := parseFlags("config check", args, nil)
+	if err != nil {
+		return err
+	}
+
+	path := opts.configPath()
+	config, err := internal.LoadConfig(path)
+	if err == nil {
+		err = config.Check()
+	}
+
+	var cerr *internal.ConfigError
+	switch {
+	case errors.As(err, &cerr):
+		fmt.Printf("%s: 发现 %d 处问题\n", path, len(cerr.Issues))
+		for _, issue := range cerr.Issues {
+			fmt.Printf("  %s\n", issue)
+		}
+		return errReported
+	case err != nil:
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	fmt.Printf("%s: 配置校验通过\n", path)
+	return nil
+}
+
+// cmdBridgesList 列出全部桥接组。
+func cmdBridgesList(args []string) error {
+	opts, _, err := parseFlags("bridges list", args, nil)
+	if err != nil {
+		return err
+	}
+	core, err := openCore(opts)
+	if err != nil {
+		return err
+	}
+	defer core.Close()
+
+	groups, err := core.ListBridges()
+	if err != nil {
+		return err
+	}
+	if len(groups) == 0 {
+		fmt.Println("没有桥接组")
+		return nil
+	}
+	for _, g := range groups {
+		nodes := make([]string, len(g.Nodes))
+		for i, n := range g.Nodes {
+			nodes[i] = n.Platform + ":" + n.RoomID
+		}
+		fmt.Printf("#%d\t%s\n", g.ID, strings.Join(nodes, "  "))
+	}
+	return nil
+}
+
+// cmdBridgesLink 手动桥接两个房间。
+func cmdBridgesLink(args []string) error {
+	opts, rest, err := parseFlags("bridges link", args, nil)
+	if err != nil {
+		return err
+	}
+	if err := positional(rest, 2, "<平台:房间ID> <平台:房间ID>"); err != nil {
+		return err
+	}
+	a, err := internal.ParseNode(rest[0])
+	if err != nil {
+		return err
+	}
+	b, err := internal.ParseNode(rest[1])
+	if err != nil {
+		return err
+	}
+
+	core, err := openCore(opts)
+	if err != nil {
+		return err
+	}
+	defer core.Close()
+
+	group, err := core.LinkNodes([]internal.BridgeNode{a, b})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已桥接到 #%d\n", group.ID)
+	return nil
+}
+
+// cmdBridgesUnlink 将房间移出其所在的桥接组。
+func cmdBridgesUnlink(args []string) error {
+	opts, rest, err := parseFlags("bridges unlink", args, nil)
+	if err != nil {
+		return err
+	}
+	if err := positional(rest, 1, "<平台:房间ID>"); err != nil {
+		return err
+	}
+	node, err := internal.ParseNode(rest[0])
+	if err != nil {
+		return err
+	}
+
+	core, err := openCore(opts)
+	if err != nil {
+		return err
+	}
+	defer core.Close()
+
+	if _, err := core.DetachNode(node); err != nil {
+		return err
+	}
+	fmt.Printf("已移出 %s\n", rest[0])
+	return nil
+}
+
+// cmdMappingsLookup 查询源消息在目标平台上对应的消息 ID。
+func cmdMappingsLookup(args []string) error {
+	opts, rest, err := parseFlags("mappings lookup", args, nil)
+	if err != nil {
+		return err
+	}
+	if err := positional(rest, 3, "<平台> <消息ID> <目标平台>"); err != nil {
+		return err
+	}
+
+	core, err := openCore(opts)
+	if err != nil {
+		return err
+	}
+	defer core.Close()
+
+	id, ok := core.Store.FindMapping(rest[0], rest[1], rest[2])
+	if !ok {
+		return errors.New("未找到映射")
+	}
+	fmt.Println(id)
+	return nil
+}
+
+// cmdMatrixRegistration 输出 Matrix 平台的 AppService 注册文件内容。
+// 注册文件不存在时生成随机令牌并写入，与驱动首次启动时的行为一致。
+// 未指定 --platform 时使用唯一一个 matrix 驱动的平台。
+func cmdMatrixRegistration(args []string) error {
+	var platform string
+	opts, _, err := parseFlags("matrix registration", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&platform, "platform", "", "平台名称（配置中 platforms 下的键）")
+	})
+	if err != nil {
+		return err
+	}
+	config, err := internal.LoadConfig(opts.configPath())
+	if err != nil {
+		return err
+	}
+
+	if platform == "" {
+		var found []string
+		for name, pc := range config.Platforms {
+			if pc.Driver == "matrix" {
+				found = append(found, name)
+			}
+		}
+		if len(found) != 1 {
+			sort.Strings(found)
+			return fmt.Errorf("找到 %d 个 Matrix 平台 %v，请使用 --platform 指定", len(found), found)
+		}
+		platform = found[0]
+	}
+	pc, ok := config.Platforms[platform]
+	if !ok || pc.Driver != "matrix" {
+		return fmt.Errorf("未找到 Matrix 平台: %s", platform)
+	}
+
+	reg, path, err := matrix.Registration(pc.Config)
+	if err != nil {
+		return err
+	}
+	data, err := reg.YAML()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "注册文件: %s\n", path)
+	fmt.Print(data)
+	return nil
+}
+
+// cmdDBVacuum 清除过期的消息映射并整理数据库文件。
+func cmdDBVacuum(args []string) error {
+	opts, _, err := parseFlags("db vacuum", args, nil)
+	if err != nil {
+		return err
+	}
+	core, err := openCore(opts)
+	if err != nil {
+		return err
+	}
+	defer core.Close()
+
+	removed, err := core.Store.Vacuum()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已清除 %d 条过期映射并整理数据库\n", removed)
+	return nil
+}
+
+// cmdDBExport 以 JSON 格式导出数据库，默认输出到标准输出。
+func cmdDBExport(args []string) error {
+	var output string
+	opts, _, err := parseFlags("db export", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&output, "o", "", "输出文件（默认标准输出）")
+	})
+	if err != nil {
+		return err
+	}
+	core, err := openCore(opts)
+	if err != nil {
+		return err
+	}
+	defer core.Close()
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return core.Store.Export(w)
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log/slog"
@@ -16,85 +18,188 @@ import (
 	_ "Relify/internal/driver/qq"
 )
 
-// main 是应用程序的入口函数。
+// Version 和 BuildTime 由 build.go 通过 -ldflags 注入。
+var (
+	Version   = "dev"
+	BuildTime = "unknown"
+)
+
+// usage 是命令行帮助信息。
+const usage = `用法: relify <命令> [参数]
+
+命令:
+  run                              启动服务（默认命令）
+  init                             生成默认配置文件
+  version                          显示版本信息
+  config check                     校验配置文件
+  bridges list                     列出桥接组
+  bridges link <节点> <节点>        桥接两个房间，节点格式为 平台:房间ID
+  bridges unlink <节点>             将房间移出桥接组
+  mappings lookup <平台> <消息ID> <目标平台>
+                                   查询消息映射
+  matrix registration [--platform 名称]
+                                   生成并输出 Matrix AppService 注册文件
+  db vacuum                        清除过期映射并整理数据库
+  db export [-o 文件]               以 JSON 格式导出数据库
+
+通用参数:
+  --data-dir <目录>                 数据目录（默认 data）
+  --config <文件>                   配置文件（默认 <数据目录>/config.yaml）
+
+bridges、mappings、db 命令直接读写数据库，运行中的实例需重启后才能看到桥接变更，
+在线管理请使用管理接口。
+`
+
+// main 是应用程序的入口函数，解析子命令并以其返回值作为进程退出码。
+// 未指定子命令时执行 run。
+func main() {
+	os.Exit(dispatch(os.Args[1:]))
+}
+
+// dispatch 根据第一个参数选择子命令，返回进程退出码。
+func dispatch(args []string) int {
+	name := "run"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		name, args = args[0], args[1:]
+	}
+
+	var err error
+	switch name {
+	case "run":
+		err = cmdRun(args)
+	case "init":
+		err = cmdInit(args)
+	case "version":
+		fmt.Printf("relify %s (构建于 %s)\n", Version, BuildTime)
+	case "config":
+		err = subcommand(args, map[string]func([]string) error{"check": cmdConfigCheck})
+	case "bridges":
+		err = subcommand(args, map[string]func([]string) error{
+			"list": cmdBridgesList, "link": cmdBridgesLink, "unlink": cmdBridgesUnlink,
+		})
+	case "mappings":
+		err = subcommand(args, map[string]func([]string) error{"lookup": cmdMappingsLookup})
+	case "matrix":
+		err = subcommand(args, map[string]func([]string) error{"registration": cmdMatrixRegistration})
+	case "db":
+		err = subcommand(args, map[string]func([]string) error{"vacuum": cmdDBVacuum, "export": cmdDBExport})
+	case "help":
+		fmt.Print(usage)
+	default:
+		err = usageError{fmt.Sprintf("未知命令: %s", name)}
+	}
+
+	var uerr usageError
+	switch {
+	case errors.As(err, &uerr):
+		fmt.Fprintf(os.Stderr, "%s\n\n%s", uerr.msg, usage)
+		return 2
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errReported):
+		return 1
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "错误:", err)
+		return 1
+	}
+	return 0
+}
+
+// cmdRun 启动服务。
 // 主要流程包括：
 // 1. 调用 setup 初始化环境和配置。
 // 2. 创建并启动核心服务 (Core)。
-// 3. 阻塞监听系统中断信号 (如 Ctrl+C, SIGTERM)。
+// 3. 阻塞监听系统中断信号 (如 Ctrl+C, SIGTERM)，收到 SIGHUP 时重新加载配置。
 // 4. 接收到信号后执行优雅关闭流程。
-func main() {
-	config := setup()
+func cmdRun(args []string) error {
+	opts, _, err := parseFlags("run", args, nil)
+	if err != nil {
+		return err
+	}
+	config, err := setup(opts)
+	if err != nil {
+		return err
+	}
+	slog.Info("Relify 启动中", "version", Version, "build_time", BuildTime)
 	ctx, cancel := context.WithCancel(context.Background())
 
-	app, err := internal.NewCore(config)
+	app, err := internal.NewCore(config, opts.dataDir)
 	if err == nil {
+		app.ConfigPath = opts.configPath()
 		err = app.Start(ctx)
 	}
 
 	if err != nil {
 		slog.Error("启动失败", "err", err)
 		cancel()
-		os.Exit(1)
+		if app != nil {
+			stopCtx, stop := context.WithTimeout(context.Background(), 10*time.Second)
+			app.Stop(stopCtx)
+			stop()
+		}
+		return errReported
 	}
 
-	// 监听中断信号 (Ctrl+C) 或终止信号
+	// 监听中断信号 (Ctrl+C) 或终止信号，SIGHUP 触发重新加载配置
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if err := app.Reload(); err != nil {
+			slog.Error("重新加载配置失败，保留原配置", "err", err)
+		}
+	}
 
 	slog.Info("停止中...")
-	cancel()
+	// 驱动的上下文由 Stop 在排空投递后取消，过早取消会中断正在进行的投递
+	defer cancel()
 
 	// 设置超时上下文以确保清理操作不会无限期挂起
 	stopCtx, stop := context.WithTimeout(context.Background(), 10*time.Second)
 	defer stop()
-	app.Stop(stopCtx)
+	return app.Stop(stopCtx)
 }
 
-// setup 负责应用程序的基础设施初始化。
+// setup 负责服务运行的基础设施初始化。
 // 功能包括：
 // - 创建日志目录。
-// - 加载或生成默认配置文件。
+// - 加载并校验配置文件，配置文件不存在时提示先执行 relify init。
 // - 初始化结构化日志记录器 (slog)，配置日志级别、输出格式及多端输出 (控制台+文件)。
-func setup() *internal.Config {
-	os.MkdirAll("data/logs", 0755)
-	configPath := filepath.Join("data", "config.yaml")
+func setup(opts *options) (*internal.Config, error) {
+	logDir := filepath.Join(opts.dataDir, "logs")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return nil, err
+	}
 
-	// 检查配置文件是否存在，不存在则生成默认配置
+	configPath := opts.configPath()
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		if internal.SaveConfig(configPath, internal.DefaultConfig()) == nil {
-			slog.Info("生成配置成功", "path", configPath)
-			os.Exit(0)
-		}
+		return nil, fmt.Errorf("配置文件不存在: %s，请先执行 relify init 生成", configPath)
 	}
 
 	config, err := internal.LoadConfig(configPath)
-	if err != nil || config.Check() != nil {
-		slog.Error("加载配置失败", "err", err)
-		os.Exit(1)
+	if err == nil {
+		err = config.Check()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("加载配置失败: %w", err)
 	}
 
 	// 设置日志文件输出，文件名包含当前时间戳
 	logFile, _ := os.OpenFile(
-		filepath.Join("data/logs", fmt.Sprintf("relify_%s.log", time.Now().Format("2006-01-02_15-04-05"))),
+		filepath.Join(logDir, fmt.Sprintf("relify_%s.log", time.Now().Format("2006-01-02_15-04-05"))),
 		os.O_CREATE|os.O_WRONLY|os.O_APPEND,
 		0666,
 	)
 
-	// 根据配置设置日志级别
-	logLevel := slog.LevelInfo
-	switch config.LogLevel {
-	case "debug":
-		logLevel = slog.LevelDebug
-	case "warn":
-		logLevel = slog.LevelWarn
-	case "error":
-		logLevel = slog.LevelError
-	}
+	// 根据配置设置日志级别，重新加载配置时由 Core 更新
+	logLevel, _ := internal.ParseLogLevel(config.LogLevel)
+	internal.LogLevel.Set(logLevel)
 
 	// 配置 slog 使用 JSON 格式，并同时输出到控制台和文件
 	slog.SetDefault(slog.New(slog.NewJSONHandler(io.MultiWriter(os.Stdout, logFile), &slog.HandlerOptions{
-		Level: logLevel,
+		Level: internal.LogLevel,
 		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
 			// 格式化时间戳为可读格式
 			if a.Key == slog.TimeKey {
@@ -104,5 +209,5 @@ func setup() *internal.Config {
 		},
 	})))
 
-	return config
+	return config, nil
 }
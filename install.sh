@@ -74,6 +74,12 @@ sudo mv "$TMP_FILE" "$INSTALL_DIR/$APP_NAME"
 log_info "创建软链接 $BIN_LINK ..."
 sudo ln -sf "$INSTALL_DIR/$APP_NAME" "$BIN_LINK"
 
+# 生成默认配置 (已存在时保留)
+if [ ! -f "$INSTALL_DIR/data/config.yaml" ]; then
+    log_info "生成默认配置 $INSTALL_DIR/data/config.yaml ..."
+    sudo "$INSTALL_DIR/$APP_NAME" init --data-dir "$INSTALL_DIR/data"
+fi
+
 # --- 4. Systemd 配置 (仅 Linux) ---
 if [ "$OS_TYPE" == "linux" ] && command -v systemctl >/dev/null 2>&1; then
     log_info "检测到 Systemd，正在配置服务..."
@@ -88,7 +94,7 @@ After=network.target
 Type=simple
 User=root
 WorkingDirectory=$INSTALL_DIR
-ExecStart=$INSTALL_DIR/$APP_NAME
+ExecStart=$INSTALL_DIR/$APP_NAME run
 Restart=on-failure
 RestartSec=5s
 
@@ -0,0 +1,348 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsHandler 导出默认注册表中的全部指标（含 Go 运行时与进程指标）。
+var metricsHandler = promhttp.Handler()
+
+// startAdmin 按配置启动 HTTP 管理接口，未配置监听地址时直接返回。
+// 监听失败会作为启动错误返回；之后的服务错误仅记录日志。
+func (c *Core) startAdmin() error {
+	if c.Config().Admin.Listen == "" {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", c.Config().Admin.Listen)
+	if err != nil {
+		return fmt.Errorf("管理接口监听失败: %w", err)
+	}
+
+	c.admin = &http.Server{
+		Handler:           c.adminHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := c.admin.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("管理接口服务错误", "err", err)
+		}
+	}()
+
+	slog.Info("管理接口已启动", "listen", c.Config().Admin.Listen)
+	return nil
+}
+
+// adminHandler 构建管理接口的路由，除 /metrics、/healthz、/readyz 外所有接口均需 Bearer Token 认证。
+func (c *Core) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/drivers", c.apiDrivers)
+	mux.HandleFunc("GET /api/bridges", c.apiListBridges)
+	mux.HandleFunc("POST /api/bridges", c.apiCreateBridge)
+	mux.HandleFunc("GET /api/bridges/{id}", c.apiGetBridge)
+	mux.HandleFunc("DELETE /api/bridges/{id}", c.apiDeleteBridge)
+	mux.HandleFunc("POST /api/bridges/{id}/nodes", c.apiAttachNode)
+	mux.HandleFunc("DELETE /api/nodes/{node}", c.apiDetachNode)
+	mux.HandleFunc("GET /api/requests", c.apiListRequests)
+	mux.HandleFunc("POST /api/requests/{node}/{action}", c.apiHandleRequest)
+	mux.HandleFunc("GET /api/mappings", c.apiFindMapping)
+	mux.HandleFunc("GET /api/queues", c.apiQueues)
+	mux.HandleFunc("POST /api/reload", c.apiReload)
+	mux.HandleFunc("POST /api/test", c.apiTestMessage)
+
+	token := []byte("Bearer " + c.Config().Admin.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 监控与探针端点供 Prometheus、systemd、容器编排抓取，无需认证
+		switch r.URL.Path {
+		case "/metrics":
+			metricsHandler.ServeHTTP(w, r)
+			return
+		case "/healthz":
+			writeProbe(w, c.Live())
+			return
+		case "/readyz":
+			writeProbe(w, c.Ready())
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) != 1 {
+			slog.Warn("管理接口认证失败", "remote", r.RemoteAddr, "path", r.URL.Path)
+			writeError(w, http.StatusUnauthorized, errors.New("未授权"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON 以 JSON 格式写出响应。
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError 以 {"error": "..."} 格式写出错误响应。
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeProbe 写出探针响应：无问题时返回 200，否则返回 503 及问题列表。
+func writeProbe(w http.ResponseWriter, problems []string) {
+	if len(problems) == 0 {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "fail", "problems": problems})
+}
+
+// readJSON 解析请求体，失败时写出 400 响应并返回 false。
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("请求格式错误: %w", err))
+		return false
+	}
+	return true
+}
+
+// pathID 解析路径中的桥接组 ID，失败时写出 400 响应并返回 false。
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("无效的桥接组 ID: %s", r.PathValue("id")))
+		return 0, false
+	}
+	return id, true
+}
+
+// apiDrivers 列出所有平台的驱动、路由策略、启动状态和连接健康状态。
+func (c *Core) apiDrivers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, c.DriverStates())
+}
+
+// apiListBridges 列出所有桥接组。
+func (c *Core) apiListBridges(w http.ResponseWriter, r *http.Request) {
+	groups, err := c.ListBridges()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if groups == nil {
+		groups = []*BridgeGroup{}
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
+
+// apiCreateBridge 将请求中的节点（"平台:房间ID"，至少两个）桥接为一组。
+func (c *Core) apiCreateBridge(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Nodes []string `json:"nodes"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if len(req.Nodes) < 2 {
+		writeError(w, http.StatusBadRequest, errors.New("至少需要两个节点"))
+		return
+	}
+
+	nodes := make([]BridgeNode, len(req.Nodes))
+	for i, s := range req.Nodes {
+		node, err := ParseNode(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		nodes[i] = node
+	}
+
+	group, err := c.LinkNodes(nodes)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, group)
+}
+
+// apiGetBridge 返回指定桥接组。
+func (c *Core) apiGetBridge(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	group, err := c.Store.GetBridgeByID(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if group == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("桥接组不存在: %d", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, group)
+}
+
+// apiDeleteBridge 解散指定桥接组。
+func (c *Core) apiDeleteBridge(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := c.DissolveBridge(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiAttachNode 将请求中的节点加入指定桥接组。
+func (c *Core) apiAttachNode(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Node string `json:"node"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	node, err := ParseNode(req.Node)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	group, err := c.AttachNode(id, node)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, group)
+}
+
+// apiDetachNode 将节点移出其所在的桥接组。
+func (c *Core) apiDetachNode(w http.ResponseWriter, r *http.Request) {
+	node, err := ParseNode(r.PathValue("node"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := c.DetachNode(node); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiListRequests 列出自动建桥请求，可用 ?status= 过滤。
+func (c *Core) apiListRequests(w http.ResponseWriter, r *http.Request) {
+	list, err := c.ListBridgeRequests(r.URL.Query().Get("status"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if list == nil {
+		list = []BridgeRequest{}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// apiHandleRequest 批准（approve）或驳回（reject）自动建桥请求。
+func (c *Core) apiHandleRequest(w http.ResponseWriter, r *http.Request) {
+	node, err := ParseNode(r.PathValue("node"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch r.PathValue("action") {
+	case "approve":
+		group, err := c.ApproveBridge(r.Context(), node)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"status": RequestApproved, "bridge": group})
+	case "reject":
+		if err := c.RejectBridge(node); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"status": RequestRejected})
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("未知操作: %s", r.PathValue("action")))
+	}
+}
+
+// apiFindMapping 查找消息在目标平台的对应 ID。
+// 参数：platform（源平台）、msg_id（源消息 ID）、target（目标平台）。
+func (c *Core) apiFindMapping(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	platform, msgID, target := q.Get("platform"), q.Get("msg_id"), q.Get("target")
+	if platform == "" || msgID == "" || target == "" {
+		writeError(w, http.StatusBadRequest, errors.New("缺少参数 platform、msg_id 或 target"))
+		return
+	}
+	id, ok := c.Store.FindMapping(platform, msgID, target)
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("未找到映射"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"platform": target, "msg_id": id})
+}
+
+// apiQueues 返回出站重试队列和投递序列的积压情况。
+func (c *Core) apiQueues(w http.ResponseWriter, r *http.Request) {
+	outbox, sequencer := c.Router.QueueDepths()
+	writeJSON(w, http.StatusOK, map[string]any{"outbox": outbox, "sequencer": sequencer})
+}
+
+// apiReload 重新加载配置文件。
+func (c *Core) apiReload(w http.ResponseWriter, r *http.Request) {
+	if err := c.Reload(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// apiTestMessage 向指定节点发送一条测试消息。
+func (c *Core) apiTestMessage(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Node string `json:"node"`
+		Text string `json:"text"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	node, err := ParseNode(req.Node)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		req.Text = "Relify 测试消息"
+	}
+
+	results, err := c.Router.SendNotice(r.Context(), node, req.Text)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	ids := make([]string, 0, len(results))
+	for _, res := range results {
+		if res.MsgID != "" {
+			ids = append(ids, res.MsgID)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"msg_ids": ids})
+}
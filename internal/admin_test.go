@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestCore 创建只带存储、不加载驱动的 Core，声明 qq 与 matrix 两个平台。
+func newTestCore(t *testing.T) *Core {
+	t.Helper()
+	config := &Config{
+		Admin: AdminConfig{Listen: "127.0.0.1:0", Token: "secret"},
+		Platforms: map[string]PlatformConfig{
+			"qq":     {Driver: "qq"},
+			"matrix": {Driver: "matrix"},
+		},
+	}
+	c, err := OpenCore(config, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// adminRequest 向管理接口发送请求，auth 为 Authorization 头（为空不设置）。
+func adminRequest(h http.Handler, method, path, body, auth string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminAuth(t *testing.T) {
+	c := newTestCore(t)
+	h := c.adminHandler()
+
+	tests := []struct {
+		name string
+		path string
+		auth string
+		want int
+	}{
+		{"缺少认证头", "/api/bridges", "", http.StatusUnauthorized},
+		{"令牌错误", "/api/bridges", "Bearer wrong", http.StatusUnauthorized},
+		{"缺少 Bearer 前缀", "/api/bridges", "secret", http.StatusUnauthorized},
+		{"令牌正确", "/api/bridges", "Bearer secret", http.StatusOK},
+		{"未知接口同样需要认证", "/api/unknown", "", http.StatusUnauthorized},
+		{"存活探针无需认证", "/healthz", "", http.StatusOK},
+		{"启动未完成时未就绪", "/readyz", "", http.StatusServiceUnavailable},
+		{"指标无需认证", "/metrics", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := adminRequest(h, http.MethodGet, tt.path, "", tt.auth); rec.Code != tt.want {
+				t.Errorf("GET %s 状态码 = %d，期望 %d", tt.path, rec.Code, tt.want)
+			}
+		})
+	}
+
+	c.started.Store(true)
+	if rec := adminRequest(h, http.MethodGet, "/readyz", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("启动完成后 /readyz 状态码 = %d，期望 200", rec.Code)
+	}
+}
+
+func TestAdminBridges(t *testing.T) {
+	c := newTestCore(t)
+	h := c.adminHandler()
+
+	rec := adminRequest(h, http.MethodPost, "/api/bridges", `{"nodes": ["qq:1", "matrix:!a:x"]}`, "Bearer secret")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("创建桥接组状态码 = %d，期望 201: %s", rec.Code, rec.Body)
+	}
+	var group BridgeGroup
+	if err := json.Unmarshal(rec.Body.Bytes(), &group); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.LinkNodes([]BridgeNode{{Platform: "qq", RoomID: "2"}, {Platform: "matrix", RoomID: "!b:x"}}); err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.FormatInt(group.ID, 10)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"节点不足", http.MethodPost, "/api/bridges", `{"nodes": ["qq:3"]}`, http.StatusBadRequest},
+		{"节点格式错误", http.MethodPost, "/api/bridges", `{"nodes": ["qq:3", "matrix"]}`, http.StatusBadRequest},
+		{"请求体格式错误", http.MethodPost, "/api/bridges", `{"nodes": `, http.StatusBadRequest},
+		{"节点分属不同桥接组", http.MethodPost, "/api/bridges", `{"nodes": ["qq:1", "qq:2"]}`, http.StatusConflict},
+		{"查看桥接组", http.MethodGet, "/api/bridges/" + id, "", http.StatusOK},
+		{"桥接组不存在", http.MethodGet, "/api/bridges/1", "", http.StatusNotFound},
+		{"无效的桥接组 ID", http.MethodGet, "/api/bridges/abc", "", http.StatusBadRequest},
+		{"加入节点", http.MethodPost, "/api/bridges/" + id + "/nodes", `{"node": "qq:3"}`, http.StatusOK},
+		{"节点已属于其他组", http.MethodPost, "/api/bridges/" + id + "/nodes", `{"node": "qq:2"}`, http.StatusConflict},
+		{"加入不存在的桥接组", http.MethodPost, "/api/bridges/1/nodes", `{"node": "qq:4"}`, http.StatusConflict},
+		{"移出节点", http.MethodDelete, "/api/nodes/qq:3", "", http.StatusNoContent},
+		{"移出未桥接的节点", http.MethodDelete, "/api/nodes/qq:3", "", http.StatusNotFound},
+		{"移出节点格式错误", http.MethodDelete, "/api/nodes/qq", "", http.StatusBadRequest},
+		{"解散桥接组", http.MethodDelete, "/api/bridges/" + id, "", http.StatusNoContent},
+		{"解散不存在的桥接组", http.MethodDelete, "/api/bridges/" + id, "", http.StatusNotFound},
+		{"未知的请求操作", http.MethodPost, "/api/requests/qq:1/ignore", "", http.StatusNotFound},
+		{"驳回未知平台", http.MethodPost, "/api/requests/irc:1/reject", "", http.StatusBadRequest},
+		{"驳回建桥请求", http.MethodPost, "/api/requests/qq:1/reject", "", http.StatusOK},
+		{"映射缺少参数", http.MethodGet, "/api/mappings?platform=qq", "", http.StatusBadRequest},
+		{"映射不存在", http.MethodGet, "/api/mappings?platform=qq&msg_id=1&target=matrix", "", http.StatusNotFound},
+		{"方法不允许", http.MethodPut, "/api/bridges", "", http.StatusMethodNotAllowed},
+	}
+
+	// 依次执行，后面的用例依赖前面的修改
+	for _, tt := range tests {
+		rec := adminRequest(h, tt.method, tt.path, tt.body, "Bearer secret")
+		if rec.Code != tt.want {
+			t.Errorf("%s: %s %s 状态码 = %d，期望 %d: %s", tt.name, tt.method, tt.path, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestCheckAdminToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		admin AdminConfig
+		want  bool // 是否报告 admin.token
+	}{
+		{"未启用管理接口", AdminConfig{}, false},
+		{"启用且设置令牌", AdminConfig{Listen: "127.0.0.1:6169", Token: "secret"}, false},
+		{"启用但未设置令牌", AdminConfig{Listen: "127.0.0.1:6169"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{LogLevel: "info", Mode: "mesh", RetentDay: 7, AutoBridge: AutoBridgeConfig{Default: "allow"}, Admin: tt.admin}
+			err := config.Check()
+			var got bool
+			var ce *ConfigError
+			if errors.As(err, &ce) {
+				for _, issue := range ce.Issues {
+					got = got || issue.Path == "admin.token"
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("报告 admin.token = %v，期望 %v: %v", got, tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+)
+
+// 节点配置项，保存在 BridgeNode.Config 中。
+const (
+	// nodeMuted 为 true 时该节点既不接收也不转出消息。
+	nodeMuted = "muted"
+	// nodeFormat 是转发到该节点的消息前缀模板，支持 {name}、{id}、{platform} 占位符及 \n 换行。
+	nodeFormat = "format"
+)
+
+// commandHelp 是聊天内指令的帮助文本。
+const commandHelp = `可用指令：
+ping - 检查桥接是否在线
+status - 查看当前房间的桥接状态
+link <平台:房间ID> - 将当前房间与指定房间桥接（仅配置的管理员）
+unlink - 将当前房间移出桥接，之后不再自动建桥（仅配置的管理员）
+mute [平台:房间ID] - 静音节点，默认当前房间（管理员；指定其他房间仅限配置的管理员）
+unmute [平台:房间ID] - 取消静音节点（管理员；指定其他房间仅限配置的管理员）
+format [模板|reset] - 查看或设置转发到当前房间的消息前缀，支持 {name} {id} {platform} 及 \n 换行（管理员）`
+
+// commandFunc 执行一条聊天内指令并返回回复文本。
+type commandFunc func(r *Router, event *Event, args []string) (string, error)
+
+// command 描述一条聊天内指令。
+// admin 指令允许房间管理员执行；operator 指令会改变桥接拓扑，仅限 command.admins 中配置的用户。
+type command struct {
+	admin    bool
+	operator bool
+	run      commandFunc
+}
+
+// commands 是所有可用的聊天内指令。
+var commands = map[string]command{
+	"help":   {run: cmdHelp},
+	"ping":   {run: cmdPing},
+	"status": {run: cmdStatus},
+	"link":   {operator: true, run: cmdLink},
+	"unlink": {operator: true, run: cmdUnlink},
+	"mute":   {admin: true, run: cmdMute(true)},
+	"unmute": {admin: true, run: cmdMute(false)},
+	"format": {admin: true, run: cmdFormat},
+}
+
+// handleCommand 识别并执行聊天内指令。
+// 指令仅从消息的首个文本段识别，识别成功时返回 true，指令本身不会被转发。
+// 回复通过源驱动发送，并与该房间的其他投递任务串行执行。
+func (r *Router) handleCommand(ctx context.Context, event *Event) bool {
+	prefix := r.config.Load().Command.Prefix
+	if prefix == "" || event.Type != TypeMessage || len(event.Segments) == 0 || event.Segments[0].Type != SegText {
+		return false
+	}
+
+	rest, ok := strings.CutPrefix(strings.TrimSpace(event.Segments[0].Text), prefix)
+	if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\n') {
+		return false
+	}
+	args := strings.Fields(rest)
+	if len(args) == 0 {
+		args = []string{"help"}
+	}
+
+	drv, ok := r.registry.GetDriver(event.Platform)
+	if !ok {
+		return true
+	}
+
+	// 事件对象由驱动持有，异步执行前复制一份
+	evt := r.cloneEvent(event)
+	node := BridgeNode{Platform: event.Platform, RoomID: event.RoomID}
+	r.sequencer.Submit(node.Platform+":"+node.RoomID, func() {
+		reply := r.runCommand(evt, args)
+		if _, err := r.sendNotice(ctx, drv, &node, reply); err != nil {
+			slog.Warn("回复聊天指令失败", "platform", node.Platform, "room", node.RoomID, "err", err)
+		}
+	})
+	return true
+}
+
+// runCommand 检查权限并执行指令，返回回复文本。
+func (r *Router) runCommand(event *Event, args []string) string {
+	name := strings.ToLower(args[0])
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Sprintf("未知指令: %s，发送 %s help 查看帮助", name, r.config.Load().Command.Prefix)
+	}
+	if (cmd.admin && !r.isAdmin(event)) || (cmd.operator && !r.isOperator(event)) {
+		return "权限不足"
+	}
+
+	reply, err := cmd.run(r, event, args[1:])
+	if err != nil {
+		return "执行失败: " + err.Error()
+	}
+	slog.Info("执行聊天指令", "platform", event.Platform, "room", event.RoomID, "command", name)
+	return reply
+}
+
+// sendNotice 通过驱动以 Relify 的身份向房间发送一条通知，用于指令回复和测试消息。
+func (r *Router) sendNotice(ctx context.Context, drv Driver, node *BridgeNode, text string) ([]SendResult, error) {
+	notice := &Event{
+		ID:       "relify_" + strconv.FormatInt(time.Now().UnixNano(), 10),
+		Type:     TypeNotice,
+		Time:     time.Now(),
+		Platform: node.Platform,
+		RoomID:   node.RoomID,
+		Sender:   &Sender{ID: "relify", Name: "Relify", Type: SenderBot},
+		Segments: []Segment{{Type: SegText, Text: text}},
+	}
+
+	results, err := drv.Send(ctx, node, notice)
+	if err != nil {
+		return nil, err
+	}
+	// 通知消息可能被平台回报，加入回声缓存避免被当作新消息转发
+	now := time.Now().Unix()
+	for _, res := range results {
+		if res.MsgID != "" {
+			r.echoCache.Set(node.Platform+":"+res.MsgID, now, ttlcache.DefaultTTL)
+		}
+	}
+	return results, nil
+}
+
+// isAdmin 判断事件发送者是否有权执行房间管理指令。
+// 配置的管理员列表优先；其次识别驱动提供的角色信息（QQ 群主/管理员、Matrix 权限等级不低于 50）。
+func (r *Router) isAdmin(event *Event) bool {
+	if event.Sender == nil {
+		return false
+	}
+	if r.isOperator(event) {
+		return true
+	}
+	if role, _ := event.Sender.Role["role"].(string); role == "owner" || role == "admin" {
+		return true
+	}
+	switch pl := event.Sender.Role["power_level"].(type) {
+	case int:
+		return pl >= 50
+	case float64:
+		return pl >= 50
+	}
+	return false
+}
+
+// isOperator 判断事件发送者是否在配置的管理员列表中。
+// 房间内的角色只能证明对当前房间的管理权，无法证明对目标房间的管理权，
+// 因此改变桥接拓扑的指令只认配置的管理员。
+func (r *Router) isOperator(event *Event) bool {
+	return event.Sender != nil && slices.Contains(r.config.Load().Command.Admins, event.Platform+":"+event.Sender.ID)
+}
+
+// commandNode 解析指令参数中的目标节点，缺省为当前房间。
+// 指定其他房间时，该房间必须与当前房间处于同一桥接组。
+func (r *Router) commandNode(event *Event, args []string) (BridgeNode, *BridgeGroup, error) {
+	self := BridgeNode{Platform: event.Platform, RoomID: event.RoomID}
+	group := r.store.GetBridge(self.Platform, self.RoomID)
+	if group == nil {
+		return self, nil, fmt.Errorf("当前房间未桥接")
+	}
+	if len(args) == 0 {
+		return self, group, nil
+	}
+
+	node, err := ParseNode(args[0])
+	if err != nil {
+		return node, nil, err
+	}
+	if g := r.store.GetBridge(node.Platform, node.RoomID); g == nil || g.ID != group.ID {
+		return node, nil, fmt.Errorf("节点不在当前桥接组: %s", args[0])
+	}
+	return node, group, nil
+}
+
+// cmdHelp 返回帮助文本。
+func cmdHelp(r *Router, event *Event, args []string) (string, error) {
+	return commandHelp, nil
+}
+
+// cmdPing 返回在线状态及消息处理延迟。
+func cmdPing(r *Router, event *Event, args []string) (string, error) {
+	if event.Time.IsZero() {
+		return "pong", nil
+	}
+	return fmt.Sprintf("pong（延迟 %dms）", time.Since(event.Time).Milliseconds()), nil
+}
+
+// cmdStatus 返回当前房间所在桥接组的节点列表及状态。
+func cmdStatus(r *Router, event *Event, args []string) (string, error) {
+	group := r.store.GetBridge(event.Platform, event.RoomID)
+	if group == nil {
+		return "当前房间未桥接", nil
+	}
+
+	depths := r.outbox.Depths()
+	var b strings.Builder
+	fmt.Fprintf(&b, "桥接组 #%d", group.ID)
+	for _, node := range group.Nodes {
+		fmt.Fprintf(&b, "\n- %s:%s", node.Platform, node.RoomID)
+		if _, ok := r.registry.GetDriver(node.Platform); !ok {
+			b.WriteString(" [平台不可用]")
+		}
+		if muted, _ := node.Config[nodeMuted].(bool); muted {
+			b.WriteString(" [已静音]")
+		}
+		if n := depths[node.Platform+":"+node.RoomID]; n > 0 {
+			fmt.Fprintf(&b, " [待投递 %d]", n)
+		}
+	}
+	return b.String(), nil
+}
+
+// cmdLink 将当前房间与指定房间桥接。
+func cmdLink(r *Router, event *Event, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("用法: link <平台:房间ID>")
+	}
+	target, err := ParseNode(args[0])
+	if err != nil {
+		return "", err
+	}
+	nodes := []BridgeNode{{Platform: event.Platform, RoomID: event.RoomID}, target}
+	if _, err := validateLink(r.config.Load(), nodes); err != nil {
+		return "", err
+	}
+
+	group, err := r.store.LinkNodes(nodes)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("已桥接到 %s，桥接组 #%d", args[0], group.ID), nil
+}
+
+// cmdUnlink 将当前房间移出桥接组。
+func cmdUnlink(r *Router, event *Event, args []string) (string, error) {
+	if _, err := r.store.DetachNode(event.Platform, event.RoomID); err != nil {
+		return "", err
+	}
+	return "已将当前房间移出桥接", nil
+}
+
+// cmdMute 返回设置节点静音状态的指令。
+func cmdMute(muted bool) commandFunc {
+	return func(r *Router, event *Event, args []string) (string, error) {
+		node, _, err := r.commandNode(event, args)
+		if err != nil {
+			return "", err
+		}
+		// 房间管理员只能管理当前房间，静音组内其他节点须为配置的管理员
+		if (node.Platform != event.Platform || node.RoomID != event.RoomID) && !r.isOperator(event) {
+			return "", fmt.Errorf("仅配置的管理员可以设置其他房间")
+		}
+		var value any
+		if muted {
+			value = true
+		}
+		if _, err := r.store.SetNodeConfig(node.Platform, node.RoomID, nodeMuted, value); err != nil {
+			return "", err
+		}
+		if muted {
+			return fmt.Sprintf("已静音 %s:%s", node.Platform, node.RoomID), nil
+		}
+		return fmt.Sprintf("已取消静音 %s:%s", node.Platform, node.RoomID), nil
+	}
+}
+
+// cmdFormat 查看或设置转发到当前房间的消息前缀模板。
+func cmdFormat(r *Router, event *Event, args []string) (string, error) {
+	node, group, err := r.commandNode(event, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if len(args) == 0 {
+		for _, n := range group.Nodes {
+			if n.Platform == node.Platform && n.RoomID == node.RoomID {
+				if tpl, _ := n.Config[nodeFormat].(string); tpl != "" {
+					return "当前转发格式: " + tpl, nil
+				}
+			}
+		}
+		return "当前使用平台默认转发格式", nil
+	}
+
+	var value any
+	if tpl := strings.Join(args, " "); tpl != "reset" {
+		value = tpl
+	}
+	if _, err := r.store.SetNodeConfig(node.Platform, node.RoomID, nodeFormat, value); err != nil {
+		return "", err
+	}
+	if value == nil {
+		return "已恢复平台默认转发格式", nil
+	}
+	return "已设置转发格式: " + value.(string), nil
+}
+
+// formatEvent 按目标节点的转发格式为消息添加发送者前缀。
+// 返回浅拷贝的新事件，不修改原事件（原事件可能被出站队列重复使用）。
+func formatEvent(event *Event, node *BridgeNode) *Event {
+	tpl, _ := node.Config[nodeFormat].(string)
+	if tpl == "" || event.Sender == nil || (event.Type != TypeMessage && event.Type != TypeEdit) {
+		return event
+	}
+
+	prefix := strings.NewReplacer(
+		"{name}", event.Sender.Name,
+		"{id}", event.Sender.ID,
+		"{platform}", event.Platform,
+		`\n`, "\n",
+	).Replace(tpl)
+	// 聊天客户端通常会去掉指令末尾的空白，模板未以空白结尾时补一个空格
+	if !strings.HasSuffix(prefix, " ") && !strings.HasSuffix(prefix, "\n") {
+		prefix += " "
+	}
+
+	out := *event
+	out.Segments = append([]Segment{{Type: SegText, Text: prefix}}, event.Segments...)
+	return &out
+}
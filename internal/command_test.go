@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestRouter 创建不加载驱动的路由器，指令前缀为 "!relify"，qq:10001 为配置的管理员。
+func newTestRouter(t *testing.T) *Router {
+	t.Helper()
+	config := &Config{Command: CommandConfig{Prefix: "!relify", Admins: []string{"qq:10001"}}}
+	r := NewRouter(config, NewRegistry(), newTestStore(t))
+	t.Cleanup(r.Stop)
+	return r
+}
+
+func TestHandleCommandPrefix(t *testing.T) {
+	r := newTestRouter(t)
+	text := func(s string) []Segment { return []Segment{{Type: SegText, Text: s}} }
+
+	tests := []struct {
+		name string
+		typ  EventType
+		segs []Segment
+		want bool
+	}{
+		{"指令", TypeMessage, text("!relify status"), true},
+		{"只有前缀", TypeMessage, text("!relify"), true},
+		{"前后空白", TypeMessage, text("  !relify ping \n"), true},
+		{"前缀后换行", TypeMessage, text("!relify\nstatus"), true},
+		{"前缀后紧跟字符", TypeMessage, text("!relifyx status"), false},
+		{"前缀不在开头", TypeMessage, text("看看 !relify status"), false},
+		{"普通消息", TypeMessage, text("hello"), false},
+		{"编辑消息", TypeEdit, text("!relify status"), false},
+		{"首段不是文本", TypeMessage, []Segment{{Type: SegImage}, {Type: SegText, Text: "!relify status"}}, false},
+		{"空消息", TypeMessage, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &Event{Type: tt.typ, Platform: "qq", RoomID: "1", Segments: tt.segs}
+			if got := r.handleCommand(context.Background(), event); got != tt.want {
+				t.Errorf("handleCommand = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+
+	r.SetConfig(&Config{})
+	if r.handleCommand(context.Background(), &Event{Type: TypeMessage, Segments: text("!relify status")}) {
+		t.Error("前缀为空时应禁用指令")
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	r := newTestRouter(t)
+
+	tests := []struct {
+		name     string
+		platform string
+		sender   *Sender
+		admin    bool
+		operator bool
+	}{
+		{"无发送者", "qq", nil, false, false},
+		{"普通成员", "qq", &Sender{ID: "10002", Role: map[string]any{"role": "member"}}, false, false},
+		{"QQ 群主", "qq", &Sender{ID: "10002", Role: map[string]any{"role": "owner"}}, true, false},
+		{"QQ 管理员", "qq", &Sender{ID: "10002", Role: map[string]any{"role": "admin"}}, true, false},
+		{"Matrix 权限等级 50", "matrix", &Sender{ID: "@a:x", Role: map[string]any{"power_level": 50}}, true, false},
+		{"Matrix 权限等级 49", "matrix", &Sender{ID: "@a:x", Role: map[string]any{"power_level": 49}}, false, false},
+		{"JSON 解析的权限等级", "matrix", &Sender{ID: "@a:x", Role: map[string]any{"power_level": float64(100)}}, true, false},
+		{"配置的管理员", "qq", &Sender{ID: "10001"}, true, true},
+		{"其他平台的同名用户", "telegram", &Sender{ID: "10001"}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &Event{Platform: tt.platform, Sender: tt.sender}
+			if got := r.isAdmin(event); got != tt.admin {
+				t.Errorf("isAdmin = %v，期望 %v", got, tt.admin)
+			}
+			if got := r.isOperator(event); got != tt.operator {
+				t.Errorf("isOperator = %v，期望 %v", got, tt.operator)
+			}
+		})
+	}
+}
+
+func TestRunCommandMute(t *testing.T) {
+	r := newTestRouter(t)
+	if _, err := r.store.CreateBridge([]BridgeNode{{Platform: "qq", RoomID: "1"}, {Platform: "matrix", RoomID: "!a"}}); err != nil {
+		t.Fatal(err)
+	}
+	roomAdmin := &Sender{ID: "10002", Role: map[string]any{"role": "admin"}}
+	member := &Sender{ID: "10003"}
+	operator := &Sender{ID: "10001"}
+
+	tests := []struct {
+		name   string
+		sender *Sender
+		args   []string
+		want   string // 回复的前缀
+	}{
+		{"普通成员", member, []string{"mute"}, "权限不足"},
+		{"房间管理员静音当前房间", roomAdmin, []string{"mute"}, "已静音 qq:1"},
+		{"房间管理员静音其他房间", roomAdmin, []string{"mute", "matrix:!a"}, "执行失败: 仅配置的管理员"},
+		{"配置的管理员静音其他房间", operator, []string{"mute", "matrix:!a"}, "已静音 matrix:!a"},
+		{"不在同一桥接组", operator, []string{"unmute", "matrix:!b"}, "执行失败: 节点不在当前桥接组"},
+		{"取消静音", roomAdmin, []string{"UNMUTE"}, "已取消静音 qq:1"},
+		{"未知指令", member, []string{"foo"}, "未知指令: foo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &Event{Platform: "qq", RoomID: "1", Sender: tt.sender}
+			if got := r.runCommand(event, tt.args); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("runCommand(%v) = %q，期望以 %q 开头", tt.args, got, tt.want)
+			}
+		})
+	}
+
+	muted := make(map[string]bool)
+	for _, node := range r.store.GetBridge("qq", "1").Nodes {
+		muted[node.Platform+":"+node.RoomID] = isMuted(&node)
+	}
+	if want := map[string]bool{"qq:1": false, "matrix:!a": true}; !reflect.DeepEqual(muted, want) {
+		t.Errorf("静音状态 = %v，期望 %v", muted, want)
+	}
+}
+
+func TestFormatEvent(t *testing.T) {
+	sender := &Sender{ID: "10001", Name: "Alice"}
+	body := []Segment{{Type: SegText, Text: "hi"}}
+
+	tests := []struct {
+		name   string
+		tpl    string
+		event  Event
+		prefix string // 期望添加的前缀，为空表示不修改
+	}{
+		{"未设置模板", "", Event{Type: TypeMessage, Platform: "qq", Sender: sender}, ""},
+		{"占位符", "[{platform}] {name}({id}):", Event{Type: TypeMessage, Platform: "qq", Sender: sender}, "[qq] Alice(10001): "},
+		{"已以空格结尾", "{name}: ", Event{Type: TypeMessage, Sender: sender}, "Alice: "},
+		{"换行", `{name}\n`, Event{Type: TypeMessage, Sender: sender}, "Alice\n"},
+		{"编辑消息", "{name}:", Event{Type: TypeEdit, Sender: sender}, "Alice: "},
+		{"撤回不加前缀", "{name}:", Event{Type: TypeRevoke, Sender: sender}, ""},
+		{"无发送者", "{name}:", Event{Type: TypeMessage}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &BridgeNode{Config: Properties{}}
+			if tt.tpl != "" {
+				node.Config[nodeFormat] = tt.tpl
+			}
+			event := tt.event
+			event.Segments = body
+
+			got := formatEvent(&event, node)
+			want := body
+			if tt.prefix != "" {
+				want = append([]Segment{{Type: SegText, Text: tt.prefix}}, body...)
+			}
+			if !reflect.DeepEqual(got.Segments, want) {
+				t.Errorf("formatEvent 片段 = %#v\n期望 %#v", got.Segments, want)
+			}
+			if len(event.Segments) != 1 {
+				t.Error("formatEvent 修改了原事件")
+			}
+		})
+	}
+}
@@ -1,34 +1,122 @@
 package internal
 
 import (
-	"fmt"
+	"log/slog"
 	"os"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
+// DataDir 是数据目录，由命令行 --data-dir 设置，驱动在其中保存需要持久化的文件。
+var DataDir = "data"
+
+// LogLevel 是全局日志级别，由 log_level 配置项设置，重新加载配置时即时生效。
+var LogLevel = new(slog.LevelVar)
+
+// ParseLogLevel 将 log_level 配置项转换为 slog 日志级别，无法识别时返回 false。
+func ParseLogLevel(s string) (slog.Level, bool) {
+	switch s {
+	case "debug":
+		return slog.LevelDebug, true
+	case "info", "":
+		return slog.LevelInfo, true
+	case "warn":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	}
+	return slog.LevelInfo, false
+}
+
 // LoadConfig 从指定的文件路径读取并解析配置信息。
-// 它首先读取文件内容，然后使用 YAML 解析器将其反序列化为 Config 结构体。
-// 如果文件读取失败或 YAML 格式不正确，将返回错误。
+// 它首先读取文件内容，然后使用 YAML 解析器将其反序列化为 Config 结构体，再依次处理：
+// - 平台驱动配置中的 ${ENV} / ${ENV:-默认值} 环境变量插值。
+// - 平台驱动配置中以 _file 结尾的键，读取文件内容作为去掉后缀的键的值。
+// - RELIFY_* 环境变量对顶层配置项的覆盖。
+// 如果文件读取失败、YAML 格式不正确或引用的环境变量、文件不存在，将返回错误。
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 	config := DefaultConfig()
+	// 平台列表以配置文件为准，不与默认示例平台合并，否则无法通过重新加载移除平台
+	config.Platforms = nil
 	if err := yaml.Unmarshal(data, config); err != nil {
 		return nil, err
 	}
+	config.lines = yamlLines(data)
+
+	for name, pc := range config.Platforms {
+		if err := resolveProperties(pc.Config, "platforms."+name+".config"); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnvOverrides(config); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
 
-// Check 验证当前配置对象的完整性和业务逻辑正确性。
-// 特别是在 Hub 模式下，它会检查指定的中心平台（Hub）是否存在且已启用。
+// Check 验证当前配置对象的完整性和业务逻辑正确性，一次性报告全部问题。
+// 包括日志级别、运行模式与保留天数的取值，启用的平台是否指定了已注册的驱动，
+// 驱动 config 段是否符合驱动注册的配置结构，Hub 模式下指定的中心平台（Hub）是否存在且已启用，
+// 以及自动建桥策略的动作名称和匹配模式、管理接口的访问令牌。
+// 存在问题时返回 *ConfigError，其中每处问题都带有 YAML 路径和行号。
+// 重新加载配置时未通过校验的配置会被拒绝。
 func (c *Config) Check() error {
+	ic := &issueCollector{lines: c.lines}
+	if _, ok := ParseLogLevel(c.LogLevel); !ok {
+		ic.add("log_level", "未知日志级别: %s（可选 debug、info、warn、error）", c.LogLevel)
+	}
+	if c.Mode != "hub" && c.Mode != "mesh" {
+		ic.add("mode", "未知运行模式: %s（可选 hub、mesh）", c.Mode)
+	}
+	if c.RetentDay < 1 {
+		ic.add("retent_day", "必须大于 0")
+	}
+
+	names := make([]string, 0, len(c.Platforms))
+	for name := range c.Platforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pc := c.Platforms[name]
+		if !pc.Enabled {
+			continue
+		}
+		prefix := "platforms." + name
+		if pc.Driver == "" {
+			ic.add(prefix+".driver", "未指定驱动")
+			continue
+		}
+		if _, ok := factories[pc.Driver]; !ok {
+			ic.add(prefix+".driver", "未知驱动: %s", pc.Driver)
+			continue
+		}
+		if schema, ok := schemas[pc.Driver]; ok {
+			validateSchema(ic, prefix+".config", pc.Config, schema)
+		}
+	}
 	if pc, ok := c.Platforms[c.Hub]; c.Mode == "hub" && (!ok || !pc.Enabled) {
-		return fmt.Errorf("中心平台未配置: %s", c.Hub)
+		ic.add("hub", "中心平台未配置或未启用: %s", c.Hub)
 	}
-	return nil
+
+	if _, err := NewBridgePolicy(c.AutoBridge); err != nil {
+		// 策略错误以 "路径: 描述" 的形式给出
+		if path, msg, ok := strings.Cut(err.Error(), ": "); ok {
+			ic.add(path, "%s", msg)
+		} else {
+			ic.add("auto_bridge", "%v", err)
+		}
+	}
+	if c.Admin.Listen != "" && c.Admin.Token == "" {
+		ic.add("admin.token", "启用管理接口时必须设置")
+	}
+	return ic.err()
 }
 
 // SaveConfig 将配置结构体序列化为 YAML 格式并保存到指定路径。
@@ -53,6 +141,12 @@ func DefaultConfig() *Config {
 		Mode:      "hub",
 		Hub:       "matrix",
 		RetentDay: 7, // 默认保留7天数据
+		AutoBridge: AutoBridgeConfig{
+			Default: string(ActionAllow),
+		},
+		Command: CommandConfig{
+			Prefix: "!relify",
+		},
 		Platforms: map[string]PlatformConfig{
 			"qq": {
 				Driver: "qq", Enabled: true,
@@ -68,7 +162,6 @@ func DefaultConfig() *Config {
 					"domain":     "localhost",
 					"appservice": Properties{
 						"id":        "relify",
-						"token":     "relify",
 						"namespace": "relify_",
 						"listen":    "http://localhost:6168",
 					},
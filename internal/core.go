@@ -4,22 +4,36 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"path/filepath"
+	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // DriverFactory 定义了用于创建驱动程序实例的工厂函数签名。
 // 它接收配置属性并返回初始化后的 Driver 接口或错误。
 type DriverFactory func(Properties) (Driver, error)
 
-var factories = make(map[string]DriverFactory)
+var (
+	factories = make(map[string]DriverFactory)
+	schemas   = make(map[string]SchemaFactory)
+)
 
-// RegisterDriver 注册一个驱动程序工厂，使其可以通过配置文件按名称加载。
+// RegisterDriver 注册一个驱动程序工厂及其配置结构，使其可以通过配置文件按名称加载。
+// schema 用于在启动和重新加载前校验平台的 config 段，为 nil 表示不校验。
 // 通常在驱动程序的 init 函数中调用。
-func RegisterDriver(name string, f DriverFactory) { factories[name] = f }
+func RegisterDriver(name string, f DriverFactory, schema SchemaFactory) {
+	factories[name] = f
+	if schema != nil {
+		schemas[name] = schema
+	}
+}
 
 // Registry 管理所有已加载的驱动程序实例及其对应的路由策略。
+// 配置重载时会在运行中增删驱动，因此所有方法都是并发安全的。
 type Registry struct {
+	mu      sync.RWMutex
 	drivers map[string]Driver
 	routes  map[string]RoutePolicy
 }
@@ -33,97 +47,298 @@ func NewRegistry() *Registry {
 }
 
 // Register 将一个已初始化的驱动实例及其名称添加到注册表中。
-func (r *Registry) Register(name string, d Driver) { r.drivers[name] = d }
+func (r *Registry) Register(name string, d Driver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.drivers[name] = d
+}
+
+// Unregister 从注册表中移除指定名称的驱动及其路由策略。
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.drivers, name)
+	delete(r.routes, name)
+}
 
 // GetDriver 根据名称获取已注册的驱动程序实例。
 // 返回驱动实例和是否存在该驱动的布尔值。
 func (r *Registry) GetDriver(name string) (Driver, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	d, ok := r.drivers[name]
 	return d, ok
 }
 
+// SetRoutePolicy 设置指定驱动名称的路由策略。
+func (r *Registry) SetRoutePolicy(name string, p RoutePolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes[name] = p
+}
+
 // GetRoutePolicy 获取指定驱动名称的路由策略。
-func (r *Registry) GetRoutePolicy(name string) RoutePolicy { return r.routes[name] }
+func (r *Registry) GetRoutePolicy(name string) RoutePolicy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.routes[name]
+}
+
+// GetAllDrivers 返回包含所有已注册驱动的映射表副本。
+func (r *Registry) GetAllDrivers() map[string]Driver {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	drivers := make(map[string]Driver, len(r.drivers))
+	for name, d := range r.drivers {
+		drivers[name] = d
+	}
+	return drivers
+}
+
+// PlatformStatus 描述单个平台在启动过程中的最终状态。
+type PlatformStatus string
+
+const (
+	// StatusLoaded 驱动已创建并初始化成功。
+	StatusLoaded PlatformStatus = "loaded"
+	// StatusDisabled 平台在配置中被禁用。
+	StatusDisabled PlatformStatus = "disabled"
+	// StatusUnknownDriver 配置的驱动名称未注册。
+	StatusUnknownDriver PlatformStatus = "unknown_driver"
+	// StatusFactoryError 驱动工厂函数返回错误（通常是配置有误）。
+	StatusFactoryError PlatformStatus = "factory_error"
+	// StatusInitError 驱动的 Init 方法返回错误（通常是连接失败）。
+	StatusInitError PlatformStatus = "init_error"
+)
+
+// PlatformReport 记录单个平台的启动结果。
+type PlatformReport struct {
+	Name   string         `json:"name"`
+	Driver string         `json:"driver"`
+	Status PlatformStatus `json:"status"`
+	Policy RoutePolicy    `json:"policy,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// StartupReport 汇总所有平台的启动结果。
+type StartupReport struct {
+	mu        sync.RWMutex
+	platforms map[string]*PlatformReport
+}
+
+// remove 删除指定平台的启动结果，用于配置重载时移除平台。
+func (r *StartupReport) remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.platforms, name)
+}
 
-// GetAllDrivers 返回包含所有已注册驱动的映射表。
-func (r *Registry) GetAllDrivers() map[string]Driver { return r.drivers }
+// set 记录或覆盖指定平台的启动结果。
+func (r *StartupReport) set(rep PlatformReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.platforms == nil {
+		r.platforms = make(map[string]*PlatformReport)
+	}
+	r.platforms[rep.Name] = &rep
+}
+
+// Get 返回指定平台的启动结果。
+func (r *StartupReport) Get(name string) (PlatformReport, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if rep, ok := r.platforms[name]; ok {
+		return *rep, true
+	}
+	return PlatformReport{}, false
+}
+
+// Platforms 返回按名称排序的全部平台启动结果。
+func (r *StartupReport) Platforms() []PlatformReport {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]PlatformReport, 0, len(r.platforms))
+	for _, rep := range r.platforms {
+		list = append(list, *rep)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
 
 // Core 是应用程序的核心结构体，负责集成配置、路由器、存储层和驱动管理。
 // 它是整个应用生命周期的控制中心。
 type Core struct {
-	Config   *Config
 	Router   *Router
 	Registry *Registry
 	Store    *Store
+	Report   *StartupReport
+
+	// ConfigPath 是配置文件路径，用于重新加载配置。
+	ConfigPath string
+
+	config   atomic.Pointer[Config]
+	mu       sync.Mutex // 保护 stops
+	reloadMu sync.Mutex // 串行化配置重载
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stops    map[string]context.CancelFunc // 每个驱动独立的上下文，用于单独停止
+	admin    *http.Server
+	started  atomic.Bool
 }
 
-// NewCore 根据提供的配置初始化 Core 实例。
+// NewCore 根据提供的配置初始化 Core 实例，数据库保存在 dataDir 目录中。
 // 该过程包括：
 // 1. 初始化 SQLite 存储层。
 // 2. 创建驱动注册表。
-// 3. 初始化消息路由器。
+// 3. 初始化消息路由器并加载自动建桥策略。
 // 4. 根据配置实例化所有启用的驱动程序并注册。
-func NewCore(config *Config) (*Core, error) {
-	store, err := NewStore(filepath.Join("data", "relify.db"), config.RetentDay)
+// 无法实例化的平台会记录在启动报告中，由 Start 根据启动策略统一裁决。
+func NewCore(config *Config, dataDir string) (*Core, error) {
+	policy, err := NewBridgePolicy(config.AutoBridge)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := OpenDataStore(config, dataDir)
 	if err != nil {
 		return nil, err
 	}
 	registry := NewRegistry()
 	router := NewRouter(config, registry, store)
+	router.SetPolicy(policy)
 
 	core := &Core{
-		Config:   config,
 		Router:   router,
 		Registry: registry,
 		Store:    store,
+		Report:   &StartupReport{},
+		stops:    make(map[string]context.CancelFunc),
 	}
+	core.config.Store(config)
 
 	for name, platConf := range config.Platforms {
-		if platConf.Enabled {
-			if create, ok := factories[platConf.Driver]; ok {
-				if driver, err := create(platConf.Config); err == nil {
-					core.Registry.Register(name, driver)
-				}
-			}
-		}
+		core.loadDriver(name, platConf)
 	}
 
 	return core, nil
 }
 
-// Start 并发初始化并启动所有已注册的驱动程序。
-// 它会等待所有驱动的 Init 方法执行完毕，聚合结果并输出日志。
-// 如果有驱动初始化失败，将在日志中记录警告，但不会中断其他驱动的启动。
-func (c *Core) Start(ctx context.Context) error {
-	drivers := c.Registry.GetAllDrivers()
-	count := len(drivers)
+// Config 返回当前生效的配置。配置重载后返回新的配置对象，调用方不应修改返回值。
+func (c *Core) Config() *Config {
+	return c.config.Load()
+}
+
+// loadDriver 根据平台配置实例化驱动并注册，结果记录在启动报告中。
+// 返回是否成功注册。
+func (c *Core) loadDriver(name string, platConf PlatformConfig) bool {
+	rep := PlatformReport{Name: name, Driver: platConf.Driver}
+	if !platConf.Enabled {
+		rep.Status = StatusDisabled
+		c.Report.set(rep)
+		return false
+	}
+
+	create, ok := factories[platConf.Driver]
+	if !ok {
+		rep.Status = StatusUnknownDriver
+		rep.Error = fmt.Sprintf("未知驱动: %s", platConf.Driver)
+		c.Report.set(rep)
+		return false
+	}
 
-	type result struct {
-		key    string
-		name   string
-		policy RoutePolicy
-		err    error
+	driver, err := create(platConf.Config)
+	if err != nil {
+		rep.Status = StatusFactoryError
+		rep.Error = err.Error()
+		c.Report.set(rep)
+		return false
 	}
-	resultChan := make(chan result, count)
+	c.Registry.Register(name, driver)
+	return true
+}
 
-	for key, drv := range drivers {
-		go func(k string, d Driver) {
-			name, policy, err := d.Init(ctx, c.Router)
-			resultChan <- result{k, name, policy, err}
-		}(key, drv)
+// initDriver 以独立的上下文初始化已注册的驱动，结果记录在启动报告中。
+// 初始化失败的驱动会从注册表中移除。
+func (c *Core) initDriver(name string, drv Driver) error {
+	ctx, cancel := context.WithCancel(c.ctx)
+	rep := PlatformReport{Name: name, Driver: c.Config().Platforms[name].Driver}
+
+	driverName, policy, err := drv.Init(ctx, c.Router)
+	if err != nil {
+		cancel()
+		rep.Status = StatusInitError
+		rep.Error = err.Error()
+		c.Report.set(rep)
+		c.Registry.Unregister(name)
+		return err
+	}
+	if driverName != name {
+		// 驱动以自身名称标记事件来源，与配置键不一致时将无法路由
+		slog.Warn("驱动名称与平台配置键不一致", "key", name, "name", driverName)
 	}
 
-	var loaded []string
-	var failed []string
+	c.mu.Lock()
+	c.stops[name] = cancel
+	c.mu.Unlock()
+
+	c.Registry.SetRoutePolicy(name, policy)
+	rep.Status = StatusLoaded
+	rep.Policy = policy
+	c.Report.set(rep)
+	return nil
+}
+
+// stopDriver 停止并注销指定平台的驱动，取消其上下文。
+func (c *Core) stopDriver(ctx context.Context, name string) {
+	drv, ok := c.Registry.GetDriver(name)
+	if !ok {
+		return
+	}
+	c.Registry.Unregister(name)
+
+	c.mu.Lock()
+	cancel := c.stops[name]
+	delete(c.stops, name)
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+
+	if err := drv.Stop(ctx); err != nil {
+		slog.Warn("停止驱动出错", "platform", name, "err", err)
+	}
+}
+
+// Start 并发初始化并启动所有已注册的驱动程序。
+// 它会等待所有驱动的 Init 方法执行完毕，聚合结果并输出启动报告。
+// 初始化失败的驱动会从注册表中移除。以下情况返回错误：
+// - 启用了 fail_fast 且任一平台不可用。
+// - Hub 模式下中心平台不可用。
+// - 没有任何平台可用。
+// 全部就绪后启动管理接口（如已配置）和配置文件监视。
+func (c *Core) Start(ctx context.Context) error {
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	config := c.Config()
+
+	var wg sync.WaitGroup
+	for name, drv := range c.Registry.GetAllDrivers() {
+		wg.Add(1)
+		go func(n string, d Driver) {
+			defer wg.Done()
+			c.initDriver(n, d)
+		}(name, drv)
+	}
+	wg.Wait()
 
-	for i := 0; i < count; i++ {
-		res := <-resultChan
-		if res.err != nil {
-			failed = append(failed, fmt.Sprintf("%s: %v", res.key, res.err))
-			continue
+	var loaded, failed []string
+	for _, rep := range c.Report.Platforms() {
+		switch rep.Status {
+		case StatusLoaded:
+			loaded = append(loaded, fmt.Sprintf("%s(%s)", rep.Name, rep.Policy))
+		case StatusDisabled:
+		default:
+			failed = append(failed, fmt.Sprintf("%s[%s]: %s", rep.Name, rep.Status, rep.Error))
 		}
-		c.Registry.routes[res.key] = res.policy
-		loaded = append(loaded, fmt.Sprintf("%s(%s)", res.key, res.policy))
 	}
 
 	if len(failed) > 0 {
@@ -132,27 +347,58 @@ func (c *Core) Start(ctx context.Context) error {
 		slog.Info("驱动加载完成", "drivers", loaded)
 	}
 
-	return nil
+	if config.FailFast && len(failed) > 0 {
+		return fmt.Errorf("存在不可用的平台: %s", strings.Join(failed, "; "))
+	}
+	if config.Mode == "hub" {
+		if rep, _ := c.Report.Get(config.Hub); rep.Status != StatusLoaded {
+			return fmt.Errorf("中心平台不可用: %s", config.Hub)
+		}
+	}
+	if len(loaded) == 0 {
+		return fmt.Errorf("没有可用的平台")
+	}
+
+	// 驱动就绪后回放上次未投递完成的消息
+	if err := c.Router.Start(); err != nil {
+		return err
+	}
+	c.started.Store(true)
+
+	if c.ConfigPath != "" {
+		go c.watchConfig(c.ctx)
+	}
+	return c.startAdmin()
 }
 
 // Stop 优雅地停止所有服务。
 // 操作顺序：
-// 1. 并发调用所有驱动的 Stop 方法。
-// 2. 停止路由器的后台缓存清理任务。
-// 3. 关闭存储层（保存数据、关闭 DB 连接）。
+// 1. 关闭管理接口。
+// 2. 停止路由器：不再接收新消息，等待已排队的投递和正在进行的重试完成。
+// 3. 停止配置文件监视，并发停止所有驱动。
+// 4. 关闭存储层（保存数据、关闭 DB 连接）。
 func (c *Core) Stop(ctx context.Context) error {
+	c.started.Store(false)
+	if c.admin != nil {
+		c.admin.Shutdown(ctx)
+	}
+
+	// 投递依赖驱动，必须在停止驱动之前排空
+	c.Router.Stop()
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+
 	var wg sync.WaitGroup
-	for _, d := range c.Registry.GetAllDrivers() {
+	for name := range c.Registry.GetAllDrivers() {
 		wg.Add(1)
-		go func(drv Driver) {
+		go func(n string) {
 			defer wg.Done()
-			drv.Stop(ctx)
-		}(d)
+			c.stopDriver(ctx, n)
+		}(name)
 	}
 	wg.Wait()
 
-	// 关闭路由器的缓存清理任务
-	c.Router.Stop()
-
 	return c.Store.Close()
 }
@@ -0,0 +1,109 @@
+package matrix
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseAllowIPs 解析 IP 白名单，支持单个地址和 CIDR 网段
+// 参数:
+//   - list: 白名单条目
+//
+// 返回:
+//   - []netip.Prefix: 解析后的网段
+//   - error: 第一个无法解析的条目
+func parseAllowIPs(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 IP 或网段: %s", s)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// guard 包装 AppService 监听端口的 HTTP 处理器，在交给 mautrix 或媒体代理处理前校验来源
+// 配置了 allow_ips 时仅接受白名单内地址的请求（包括媒体代理）；/_matrix/app/ 下的请求（事件推送、用户与别名查询、ping）
+// 必须携带 hs_token，兼容旧版 Homeserver 使用的 access_token 查询参数
+// 被拒绝的请求记录错误日志并计入 relify_matrix_rejected_requests_total
+// 参数:
+//   - next: 监听端口的路由
+//
+// 返回:
+//   - http.Handler: 包装后的处理器
+func (m *Matrix) guard(next http.Handler) http.Handler {
+	token := []byte(m.as.Registration.ServerToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(m.allowIPs) > 0 && !m.ipAllowed(r.RemoteAddr) {
+			m.reject(w, r, "ip", http.StatusForbidden, "M_FORBIDDEN", "来源地址不在白名单中")
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, "/_matrix/app/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			got = r.URL.Query().Get("access_token")
+		}
+		switch {
+		case got == "":
+			m.reject(w, r, "missing_token", http.StatusUnauthorized, "M_MISSING_TOKEN", "缺少 hs_token")
+			return
+		case subtle.ConstantTimeCompare([]byte(got), token) != 1:
+			m.reject(w, r, "invalid_token", http.StatusForbidden, "M_UNKNOWN_TOKEN", "hs_token 不匹配")
+			return
+		}
+		// mautrix 仅从 Authorization 头读取令牌
+		r.Header.Set("Authorization", "Bearer "+got)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ipAllowed 检查请求来源地址是否在白名单中
+// 参数:
+//   - remoteAddr: 请求的 RemoteAddr（host:port）
+func (m *Matrix) ipAllowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range m.allowIPs {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// reject 记录并拒绝来自 Homeserver 方向的请求，按 Matrix 错误格式响应
+func (m *Matrix) reject(w http.ResponseWriter, r *http.Request, reason string, status int, errcode, msg string) {
+	rejectedRequests.WithLabelValues(reason).Inc()
+	slog.Error("Matrix 拒绝 AppService 请求",
+		"reason", msg,
+		"remote", r.RemoteAddr,
+		"method", r.Method,
+		"path", r.URL.Path,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"errcode": errcode, "error": msg})
+}
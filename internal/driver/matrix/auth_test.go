@@ -0,0 +1,91 @@
+package matrix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"maunium.net/go/mautrix/appservice"
+)
+
+func TestGuard(t *testing.T) {
+	allowIPs, err := parseAllowIPs([]string{"10.0.0.0/8", "192.168.1.5", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		allow    bool // 是否启用 IP 白名单
+		remote   string
+		path     string
+		header   string
+		want     int
+		wantAuth string // 交给下游时的 Authorization 头
+	}{
+		{name: "Bearer 令牌", path: "/_matrix/app/v1/ping", header: "Bearer hs", want: http.StatusOK, wantAuth: "Bearer hs"},
+		{name: "查询参数令牌", path: "/_matrix/app/v1/transactions/1?access_token=hs", want: http.StatusOK, wantAuth: "Bearer hs"},
+		{name: "请求头优先于查询参数", path: "/_matrix/app/v1/ping?access_token=hs", header: "Bearer bad", want: http.StatusForbidden},
+		{name: "缺少令牌", path: "/_matrix/app/v1/ping", want: http.StatusUnauthorized},
+		{name: "非 Bearer 认证视为缺少令牌", path: "/_matrix/app/v1/ping", header: "Basic hs", want: http.StatusUnauthorized},
+		{name: "令牌错误", path: "/_matrix/app/v1/ping", header: "Bearer bad", want: http.StatusForbidden},
+		{name: "媒体代理不校验令牌", path: mediaPath + "x/y/sig", want: http.StatusOK},
+		{name: "白名单网段", allow: true, remote: "10.1.2.3:1234", path: "/_matrix/app/v1/ping", header: "Bearer hs", want: http.StatusOK, wantAuth: "Bearer hs"},
+		{name: "白名单单个地址", allow: true, remote: "192.168.1.5:1234", path: mediaPath + "x", want: http.StatusOK},
+		{name: "IPv4 映射地址", allow: true, remote: "[::ffff:10.0.0.1]:1234", path: mediaPath + "x", want: http.StatusOK},
+		{name: "IPv6 地址", allow: true, remote: "[::1]:1234", path: mediaPath + "x", want: http.StatusOK},
+		{name: "不在白名单", allow: true, remote: "192.168.1.6:1234", path: "/_matrix/app/v1/ping", header: "Bearer hs", want: http.StatusForbidden},
+		{name: "白名单同样限制媒体代理", allow: true, remote: "8.8.8.8:1234", path: mediaPath + "x", want: http.StatusForbidden},
+		{name: "无法解析的来源地址", allow: true, remote: "unknown", path: mediaPath + "x", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Matrix{as: &appservice.AppService{Registration: &appservice.Registration{ServerToken: "hs"}}}
+			if tt.allow {
+				m.allowIPs = allowIPs
+			}
+
+			var gotAuth string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAuth = r.Header.Get("Authorization")
+			})
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.remote != "" {
+				req.RemoteAddr = tt.remote
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			m.guard(next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("状态码 = %d，期望 %d", rec.Code, tt.want)
+			}
+			if gotAuth != tt.wantAuth {
+				t.Errorf("Authorization = %q，期望 %q", gotAuth, tt.wantAuth)
+			}
+		})
+	}
+}
+
+func TestParseAllowIPs(t *testing.T) {
+	got, err := parseAllowIPs([]string{"10.1.2.3/8", "192.168.1.5", "fe80::/10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.5/32", "fe80::/10"}
+	for i, p := range got {
+		if p.String() != want[i] {
+			t.Errorf("parseAllowIPs[%d] = %s，期望 %s", i, p, want[i])
+		}
+	}
+
+	if _, err := parseAllowIPs([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("无效网段应返回错误")
+	}
+	if _, err := parseAllowIPs([]string{"example.org"}); err == nil {
+		t.Error("主机名应返回错误")
+	}
+}
@@ -3,13 +3,14 @@ package matrix
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	"Relify/internal"
@@ -22,29 +23,99 @@ import (
 
 // AppServiceConfig 定义 Matrix AppService 的配置
 type AppServiceConfig struct {
-	ID        string `json:"id" yaml:"id"`               // AppService 唯一标识符
-	Token     string `json:"token" yaml:"token"`         // 鉴权令牌
-	Namespace string `json:"namespace" yaml:"namespace"` // 用户和房间命名空间前缀
-	Listen    string `json:"listen" yaml:"listen"`       // HTTP 监听地址
+	ID           string   `json:"id" yaml:"id"`                     // AppService 唯一标识符
+	Token        string   `json:"token" yaml:"token"`               // 旧版鉴权令牌，同时作为 as_token 和 hs_token
+	ASToken      string   `json:"as_token" yaml:"as_token"`         // AppService 调用 Homeserver 的令牌（留空自动生成）
+	HSToken      string   `json:"hs_token" yaml:"hs_token"`         // Homeserver 推送事件的令牌（留空自动生成）
+	Namespace    string   `json:"namespace" yaml:"namespace"`       // 用户和房间命名空间前缀
+	Listen       string   `json:"listen" yaml:"listen"`             // HTTP 监听地址
+	Registration string   `json:"registration" yaml:"registration"` // 注册文件路径（默认为数据目录下的 registration.yaml）
+	AllowIPs     []string `json:"allow_ips" yaml:"allow_ips"`       // 允许访问监听端口的地址或网段，包括 Homeserver 和获取媒体的其他平台（留空不限制）
+}
+
+// EncryptionConfig 定义端到端加密的配置
+type EncryptionConfig struct {
+	Enabled   bool   `json:"enabled" yaml:"enabled"`       // 是否启用端到端加密支持
+	Default   bool   `json:"default" yaml:"default"`       // 所有新建房间默认启用加密
+	PickleKey string `json:"pickle_key" yaml:"pickle_key"` // 加密存储中密钥的保护口令（默认由 AppService ID 派生，设置后不可更改）
+	Store     string `json:"store" yaml:"store"`           // 加密存储数据库路径（默认为数据目录下的 matrix-crypto.db）
+}
+
+// MediaConfig 定义媒体代理的配置
+// 其他平台通过代理链接获取 Matrix 媒体，代理使用认证媒体接口从 Homeserver 下载
+type MediaConfig struct {
+	PublicURL string `json:"public_url" yaml:"public_url"` // 其他平台访问媒体代理的地址（默认为 AppService 监听地址）
+	TTL       int    `json:"ttl" yaml:"ttl"`               // 代理链接的有效期，单位秒（默认 3600）；有效期内持有链接者均可下载，无需其他认证
 }
 
 // Config 定义 Matrix 适配器的完整配置
 type Config struct {
-	ServerURL    string           `json:"server_url" yaml:"server_url"`       // Matrix 服务器地址
-	Domain       string           `json:"domain" yaml:"domain"`               // Matrix 域名
-	ServerDomain string           `json:"server_domain" yaml:"server_domain"` // 服务器域名（用于媒体下载）
-	AppService   AppServiceConfig `json:"appservice" yaml:"appservice"`       // AppService 配置
-	AutoInvite   string           `json:"auto_invite" yaml:"auto_invite"`     // 自动邀请的用户 ID（中心模式）
+	ServerURL  string           `json:"server_url" yaml:"server_url"`   // Matrix 服务器地址
+	Domain     string           `json:"domain" yaml:"domain"`           // Matrix 域名
+	AppService AppServiceConfig `json:"appservice" yaml:"appservice"`   // AppService 配置
+	AutoInvite string           `json:"auto_invite" yaml:"auto_invite"` // 自动邀请到新建房间的用户 ID
+	Encryption EncryptionConfig `json:"encryption" yaml:"encryption"`   // 端到端加密配置
+	Media      MediaConfig      `json:"media" yaml:"media"`             // 媒体代理配置
+
+	ServerDomain string `json:"server_domain" yaml:"server_domain"` // 已废弃：媒体改由 media.public_url 代理，设置后仅输出警告
+}
+
+// errNoCrypto 表示当前构建未包含端到端加密支持
+var errNoCrypto = errors.New("当前版本未编译端到端加密支持（需使用 goolm 构建标签）")
+
+// Validate 校验 Matrix 驱动配置
+// 参数:
+//   - report: 问题报告函数
+func (c *Config) Validate(report func(path, msg string)) {
+	if c.ServerURL == "" {
+		report("server_url", "必须设置 Matrix 服务器地址")
+	} else if u, err := url.Parse(c.ServerURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		report("server_url", fmt.Sprintf("无效的服务器地址: %s", c.ServerURL))
+	}
+	if c.Domain == "" {
+		report("domain", "必须设置 Matrix 域名")
+	}
+	if c.AppService.ID == "" {
+		report("appservice.id", "必须设置 AppService ID")
+	}
+	if c.AppService.ASToken != "" && c.AppService.ASToken == c.AppService.HSToken {
+		report("appservice.hs_token", "不能与 as_token 相同")
+	}
+	if _, err := parseAllowIPs(c.AppService.AllowIPs); err != nil {
+		report("appservice.allow_ips", err.Error())
+	}
+	if c.AppService.Listen == "" {
+		report("appservice.listen", "必须设置 AppService 监听地址")
+	} else if _, err := url.Parse(c.AppService.Listen); err != nil {
+		report("appservice.listen", fmt.Sprintf("无效的监听地址: %s", c.AppService.Listen))
+	}
+	if c.Media.PublicURL != "" {
+		if u, err := url.Parse(c.Media.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			report("media.public_url", fmt.Sprintf("无效的媒体代理地址: %s", c.Media.PublicURL))
+		}
+	}
+	if c.Media.TTL < 0 {
+		report("media.ttl", "不能为负数")
+	}
+	if c.AutoInvite != "" && !strings.HasPrefix(c.AutoInvite, "@") {
+		report("auto_invite", fmt.Sprintf("无效的用户 ID: %s", c.AutoInvite))
+	}
+	if c.Encryption.Default && !c.Encryption.Enabled {
+		report("encryption.default", "需要同时设置 encryption.enabled")
+	}
+	if c.Encryption.Enabled && !cryptoAvailable {
+		report("encryption.enabled", errNoCrypto.Error())
+	}
 }
 
-// parseConfig 解析 Props 为 Config 结构
+// parseConfig 解析 Properties 为 Config 结构
 // 参数:
 //   - p: 配置属性映射
 //
 // 返回:
 //   - *Config: 解析后的配置
 //   - error: 解析错误
-func parseConfig(p internal.Props) (*Config, error) {
+func parseConfig(p internal.Properties) (*Config, error) {
 	b, _ := json.Marshal(p)
 	var c Config
 	if err := json.Unmarshal(b, &c); err != nil {
@@ -54,49 +125,46 @@ func parseConfig(p internal.Props) (*Config, error) {
 	if c.AppService.Namespace == "" {
 		c.AppService.Namespace = "relify_"
 	}
-	// 如果未指定服务器域名，使用主域名
-	if c.ServerDomain == "" {
-		c.ServerDomain = c.Domain
+	if c.ServerDomain != "" {
+		slog.Warn("Matrix 配置项 server_domain 已废弃且不再生效，媒体通过 media.public_url 代理", "server_domain", c.ServerDomain)
+	}
+	// 如果未指定媒体代理地址，使用 AppService 监听地址
+	if c.Media.PublicURL == "" {
+		c.Media.PublicURL = c.AppService.Listen
+	}
+	if c.AppService.Registration == "" {
+		c.AppService.Registration = filepath.Join(internal.DataDir, "registration.yaml")
+	}
+	if c.Encryption.PickleKey == "" {
+		c.Encryption.PickleKey = "relify:" + c.AppService.ID
+	}
+	if c.Encryption.Store == "" {
+		c.Encryption.Store = filepath.Join(internal.DataDir, "matrix-crypto.db")
 	}
 	return &c, nil
 }
 
 // initClient 初始化 Matrix AppService 客户端
-// 配置 AppService 注册信息、命名空间和状态存储
+// 配置 AppService 注册信息和命名空间，状态存储在 Init 时接入核心数据库
 // 返回:
 //   - error: 初始化错误
 func (m *Matrix) initClient() error {
+	reg, err := ensureRegistration(m.cfg)
+	if err != nil {
+		return err
+	}
+
 	as := appservice.Create()
 	as.HomeserverDomain = m.cfg.Domain
-	// 配置 AppService 注册信息
-	as.Registration = &appservice.Registration{
-		ID:              m.cfg.AppService.ID,
-		URL:             m.cfg.AppService.Listen,
-		AppToken:        m.cfg.AppService.Token, // AppService 到 Homeserver 的令牌
-		ServerToken:     m.cfg.AppService.Token, // Homeserver 到 AppService 的令牌
-		SenderLocalpart: "relify",               // Bot 用户的本地部分
-		Namespaces: appservice.Namespaces{
-			// 独占用户命名空间（用于 Ghost 用户）
-			UserIDs: appservice.NamespaceList{{Exclusive: true, Regex: fmt.Sprintf("@%s.*", m.cfg.AppService.Namespace)}},
-			// 独占房间别名命名空间
-			RoomAliases: appservice.NamespaceList{{Exclusive: true, Regex: fmt.Sprintf("#%s.*:%s", m.cfg.AppService.Namespace, m.cfg.Domain)}},
-		},
-	}
+	as.Registration = reg
 
 	// 设置 Homeserver URL
 	if err := as.SetHomeserverURL(m.cfg.ServerURL); err != nil {
 		return err
 	}
 
-	// 使用自定义状态存储
-	as.StateStore = &AppServiceStateStore{
-		StateStore:    mautrix.NewMemoryStateStore(), // 基础内存存储
-		registrations: sync.Map{},                    // Ghost 用户注册状态
-		joinRules:     sync.Map{},                    // 房间加入规则缓存
-	}
-
 	m.as = as
-	m.botUserID = id.NewUserID("relify", m.cfg.Domain) // Bot 用户完整 ID
+	m.botUserID = id.NewUserID(botLocalpart, m.cfg.Domain) // Bot 用户完整 ID
 
 	return nil
 }
@@ -119,7 +187,7 @@ func (m *Matrix) startServe(ctx context.Context) error {
 			select {
 			case evt := <-m.as.Events:
 				if evt != nil {
-					m.processEvent(evt) // 处理 Matrix 事件
+					m.processEvent(ctx, evt) // 处理 Matrix 事件
 				}
 			case <-ctx.Done():
 				slog.Info("Matrix 事件处理协程退出")
@@ -129,26 +197,123 @@ func (m *Matrix) startServe(ctx context.Context) error {
 	}()
 
 	// 启动 HTTP 服务监听 Homeserver 的事件推送
-	// 启动 HTTP 服务监听 Homeserver 的事件推送
+	// 来源地址白名单作用于整个端口；媒体代理由链接签名保护，不校验 hs_token
+	addr := extractPort(m.cfg.AppService.Listen)
+	mux := http.NewServeMux()
+	mux.HandleFunc(mediaPath, m.serveMedia)
+	mux.Handle("/", m.as.Router)
+	m.server = &http.Server{Addr: addr, Handler: m.guard(mux)}
 	go func() {
-		addr := extractPort(m.cfg.AppService.Listen)
 		slog.Info("Matrix HTTP 服务启动", "addr", addr)
-		if err := http.ListenAndServe(addr, m.as.Router); err != nil {
+		if err := m.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			slog.Error("Matrix HTTP 服务错误", "error", err)
+			m.health.SetConnected(false, err)
 		}
 	}()
 
-	// 延迟确保 Bot 用户已注册
-	go func() {
-		time.Sleep(2 * time.Second)
-		if err := m.as.BotIntent().EnsureRegistered(context.Background()); err != nil {
-			slog.Warn("Matrix Bot 注册失败", "error", err)
+	// 定期探测 Homeserver 是否可达
+	go m.probeHomeserver(ctx)
+
+	// 注册 Bot 用户并初始化端到端加密
+	go m.startBot(ctx)
+
+	return nil
+}
+
+// cryptoRetryInterval 是端到端加密初始化失败后的重试间隔
+const cryptoRetryInterval = time.Minute
+
+// cryptoWaitTimeout 是创建加密房间时等待加密初始化完成的最长时间
+const cryptoWaitTimeout = 30 * time.Second
+
+// startBot 注册 Bot 用户，启用加密时初始化端到端加密
+// 加密初始化失败时定期重试，期间驱动报告为未连接，需要加密的房间拒绝创建
+// 参数:
+//   - ctx: 上下文
+func (m *Matrix) startBot(ctx context.Context) {
+	if err := m.as.BotIntent().EnsureRegistered(ctx); err != nil {
+		slog.Warn("Matrix Bot 注册失败", "error", err)
+	} else {
+		slog.Info("Matrix Bot 已注册", "user_id", m.botUserID)
+	}
+
+	if !m.cfg.Encryption.Enabled {
+		close(m.cryptoRun)
+		return
+	}
+	for first := true; ; first = false {
+		err := m.startCrypto(ctx)
+		if err == nil {
+			m.cryptoErr.Store(nil)
 		} else {
-			slog.Info("Matrix Bot 已注册", "user_id", m.botUserID)
+			slog.Error("Matrix 端到端加密初始化失败", "error", err, "retry_in", cryptoRetryInterval)
+			m.cryptoErr.Store(&err)
+			m.health.SetConnected(false, err)
 		}
-	}()
+		if first {
+			close(m.cryptoRun)
+		}
+		if err == nil {
+			return
+		}
+		select {
+		case <-time.After(cryptoRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-	return nil
+// waitCrypto 等待端到端加密就绪，用于创建必须加密的房间
+// 参数:
+//   - ctx: 上下文
+//
+// 返回:
+//   - error: 超时或初始化失败时返回错误
+func (m *Matrix) waitCrypto(ctx context.Context) error {
+	if m.crypto.Load() == nil {
+		timer := time.NewTimer(cryptoWaitTimeout)
+		defer timer.Stop()
+		select {
+		case <-m.cryptoRun:
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if m.crypto.Load() != nil {
+		return nil
+	}
+	if err := m.cryptoErr.Load(); err != nil {
+		return fmt.Errorf("端到端加密未就绪: %w", *err)
+	}
+	return errors.New("端到端加密未就绪")
+}
+
+// probeHomeserver 定期请求 Homeserver 的版本接口以更新连接状态
+// 参数:
+//   - ctx: 上下文
+func (m *Matrix) probeHomeserver(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		_, err := m.as.BotClient().Versions(probeCtx)
+		cancel()
+		if err != nil {
+			slog.Warn("Matrix Homeserver 不可达", "error", err)
+		} else if cryptoErr := m.cryptoErr.Load(); cryptoErr != nil {
+			err = *cryptoErr // 启用了加密但初始化失败，加密房间无法收发
+		}
+		m.health.SetConnected(err == nil, err)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
 // stopServe 停止 AppService 服务
@@ -158,7 +323,17 @@ func (m *Matrix) startServe(ctx context.Context) error {
 // 返回:
 //   - error: 停止错误
 func (m *Matrix) stopServe(ctx context.Context) error {
-	return nil
+	// 事件处理协程和探测协程随 Init 的上下文退出，这里关闭 HTTP 监听、
+	// 等待仍在排队的加密事件处理完毕后再关闭加密同步
+	var err error
+	if m.server != nil {
+		err = m.server.Shutdown(ctx)
+	}
+	m.waitPending(ctx)
+	if c := m.crypto.Swap(nil); c != nil {
+		c.close()
+	}
+	return err
 }
 
 // createRoom 创建新的 Matrix 房间
@@ -169,33 +344,17 @@ func (m *Matrix) stopServe(ctx context.Context) error {
 // 返回:
 //   - string: 创建的房间 ID
 //   - error: 创建错误
-func (m *Matrix) createRoom(ctx context.Context, info *internal.Info) (string, error) {
+func (m *Matrix) createRoom(ctx context.Context, info *internal.RoomInfo) (string, error) {
 	slog.Info("Matrix 创建房间",
 		"name", info.Name,
 		"topic", info.Topic,
 		"avatar", info.Avatar,
 	)
 
-	// 根据运行模式设置房间可见性
-	visibility := "private"
-	if m.router != nil && m.router.Mode() == "peer" {
-		visibility = "public" // 对等模式下创建公开房间
-	}
-
-	slog.Debug("Matrix 房间配置",
-		"visibility", visibility,
-		"mode", func() string {
-			if m.router != nil {
-				return m.router.Mode()
-			}
-			return "unknown"
-		}(),
-	)
-
 	req := &mautrix.ReqCreateRoom{
 		Name:            info.Name,
 		Topic:           info.Topic,
-		Visibility:      visibility,
+		Visibility:      "private",
 		CreationContent: map[string]any{"m.federate": true}, // 启用联邦
 	}
 
@@ -210,6 +369,24 @@ func (m *Matrix) createRoom(ctx context.Context, info *internal.Info) (string, e
 		}
 	}
 
+	// 启用端到端加密；加密未就绪时拒绝创建，避免留下永久的明文镜像房间，由下一条消息重新建桥
+	if info.Encrypted && !m.cfg.Encryption.Enabled {
+		slog.Warn("Matrix 未启用端到端加密，房间将以明文创建", "name", info.Name)
+	} else if info.Encrypted || m.cfg.Encryption.Default {
+		if err := m.waitCrypto(ctx); err != nil {
+			slog.Error("Matrix 无法创建加密房间", "name", info.Name, "error", err)
+			return "", err
+		}
+		stateKey := ""
+		req.InitialState = append(req.InitialState, &event.Event{
+			Type:     event.StateEncryption,
+			StateKey: &stateKey,
+			Content: event.Content{
+				Parsed: &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1},
+			},
+		})
+	}
+
 	// 生成房间别名（使用命名空间前缀）
 	safeName := strings.ReplaceAll(strings.ToLower(info.Name), " ", "_")
 	req.RoomAliasName = m.cfg.AppService.Namespace + safeName
@@ -240,13 +417,8 @@ func (m *Matrix) createRoom(ctx context.Context, info *internal.Info) (string, e
 		"name", info.Name,
 	)
 
-	// 在中心模式下自动邀请指定用户
-	if m.router != nil && m.router.Mode() == "hub" && m.cfg.AutoInvite != "" {
-		slog.Debug("Matrix 中心模式，准备邀请用户",
-			"room_id", resp.RoomID,
-			"user", m.cfg.AutoInvite,
-		)
-
+	// 自动邀请指定用户
+	if m.cfg.AutoInvite != "" {
 		_, err := m.as.BotIntent().InviteUser(ctx, resp.RoomID, &mautrix.ReqInviteUser{
 			UserID: id.UserID(m.cfg.AutoInvite),
 		})
@@ -262,16 +434,6 @@ func (m *Matrix) createRoom(ctx context.Context, info *internal.Info) (string, e
 				"user", m.cfg.AutoInvite,
 			)
 		}
-	} else {
-		slog.Debug("Matrix 跳过用户邀请",
-			"mode", func() string {
-				if m.router != nil {
-					return m.router.Mode()
-				}
-				return "unknown"
-			}(),
-			"auto_invite", m.cfg.AutoInvite,
-		)
 	}
 
 	return resp.RoomID.String(), nil
@@ -288,8 +450,8 @@ func (m *Matrix) createRoom(ctx context.Context, info *internal.Info) (string, e
 func (m *Matrix) setRoomAvatar(ctx context.Context, req *mautrix.ReqCreateRoom, avatarURL string) error {
 	slog.Debug("Matrix 开始设置房间头像", "avatar_url", avatarURL)
 
-	// 上传头像到 Matrix 媒体仓库
-	mxc, err := m.uploadMedia(ctx, m.as.BotIntent(), avatarURL, "")
+	// 上传头像到 Matrix 媒体仓库（内容相同的头像只上传一次）
+	mxc, _, err := m.uploadAvatar(ctx, m.as.BotIntent(), avatarURL)
 	if err != nil {
 		slog.Error("Matrix 上传房间头像失败",
 			"avatar_url", avatarURL,
@@ -352,7 +514,59 @@ func (m *Matrix) uploadMedia(ctx context.Context, intent *appservice.IntentAPI,
 		return urlStr, nil
 	}
 
-	// 下载媒体文件
+	data, mimeType, err := m.downloadMedia(ctx, urlStr, mimeType)
+	if err != nil {
+		return "", err
+	}
+	return m.uploadBytes(ctx, intent, data, mimeType)
+}
+
+// uploadAvatar 下载并上传头像到 Matrix，内容相同的头像只上传一次
+// 参数:
+//   - ctx: 上下文
+//   - intent: Intent API 实例
+//   - urlStr: 头像 URL
+//
+// 返回:
+//   - string: MXC URI
+//   - string: 头像内容摘要（已是 MXC URI 时为空）
+//   - error: 下载或上传错误
+func (m *Matrix) uploadAvatar(ctx context.Context, intent *appservice.IntentAPI, urlStr string) (string, string, error) {
+	if strings.HasPrefix(urlStr, "mxc://") {
+		return urlStr, "", nil
+	}
+
+	data, mimeType, err := m.downloadMedia(ctx, urlStr, "image/jpeg")
+	if err != nil {
+		return "", "", err
+	}
+	hash := contentHash(data)
+	if mxc, err := m.store.GetMedia(ctx, hash); err == nil && mxc != "" {
+		slog.Debug("Matrix 头像已上传过，直接使用", "mxc", mxc, "hash", hash)
+		return mxc, hash, nil
+	}
+
+	mxc, err := m.uploadBytes(ctx, intent, data, mimeType)
+	if err != nil {
+		return "", "", err
+	}
+	if err := m.store.SetMedia(ctx, hash, mxc); err != nil {
+		slog.Warn("Matrix 保存头像摘要失败", "mxc", mxc, "error", err)
+	}
+	return mxc, hash, nil
+}
+
+// downloadMedia 下载媒体文件
+// 参数:
+//   - ctx: 上下文
+//   - urlStr: 源媒体 URL
+//   - mimeType: MIME 类型（为空时使用响应头中的类型）
+//
+// 返回:
+//   - []byte: 文件内容
+//   - string: MIME 类型
+//   - error: 下载错误
+func (m *Matrix) downloadMedia(ctx context.Context, urlStr, mimeType string) ([]byte, string, error) {
 	downCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
@@ -362,7 +576,7 @@ func (m *Matrix) uploadMedia(ctx context.Context, intent *appservice.IntentAPI,
 			"url", urlStr,
 			"error", err,
 		)
-		return "", err
+		return nil, "", err
 	}
 
 	resp, err := http.DefaultClient.Do(req)
@@ -371,7 +585,7 @@ func (m *Matrix) uploadMedia(ctx context.Context, intent *appservice.IntentAPI,
 			"url", urlStr,
 			"error", err,
 		)
-		return "", err
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
@@ -381,7 +595,7 @@ func (m *Matrix) uploadMedia(ctx context.Context, intent *appservice.IntentAPI,
 			"status_code", resp.StatusCode,
 			"status", resp.Status,
 		)
-		return "", fmt.Errorf("下载状态码 %d", resp.StatusCode)
+		return nil, "", fmt.Errorf("下载状态码 %d", resp.StatusCode)
 	}
 
 	slog.Debug("Matrix 媒体下载成功",
@@ -397,7 +611,7 @@ func (m *Matrix) uploadMedia(ctx context.Context, intent *appservice.IntentAPI,
 			"url", urlStr,
 			"error", err,
 		)
-		return "", err
+		return nil, "", err
 	}
 
 	// 检测 MIME 类型
@@ -407,8 +621,20 @@ func (m *Matrix) uploadMedia(ctx context.Context, intent *appservice.IntentAPI,
 			mimeType = "application/octet-stream"
 		}
 	}
+	return data, mimeType, nil
+}
 
-	// 上传到 Matrix 媒体仓库
+// uploadBytes 上传文件内容到 Matrix 媒体仓库
+// 参数:
+//   - ctx: 上下文
+//   - intent: Intent API 实例
+//   - data: 文件内容
+//   - mimeType: MIME 类型
+//
+// 返回:
+//   - string: MXC URI
+//   - error: 上传错误
+func (m *Matrix) uploadBytes(ctx context.Context, intent *appservice.IntentAPI, data []byte, mimeType string) (string, error) {
 	slog.Debug("Matrix 开始上传到媒体仓库",
 		"size", len(data),
 		"mime_type", mimeType,
@@ -418,7 +644,6 @@ func (m *Matrix) uploadMedia(ctx context.Context, intent *appservice.IntentAPI,
 	uploadResp, err := intent.UploadBytes(ctx, data, mimeType)
 	if err != nil {
 		slog.Error("Matrix 上传到媒体仓库失败",
-			"url", urlStr,
 			"size", len(data),
 			"mime_type", mimeType,
 			"error", err,
@@ -426,9 +651,10 @@ func (m *Matrix) uploadMedia(ctx context.Context, intent *appservice.IntentAPI,
 		return "", err
 	}
 
+	uploadSize.Observe(float64(len(data)))
+
 	mxc := string(uploadResp.ContentURI.CUString())
 	slog.Debug("Matrix 媒体上传成功",
-		"original_url", urlStr,
 		"mxc", mxc,
 		"size", len(data),
 	)
@@ -436,76 +662,6 @@ func (m *Matrix) uploadMedia(ctx context.Context, intent *appservice.IntentAPI,
 	return mxc, nil
 }
 
-// AppServiceStateStore 扩展的状态存储
-// 缓存 Ghost 用户注册状态和房间加入规则
-type AppServiceStateStore struct {
-	mautrix.StateStore          // 基础状态存储
-	registrations      sync.Map // Ghost 用户注册状态缓存
-	joinRules          sync.Map // 房间加入规则缓存
-}
-
-// IsRegistered 检查用户是否已注册
-func (s *AppServiceStateStore) IsRegistered(ctx context.Context, userID id.UserID) (bool, error) {
-	val, ok := s.registrations.Load(userID)
-	if !ok {
-		return false, nil
-	}
-	return val.(bool), nil
-}
-
-// MarkRegistered 标记用户已注册
-func (s *AppServiceStateStore) MarkRegistered(ctx context.Context, userID id.UserID) error {
-	s.registrations.Store(userID, true)
-	return nil
-}
-
-// SetJoinRules 设置房间加入规则
-func (s *AppServiceStateStore) SetJoinRules(ctx context.Context, roomID id.RoomID, content *event.JoinRulesEventContent) error {
-	if content != nil {
-		s.joinRules.Store(roomID, content)
-	}
-	return nil
-}
-
-// GetJoinRules 获取房间加入规则
-func (s *AppServiceStateStore) GetJoinRules(ctx context.Context, roomID id.RoomID) (*event.JoinRulesEventContent, error) {
-	if val, ok := s.joinRules.Load(roomID); ok {
-		return val.(*event.JoinRulesEventContent), nil
-	}
-	return &event.JoinRulesEventContent{JoinRule: event.JoinRuleInvite}, nil // 默认为邀请制
-}
-
-// GetPowerLevel 获取用户在房间的权限等级
-func (s *AppServiceStateStore) GetPowerLevel(ctx context.Context, roomID id.RoomID, userID id.UserID) (int, error) {
-	levels, err := s.GetPowerLevels(ctx, roomID)
-	if err != nil || levels == nil {
-		return 0, err
-	}
-	return levels.GetUserLevel(userID), nil
-}
-
-// GetPowerLevelRequirement 获取事件类型所需的权限等级
-func (s *AppServiceStateStore) GetPowerLevelRequirement(ctx context.Context, roomID id.RoomID, eventType event.Type) (int, error) {
-	levels, err := s.GetPowerLevels(ctx, roomID)
-	if err != nil || levels == nil {
-		return 0, err
-	}
-	return levels.GetEventLevel(eventType), nil
-}
-
-// HasPowerLevel 检查用户是否有足够权限发送指定类型的事件
-func (s *AppServiceStateStore) HasPowerLevel(ctx context.Context, roomID id.RoomID, userID id.UserID, eventType event.Type) (bool, error) {
-	userLevel, err := s.GetPowerLevel(ctx, roomID, userID)
-	if err != nil {
-		return false, err
-	}
-	required, err := s.GetPowerLevelRequirement(ctx, roomID, eventType)
-	if err != nil {
-		return false, err
-	}
-	return userLevel >= required, nil
-}
-
 // sanitize 将字符串转换为安全的 Matrix localpart
 // 只保留小写字母、数字、连字符、点和下划线
 func (m *Matrix) sanitize(s string) string {
@@ -0,0 +1,136 @@
+//go:build goolm
+
+package matrix
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"Relify/internal"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/cryptohelper"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// cryptoAvailable 表示当前构建是否包含端到端加密支持
+const cryptoAvailable = true
+
+// matrixCrypto 封装 Bot 设备的加密状态
+// Ghost 用户没有独立设备，发送的加密消息使用 Bot 设备的 Megolm 会话
+type matrixCrypto struct {
+	helper *cryptohelper.CryptoHelper // mautrix 加密助手
+	client *mautrix.Client            // Bot 设备的客户端（用于同步 to-device 消息）
+}
+
+// startCrypto 初始化 Bot 设备的加密状态并开始同步 to-device 消息
+// 首次启动时以 AppService 身份登录创建设备，设备 ID 和密钥保存在加密存储中
+// 参数:
+//   - ctx: 上下文（同步随其取消而停止）
+//
+// 返回:
+//   - error: 初始化错误
+func (m *Matrix) startCrypto(ctx context.Context) error {
+	path := m.cfg.Encryption.Store
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", internal.SQLiteDSN(path))
+	if err != nil {
+		return fmt.Errorf("打开加密存储失败: %w", err)
+	}
+	store, err := dbutil.NewWithDB(db, "sqlite3")
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("打开加密存储失败: %w", err)
+	}
+
+	// 同步只用于接收 to-device 消息和设备列表变更，房间事件由 AppService 推送
+	everything := []event.Type{{Type: "*"}}
+	syncer := mautrix.NewDefaultSyncer()
+	syncer.FilterJSON = &mautrix.Filter{
+		Presence:    &mautrix.FilterPart{NotTypes: everything},
+		AccountData: &mautrix.FilterPart{NotTypes: everything},
+		Room: &mautrix.RoomFilter{
+			Ephemeral:   &mautrix.FilterPart{NotTypes: everything},
+			AccountData: &mautrix.FilterPart{NotTypes: everything},
+			State:       &mautrix.FilterPart{NotTypes: everything},
+			Timeline:    &mautrix.FilterPart{NotTypes: everything},
+		},
+	}
+
+	client := m.as.NewMautrixClient(m.botUserID)
+	client.Syncer = syncer
+
+	helper, err := cryptohelper.NewCryptoHelper(client, []byte(m.cfg.Encryption.PickleKey), store)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	helper.LoginAs = &mautrix.ReqLogin{
+		Type:                     mautrix.AuthTypeAppservice,
+		Identifier:               mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: m.botUserID.String()},
+		InitialDeviceDisplayName: "Relify",
+	}
+	if err := helper.Init(ctx); err != nil {
+		helper.Close()
+		return fmt.Errorf("初始化端到端加密失败: %w", err)
+	}
+	// 登录后使用设备自己的访问令牌，不再以 AppService 身份伪装
+	client.SetAppServiceUserID = false
+
+	go func() {
+		if err := client.SyncWithContext(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("Matrix 加密同步退出", "error", err)
+		}
+	}()
+
+	m.crypto.Store(&matrixCrypto{helper: helper, client: client})
+	slog.Info("Matrix 端到端加密已启用", "device_id", client.DeviceID)
+	return nil
+}
+
+// close 停止同步并关闭加密存储
+func (c *matrixCrypto) close() {
+	c.client.StopSync()
+	if err := c.helper.Close(); err != nil {
+		slog.Warn("Matrix 关闭加密存储失败", "error", err)
+	}
+}
+
+// handleMember 处理房间成员变更，成员离开时轮换 Megolm 会话
+func (c *matrixCrypto) handleMember(ctx context.Context, evt *event.Event) {
+	c.helper.Machine().HandleMemberEvent(ctx, evt)
+}
+
+// decrypt 解密 m.room.encrypted 事件
+func (c *matrixCrypto) decrypt(ctx context.Context, evt *event.Event) (*event.Event, error) {
+	return c.helper.Decrypt(ctx, evt)
+}
+
+// missingSession 判断解密失败是否因为尚未收到对应的 Megolm 会话
+func (c *matrixCrypto) missingSession(err error) bool {
+	return errors.Is(err, crypto.NoSessionFound)
+}
+
+// waitForSession 等待发送方通过 to-device 消息分享会话
+// 返回:
+//   - bool: 超时前是否收到会话
+func (c *matrixCrypto) waitForSession(ctx context.Context, evt *event.Event, timeout time.Duration) bool {
+	content := evt.Content.AsEncrypted()
+	return c.helper.WaitForSession(ctx, evt.RoomID, content.SenderKey, content.SessionID, timeout)
+}
+
+// encrypt 使用 Bot 设备的 Megolm 会话加密事件内容，必要时先向房间成员分享会话
+func (c *matrixCrypto) encrypt(ctx context.Context, roomID id.RoomID, evtType event.Type, content any) (*event.EncryptedEventContent, error) {
+	return c.helper.Encrypt(ctx, roomID, evtType, content)
+}
@@ -0,0 +1,38 @@
+//go:build !goolm
+
+package matrix
+
+import (
+	"context"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// cryptoAvailable 表示当前构建是否包含端到端加密支持
+const cryptoAvailable = false
+
+// matrixCrypto 在未编译加密支持时为空实现，驱动不会创建该类型的实例
+type matrixCrypto struct{}
+
+// startCrypto 未编译加密支持时始终返回错误
+func (m *Matrix) startCrypto(ctx context.Context) error { return errNoCrypto }
+
+func (c *matrixCrypto) close() {}
+
+func (c *matrixCrypto) handleMember(ctx context.Context, evt *event.Event) {}
+
+func (c *matrixCrypto) decrypt(ctx context.Context, evt *event.Event) (*event.Event, error) {
+	return nil, errNoCrypto
+}
+
+func (c *matrixCrypto) missingSession(err error) bool { return false }
+
+func (c *matrixCrypto) waitForSession(ctx context.Context, evt *event.Event, timeout time.Duration) bool {
+	return false
+}
+
+func (c *matrixCrypto) encrypt(ctx context.Context, roomID id.RoomID, evtType event.Type, content any) (*event.EncryptedEventContent, error) {
+	return nil, errNoCrypto
+}
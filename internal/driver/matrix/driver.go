@@ -4,34 +4,55 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/netip"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"Relify/internal"
 
+	"github.com/jellydator/ttlcache/v3"
 	"maunium.net/go/mautrix/appservice"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
 
+func init() {
+	internal.RegisterDriver("matrix", func(props internal.Properties) (internal.Driver, error) {
+		return NewMatrix(props)
+	}, func() internal.ConfigSchema { return &Config{} })
+}
+
 // Matrix 实现 Matrix 平台的驱动
 // 使用 AppService 协议与 Matrix 服务器通信
 type Matrix struct {
-	cfg       *Config                // Matrix 配置
-	router    *internal.Router       // 消息路由器
-	as        *appservice.AppService // AppService 实例
-	botUserID id.UserID              // Bot 用户 ID
-	cache     sync.Map               // 缓存（用于存储用户信息、Ghost 配置等）
+	cfg       *Config                      // Matrix 配置
+	api       internal.API                 // 核心接口（消息提交与映射查询）
+	as        *appservice.AppService       // AppService 实例
+	botUserID id.UserID                    // Bot 用户 ID
+	cache     *ttlcache.Cache[string, any] // 有界缓存（成员信息、权限等级、Ghost 资料摘要等）
+	store     *AppServiceStateStore        // 持久化状态存储
+	health    internal.HealthTracker       // 与 Homeserver 的连接健康状态
+	server    *http.Server                 // 接收 Homeserver 推送的 HTTP 服务
+	allowIPs  []netip.Prefix               // 允许访问监听端口的地址（为空不限制）
+	crypto    atomic.Pointer[matrixCrypto] // 端到端加密状态（未启用或未就绪时为 nil）
+	cryptoErr atomic.Pointer[error]        // 最近一次加密初始化失败的原因（成功后清除）
+	cryptoRun chan struct{}                // 首次加密初始化结束（无论成功与否）时关闭
+
+	pendingMu sync.Mutex                   // 保护 pending
+	pending   map[id.RoomID][]*event.Event // 有加密事件等待 Megolm 会话的房间及其排队事件
+	pendingWG sync.WaitGroup               // 处理待处理队列的协程
 }
 
 // NewMatrix 创建新的 Matrix 驱动实例
 // 参数:
 //   - props: 配置属性
-//   - router: 消息路由器
 //
 // 返回:
 //   - *Matrix: Matrix 驱动实例
 //   - error: 初始化错误
-func NewMatrix(props internal.Props, router *internal.Router) (*Matrix, error) {
+func NewMatrix(props internal.Properties) (*Matrix, error) {
 	cfg, err := parseConfig(props)
 	if err != nil {
 		return nil, err
@@ -42,9 +63,22 @@ func NewMatrix(props internal.Props, router *internal.Router) (*Matrix, error) {
 		"server", cfg.ServerURL,
 	)
 
+	// 配置校验已报告此问题，这里防止绕过校验直接创建驱动时在运行中才发现
+	if cfg.Encryption.Enabled && !cryptoAvailable {
+		return nil, errNoCrypto
+	}
+
+	allowIPs, err := parseAllowIPs(cfg.AppService.AllowIPs)
+	if err != nil {
+		return nil, err
+	}
+
 	m := &Matrix{
-		cfg:    cfg,
-		router: router,
+		cfg:       cfg,
+		allowIPs:  allowIPs,
+		cache:     newCache(),
+		pending:   make(map[id.RoomID][]*event.Event),
+		cryptoRun: make(chan struct{}),
 	}
 
 	// 初始化 AppService 客户端
@@ -52,28 +86,89 @@ func NewMatrix(props internal.Props, router *internal.Router) (*Matrix, error) {
 		return nil, err
 	}
 
-	slog.Info("Matrix 驱动初始化完成",
-		"bot_user_id", m.botUserID,
-	)
-
 	return m, nil
 }
 
-// Name 返回驱动名称
-func (m *Matrix) Name() string { return "matrix" }
+// newCache 创建驱动使用的有界缓存
+// 数据以状态存储为准，缓存只用于减少数据库和 Homeserver 查询，过期或超出容量时淘汰
+func newCache() *ttlcache.Cache[string, any] {
+	return ttlcache.New(
+		ttlcache.WithTTL[string, any](30*time.Minute),
+		ttlcache.WithCapacity[string, any](10000),
+		ttlcache.WithDisableTouchOnHit[string, any](),
+	)
+}
 
-// Route 返回路由模式（镜像模式）
-// Matrix 为每个桥接创建独立的房间
-func (m *Matrix) Route() internal.Route { return internal.RouteMirror }
+// relationRetention 是表态和话题记录的保留时间，更早的表态无法再被取消，回复更早的话题消息时不再归入话题
+const relationRetention = 90 * 24 * time.Hour
 
-// Start 启动 Matrix 驱动
+// pruneRelations 每天清理一次过期的表态和话题记录，以及代理链接已失效的加密媒体记录
 // 参数:
 //   - ctx: 上下文
+func (m *Matrix) pruneRelations(ctx context.Context) {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		before := time.Now().Add(-relationRetention)
+		if n, err := m.store.PruneReactions(ctx, before); err != nil {
+			slog.Warn("Matrix 清理表态记录失败", "error", err)
+		} else if n > 0 {
+			slog.Debug("Matrix 已清理过期表态记录", "count", n)
+		}
+		if n, err := m.store.PruneThreads(ctx, before); err != nil {
+			slog.Warn("Matrix 清理话题记录失败", "error", err)
+		} else if n > 0 {
+			slog.Debug("Matrix 已清理过期话题记录", "count", n)
+		}
+		// 代理链接最长有效两个有效期
+		if n, err := m.store.PruneEncryptedMedia(ctx, time.Now().Add(-2*m.mediaTTL())); err != nil {
+			slog.Warn("Matrix 清理加密媒体记录失败", "error", err)
+		} else if n > 0 {
+			slog.Debug("Matrix 已清理过期加密媒体记录", "count", n)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// name 返回驱动在系统内部使用的平台名称
+func (m *Matrix) name() string { return "matrix" }
+
+// Init 初始化 Matrix 驱动并启动 AppService 服务
+// Matrix 为每个桥接创建独立的房间（镜像模式）
+// 参数:
+//   - ctx: 上下文
+//   - api: 核心接口
 //
 // 返回:
+//   - string: 平台名称
+//   - internal.RoutePolicy: 路由策略
 //   - error: 启动错误
-func (m *Matrix) Start(ctx context.Context) error {
-	return m.startServe(ctx)
+func (m *Matrix) Init(ctx context.Context, api internal.API) (string, internal.RoutePolicy, error) {
+	m.api = api
+
+	// 状态存储与核心共用数据库
+	store, err := newStateStore(ctx, api.DB())
+	if err != nil {
+		return "", "", err
+	}
+	m.store = store
+	m.as.StateStore = store
+	go m.pruneRelations(ctx)
+
+	if err := m.startServe(ctx); err != nil {
+		return "", "", err
+	}
+
+	slog.Info("Matrix 驱动初始化完成",
+		"bot_user_id", m.botUserID,
+	)
+	return m.name(), internal.PolicyMirror, nil
 }
 
 // Stop 停止 Matrix 驱动
@@ -86,63 +181,82 @@ func (m *Matrix) Stop(ctx context.Context) error {
 	return m.stopServe(ctx)
 }
 
-// Info 获取 Matrix 房间的信息
+// Health 返回与 Homeserver 之间的连接健康状态
+// 连接状态由定期探测 Homeserver 得出，发送失败时标记为降级
+// 返回:
+//   - internal.Health: 健康状态
+func (m *Matrix) Health() internal.Health {
+	return m.health.Health()
+}
+
+// GetUserInfo 获取 Matrix 用户的资料
 // 参数:
 //   - ctx: 上下文
-//   - room: 房间 ID
+//   - userID: 用户 ID
 //
 // 返回:
-//   - *internal.Info: 房间信息
+//   - *internal.Sender: 用户信息
 //   - error: 获取错误
-func (m *Matrix) Info(ctx context.Context, room string) (*internal.Info, error) {
-	rid := id.RoomID(room)
-	info := &internal.Info{ID: room, Name: room}
+func (m *Matrix) GetUserInfo(ctx context.Context, userID string) (*internal.Sender, error) {
+	profile, err := m.as.BotIntent().GetProfile(ctx, id.UserID(userID))
+	if err != nil {
+		return nil, err
+	}
 
-	slog.Debug("Matrix 获取房间信息", "room_id", room)
+	user := &internal.Sender{ID: userID, Name: profile.DisplayName, Type: internal.SenderUser}
+	if user.Name == "" {
+		user.Name = userID
+	}
+	if !profile.AvatarURL.IsEmpty() {
+		user.Avatar = m.mxcToURL(profile.AvatarURL.String()) // 转换 mxc:// 为媒体代理链接
+	}
+	return user, nil
+}
+
+// GetRoomInfo 获取 Matrix 房间的信息
+// 参数:
+//   - ctx: 上下文
+//   - roomID: 房间 ID
+//
+// 返回:
+//   - *internal.RoomInfo: 房间信息
+//   - error: 获取错误
+func (m *Matrix) GetRoomInfo(ctx context.Context, roomID string) (*internal.RoomInfo, error) {
+	rid := id.RoomID(roomID)
+	info := &internal.RoomInfo{ID: roomID, Name: roomID}
+
+	slog.Debug("Matrix 获取房间信息", "room_id", roomID)
 
 	// 获取房间名称
-	var nameRes struct{ Name string }
+	var nameRes event.RoomNameEventContent
 	if err := m.as.BotIntent().StateEvent(ctx, rid, event.StateRoomName, "", &nameRes); err != nil {
 		slog.Warn("Matrix 获取房间名称失败",
-			"room_id", room,
+			"room_id", roomID,
 			"error", err,
 		)
 	} else if nameRes.Name != "" {
 		info.Name = nameRes.Name
-		slog.Debug("Matrix 获取房间名称成功",
-			"room_id", room,
-			"name", nameRes.Name,
-		)
-	} else {
-		slog.Debug("Matrix 房间名称为空", "room_id", room)
 	}
 
-	// 获取房间头像
-	var avatarRes struct {
-		Url string `json:"url"`
+	// 获取房间主题
+	var topicRes event.TopicEventContent
+	if err := m.as.BotIntent().StateEvent(ctx, rid, event.StateTopic, "", &topicRes); err == nil {
+		info.Topic = topicRes.Topic
 	}
+
+	// 获取房间头像
+	var avatarRes event.RoomAvatarEventContent
 	if err := m.as.BotIntent().StateEvent(ctx, rid, event.StateRoomAvatar, "", &avatarRes); err != nil {
 		slog.Warn("Matrix 获取房间头像失败",
-			"room_id", room,
+			"room_id", roomID,
 			"error", err,
 		)
-	} else if avatarRes.Url != "" {
-		slog.Debug("Matrix 获取房间头像成功",
-			"room_id", room,
-			"mxc", avatarRes.Url,
-		)
-		info.Avatar = m.mxcToURL(avatarRes.Url) // 转换 mxc:// 为 HTTP URL
-		slog.Debug("Matrix 房间头像URL转换",
-			"room_id", room,
-			"mxc", avatarRes.Url,
-			"http_url", info.Avatar,
-		)
-	} else {
-		slog.Debug("Matrix 房间头像为空", "room_id", room)
+	} else if avatarRes.URL != "" {
+		info.Avatar = m.mxcToURL(string(avatarRes.URL)) // 转换 mxc:// 为媒体代理链接
 	}
 
 	slog.Debug("Matrix 房间信息获取完成",
-		"room_id", room,
+		"room_id", roomID,
 		"name", info.Name,
 		"avatar", info.Avatar,
 	)
@@ -150,7 +264,7 @@ func (m *Matrix) Info(ctx context.Context, room string) (*internal.Info, error)
 	return info, nil
 }
 
-// Make 创建新的 Matrix 房间
+// CreateRoom 创建新的 Matrix 房间
 // 参数:
 //   - ctx: 上下文
 //   - info: 房间信息（必需，用于镜像模式）
@@ -158,10 +272,9 @@ func (m *Matrix) Info(ctx context.Context, room string) (*internal.Info, error)
 // 返回:
 //   - string: 创建的房间 ID
 //   - error: 创建错误
-func (m *Matrix) Make(ctx context.Context, info *internal.Info) (string, error) {
+func (m *Matrix) CreateRoom(ctx context.Context, info *internal.RoomInfo) (string, error) {
 	if info == nil {
 		return "", fmt.Errorf("镜像模式需要info参数")
 	}
-	roomID, err := m.createRoom(ctx, info)
-	return roomID, err
+	return m.createRoom(ctx, info)
 }
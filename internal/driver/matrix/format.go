@@ -0,0 +1,314 @@
+package matrix
+
+import (
+	"fmt"
+	"html"
+	"maps"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"Relify/internal"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"maunium.net/go/mautrix/id"
+)
+
+// spaceRun 匹配 HTML 中可折叠的连续空白
+var spaceRun = regexp.MustCompile(`[ \t\r\n]+`)
+
+// htmlParser 将 Matrix 消息的 formatted_body 解析为内部消息段
+// 文本按样式拆分为 SegText 片段，matrix.to 用户链接（pill）转换为 SegMention
+type htmlParser struct {
+	segs  []internal.Segment // 已解析的片段
+	lists []int              // 列表嵌套栈：有序列表为下一个序号，无序列表为 0
+}
+
+// parseHTML 解析 HTML 格式的消息内容
+// 参数:
+//   - formatted: formatted_body 内容
+//
+// 返回:
+//   - []internal.Segment: 消息段列表（解析失败或内容为空时为 nil）
+func parseHTML(formatted string) []internal.Segment {
+	ctx := &xhtml.Node{Type: xhtml.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := xhtml.ParseFragment(strings.NewReader(formatted), ctx)
+	if err != nil {
+		return nil
+	}
+	p := &htmlParser{}
+	for _, n := range nodes {
+		p.walk(n, nil)
+	}
+	p.trim()
+	if len(p.segs) == 0 {
+		return nil
+	}
+	return p.segs
+}
+
+// walk 递归解析节点
+// 参数:
+//   - n: HTML 节点
+//   - style: 从父节点继承的样式
+func (p *htmlParser) walk(n *xhtml.Node, style internal.Properties) {
+	switch n.Type {
+	case xhtml.TextNode:
+		p.text(n.Data, style)
+	case xhtml.ElementNode:
+		p.element(n, style)
+	}
+}
+
+// element 解析元素节点，根据标签叠加样式后解析子节点
+// 参数:
+//   - n: HTML 元素节点
+//   - style: 从父节点继承的样式
+func (p *htmlParser) element(n *xhtml.Node, style internal.Properties) {
+	block := false
+	switch n.Data {
+	case "mx-reply":
+		return // 回复引用的回退内容
+	case "br":
+		p.write("\n", nil)
+		return
+	case "img":
+		// 自定义表情等内联图片保留替代文本
+		if alt := attr(n, "alt"); alt != "" {
+			p.text(alt, style)
+		} else {
+			p.text(attr(n, "title"), style)
+		}
+		return
+	case "a":
+		href := attr(n, "href")
+		if uri, err := id.ParseMatrixURIOrMatrixToURL(href); err == nil && uri.Sigil1 == '@' {
+			p.segs = append(p.segs, internal.Segment{
+				Type: internal.SegMention,
+				ID:   uri.UserID().String(),
+				Text: strings.TrimSpace(textContent(n)),
+			})
+			return
+		}
+		if href != "" {
+			style = withStyle(style, internal.StyleLink, href)
+		}
+	case "strong", "b":
+		style = withStyle(style, internal.StyleBold, true)
+	case "em", "i":
+		style = withStyle(style, internal.StyleItalic, true)
+	case "u", "ins":
+		style = withStyle(style, internal.StyleUnderline, true)
+	case "del", "s", "strike":
+		style = withStyle(style, internal.StyleStrike, true)
+	case "code":
+		if _, ok := style[internal.StylePre]; !ok {
+			style = withStyle(style, internal.StyleCode, true)
+		}
+	case "pre":
+		block = true
+		style = withStyle(style, internal.StylePre, true)
+		if c := n.FirstChild; c != nil && c.Type == xhtml.ElementNode && c.Data == "code" {
+			for _, class := range strings.Fields(attr(c, "class")) {
+				if lang, ok := strings.CutPrefix(class, "language-"); ok {
+					style = withStyle(style, internal.StyleLang, lang)
+				}
+			}
+		}
+	case "blockquote":
+		block = true
+		style = withStyle(style, internal.StyleQuote, true)
+	case "span", "font":
+		if _, ok := attrValue(n, "data-mx-spoiler"); ok {
+			style = withStyle(style, internal.StyleSpoiler, true)
+		}
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		block = true
+		style = withStyle(style, internal.StyleBold, true)
+	case "p", "div", "table", "tr", "hr":
+		block = true
+	case "ul", "ol":
+		block = true
+		next := 0
+		if n.Data == "ol" {
+			next = 1
+			if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+				next = start
+			}
+		}
+		p.lists = append(p.lists, next)
+		defer func() { p.lists = p.lists[:len(p.lists)-1] }()
+	case "li":
+		block = true
+	}
+
+	if block {
+		p.newline()
+	}
+	if n.Data == "li" && len(p.lists) > 0 {
+		// 列表项前缀：嵌套列表缩进，有序列表编号
+		depth := len(p.lists) - 1
+		prefix := strings.Repeat("  ", depth) + "• "
+		if next := p.lists[depth]; next > 0 {
+			prefix = strings.Repeat("  ", depth) + fmt.Sprintf("%d. ", next)
+			p.lists[depth]++
+		}
+		p.write(prefix, nil)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		p.walk(c, style)
+	}
+	if block {
+		p.newline()
+	}
+}
+
+// text 添加文本节点内容
+// 代码块内保留原样，其他位置按 HTML 规则折叠空白，行首的空白忽略
+// 参数:
+//   - data: 文本内容
+//   - style: 文本样式
+func (p *htmlParser) text(data string, style internal.Properties) {
+	if _, ok := style[internal.StylePre]; !ok {
+		data = spaceRun.ReplaceAllString(data, " ")
+		if p.atLineStart() {
+			data = strings.TrimLeft(data, " ")
+		}
+	}
+	p.write(data, style)
+}
+
+// write 追加文本，与前一个样式相同的文本片段合并
+// 参数:
+//   - text: 文本内容
+//   - style: 文本样式
+func (p *htmlParser) write(text string, style internal.Properties) {
+	if text == "" {
+		return
+	}
+	if n := len(p.segs); n > 0 && p.segs[n-1].Type == internal.SegText && maps.Equal(p.segs[n-1].Extra, style) {
+		p.segs[n-1].Text += text
+		return
+	}
+	p.segs = append(p.segs, internal.Segment{Type: internal.SegText, Text: text, Extra: style})
+}
+
+// newline 在块级元素前后换行（已在行首时不重复换行）
+func (p *htmlParser) newline() {
+	if !p.atLineStart() {
+		p.write("\n", nil)
+	}
+}
+
+// atLineStart 判断当前是否位于行首
+func (p *htmlParser) atLineStart() bool {
+	n := len(p.segs)
+	if n == 0 {
+		return true
+	}
+	last := p.segs[n-1]
+	return last.Type == internal.SegText && strings.HasSuffix(last.Text, "\n")
+}
+
+// trim 去除末尾的换行和空白，以及因此变为空的片段
+func (p *htmlParser) trim() {
+	for n := len(p.segs); n > 0; n = len(p.segs) {
+		last := &p.segs[n-1]
+		if last.Type != internal.SegText {
+			return
+		}
+		if _, ok := last.Extra[internal.StylePre]; !ok {
+			last.Text = strings.TrimRight(last.Text, " \n")
+		} else {
+			last.Text = strings.TrimRight(last.Text, "\n")
+		}
+		if last.Text != "" {
+			return
+		}
+		p.segs = p.segs[:n-1]
+	}
+}
+
+// withStyle 返回叠加了一种样式的新样式集合（不修改原集合）
+func withStyle(style internal.Properties, key string, value any) internal.Properties {
+	out := make(internal.Properties, len(style)+1)
+	maps.Copy(out, style)
+	out[key] = value
+	return out
+}
+
+// attr 返回元素的属性值，不存在时返回空字符串
+func attr(n *xhtml.Node, key string) string {
+	v, _ := attrValue(n, key)
+	return v
+}
+
+// attrValue 返回元素的属性值及其是否存在
+func attrValue(n *xhtml.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// textContent 返回元素内的全部文本
+func textContent(n *xhtml.Node) string {
+	if n.Type == xhtml.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+// renderStyledText 将带样式的文本片段渲染为 Matrix HTML
+// 参数:
+//   - seg: 文本片段
+//
+// 返回:
+//   - string: HTML 内容
+func renderStyledText(seg *internal.Segment) string {
+	h := html.EscapeString(seg.Text)
+	if len(seg.Extra) == 0 {
+		return h
+	}
+
+	if seg.HasStyle(internal.StylePre) {
+		class := ""
+		if lang, _ := seg.Extra[internal.StyleLang].(string); lang != "" {
+			class = fmt.Sprintf(` class="language-%s"`, html.EscapeString(lang))
+		}
+		h = fmt.Sprintf("<pre><code%s>%s</code></pre>", class, h)
+	} else if seg.HasStyle(internal.StyleCode) {
+		h = "<code>" + h + "</code>"
+	}
+
+	tags := []struct {
+		style string
+		open  string
+		close string
+	}{
+		{internal.StyleBold, "<strong>", "</strong>"},
+		{internal.StyleItalic, "<em>", "</em>"},
+		{internal.StyleUnderline, "<u>", "</u>"},
+		{internal.StyleStrike, "<del>", "</del>"},
+		{internal.StyleSpoiler, "<span data-mx-spoiler>", "</span>"},
+	}
+	for _, t := range tags {
+		if seg.HasStyle(t.style) {
+			h = t.open + h + t.close
+		}
+	}
+	if link, _ := seg.Extra[internal.StyleLink].(string); link != "" {
+		h = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(link), h)
+	}
+	if seg.HasStyle(internal.StyleQuote) {
+		h = "<blockquote>" + h + "</blockquote>"
+	}
+	return h
+}
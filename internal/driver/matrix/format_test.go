@@ -0,0 +1,176 @@
+package matrix
+
+import (
+	"reflect"
+	"testing"
+
+	"Relify/internal"
+)
+
+// styled 构造带样式的文本片段，style 为空时不带 Extra。
+func styled(text string, style internal.Properties) internal.Segment {
+	return internal.Segment{Type: internal.SegText, Text: text, Extra: style}
+}
+
+func TestParseHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []internal.Segment
+	}{
+		{
+			name: "纯文本",
+			in:   "hello &amp; bye",
+			want: []internal.Segment{styled("hello & bye", nil)},
+		},
+		{
+			name: "嵌套样式",
+			in:   "a <strong>b <em>c</em></strong> d",
+			want: []internal.Segment{
+				styled("a ", nil),
+				styled("b ", internal.Properties{internal.StyleBold: true}),
+				styled("c", internal.Properties{internal.StyleBold: true, internal.StyleItalic: true}),
+				styled(" d", nil),
+			},
+		},
+		{
+			name: "链接与行内代码",
+			in:   `see <a href="https://example.org">docs</a> or <code>go test</code>`,
+			want: []internal.Segment{
+				styled("see ", nil),
+				styled("docs", internal.Properties{internal.StyleLink: "https://example.org"}),
+				styled(" or ", nil),
+				styled("go test", internal.Properties{internal.StyleCode: true}),
+			},
+		},
+		{
+			name: "代码块保留空白和语言",
+			in:   "<pre><code class=\"language-go\">func main() {\n\tx  := 1\n}\n</code></pre>",
+			want: []internal.Segment{
+				styled("func main() {\n\tx  := 1\n}", internal.Properties{internal.StylePre: true, internal.StyleLang: "go"}),
+			},
+		},
+		{
+			name: "引用与段落",
+			in:   "<blockquote><p>quoted</p></blockquote><p>reply</p>",
+			want: []internal.Segment{
+				styled("quoted", internal.Properties{internal.StyleQuote: true}),
+				styled("\nreply", nil),
+			},
+		},
+		{
+			name: "剧透",
+			in:   `<span data-mx-spoiler="reason">secret</span>`,
+			want: []internal.Segment{styled("secret", internal.Properties{internal.StyleSpoiler: true})},
+		},
+		{
+			name: "用户链接转为提及",
+			in:   `hi <a href="https://matrix.to/#/@alice:example.org">Alice</a>!`,
+			want: []internal.Segment{
+				styled("hi ", nil),
+				{Type: internal.SegMention, ID: "@alice:example.org", Text: "Alice"},
+				styled("!", nil),
+			},
+		},
+		{
+			name: "房间链接保留为链接",
+			in:   `<a href="https://matrix.to/#/#room:example.org">room</a>`,
+			want: []internal.Segment{styled("room", internal.Properties{internal.StyleLink: "https://matrix.to/#/#room:example.org"})},
+		},
+		{
+			name: "去除回复回退内容",
+			in:   "<mx-reply><blockquote>old</blockquote></mx-reply>new",
+			want: []internal.Segment{styled("new", nil)},
+		},
+		{
+			name: "列表",
+			in:   `<ol start="3"><li>a</li><li>b<ul><li>c</li></ul></li></ol>`,
+			want: []internal.Segment{styled("3. a\n4. b\n  • c", nil)},
+		},
+		{
+			name: "换行与空白折叠",
+			in:   "line1<br>  line2\n\n   more",
+			want: []internal.Segment{styled("line1\nline2 more", nil)},
+		},
+		{
+			name: "标题加粗",
+			in:   "<h1>Title</h1>body",
+			want: []internal.Segment{
+				styled("Title", internal.Properties{internal.StyleBold: true}),
+				styled("\nbody", nil),
+			},
+		},
+		{
+			name: "图片使用替代文本",
+			in:   `<img alt=":cat:" src="mxc://x/y"> ok`,
+			want: []internal.Segment{styled(":cat: ok", nil)},
+		},
+		{
+			name: "空内容",
+			in:   "<p> </p><br>",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseHTML(tt.in)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHTML(%q) = %#v\n期望 %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderStyledText(t *testing.T) {
+	tests := []struct {
+		name string
+		seg  internal.Segment
+		want string
+	}{
+		{"无样式转义", styled("a < b & c", nil), "a &lt; b &amp; c"},
+		{"粗斜体", styled("x", internal.Properties{internal.StyleBold: true, internal.StyleItalic: true}), "<em><strong>x</strong></em>"},
+		{"代码块语言", styled("x := 1", internal.Properties{internal.StylePre: true, internal.StyleLang: "go"}), `<pre><code class="language-go">x := 1</code></pre>`},
+		{"链接地址转义", styled("q", internal.Properties{internal.StyleLink: `https://x/?a=1&b="2"`}), `<a href="https://x/?a=1&amp;b=&#34;2&#34;">q</a>`},
+		{"引用包裹在最外层", styled("q", internal.Properties{internal.StyleQuote: true, internal.StyleStrike: true}), "<blockquote><del>q</del></blockquote>"},
+		{"剧透", styled("s", internal.Properties{internal.StyleSpoiler: true}), "<span data-mx-spoiler>s</span>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderStyledText(&tt.seg); got != tt.want {
+				t.Errorf("renderStyledText = %q，期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestStyledTextRoundTrip 检查渲染后的 HTML 能解析回相同的片段。
+func TestStyledTextRoundTrip(t *testing.T) {
+	tests := []internal.Segment{
+		styled("plain <text>", nil),
+		styled("bold", internal.Properties{internal.StyleBold: true}),
+		styled("all", internal.Properties{
+			internal.StyleBold:      true,
+			internal.StyleItalic:    true,
+			internal.StyleUnderline: true,
+			internal.StyleStrike:    true,
+			internal.StyleSpoiler:   true,
+		}),
+		styled("inline", internal.Properties{internal.StyleCode: true}),
+		styled("fn()\n  return", internal.Properties{internal.StylePre: true, internal.StyleLang: "python"}),
+		styled("block", internal.Properties{internal.StylePre: true}),
+		styled("link & co", internal.Properties{internal.StyleLink: "https://example.org/?a=1&b=2"}),
+		styled("quoted", internal.Properties{internal.StyleQuote: true, internal.StyleItalic: true}),
+	}
+
+	for _, seg := range tests {
+		t.Run(seg.Text, func(t *testing.T) {
+			h := renderStyledText(&seg)
+			got := parseHTML(h)
+			if want := []internal.Segment{seg}; !reflect.DeepEqual(got, want) {
+				t.Errorf("parseHTML(%q) = %#v\n期望 %#v", h, got, want)
+			}
+		})
+	}
+}
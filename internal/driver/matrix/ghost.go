@@ -0,0 +1,240 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"Relify/internal"
+
+	"github.com/jellydator/ttlcache/v3"
+	"maunium.net/go/mautrix/appservice"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// getGhost 获取或创建 Ghost 用户的 Intent API
+// Ghost 用户是 AppService 为其他平台用户创建的傀儡账号
+// 参数:
+//   - ctx: 上下文
+//   - evt: 原始事件（包含用户信息）
+//   - roomID: 目标房间 ID（用于设置房间内名称）
+//
+// 返回:
+//   - *appservice.IntentAPI: Ghost 用户的操作接口（无发送者时为 Bot）
+func (m *Matrix) getGhost(ctx context.Context, evt *internal.Event, roomID id.RoomID) *appservice.IntentAPI {
+	if evt.Sender == nil || evt.Sender.ID == "" {
+		return m.as.BotIntent()
+	}
+	intent := m.ghostIntent(evt)
+	mxid := intent.UserID
+
+	// 全局资料：名称或头像地址变化时立即同步，否则每个缓存周期检查一次头像内容是否变化
+	key := "ghost_" + mxid.String()
+	profile := globalName(evt.Sender) + "\x00" + evt.Sender.Avatar
+	if cached := m.cache.Get(key); cached == nil || cached.Value() != profile {
+		m.cache.Set(key, profile, ttlcache.DefaultTTL)
+		go m.updateGhostProfile(intent, *evt.Sender)
+	}
+
+	// 房间内名称（如 QQ 群名片），与全局名称相同时由全局资料决定
+	if name := evt.Sender.Name; name != "" && name != globalName(evt.Sender) {
+		m.syncRoomName(ctx, intent, roomID, name)
+	}
+	return intent
+}
+
+// ghostIntent 返回发送者对应的 Ghost 用户操作接口，不同步资料
+// 参数:
+//   - evt: 原始事件（需包含发送者）
+//
+// 返回:
+//   - *appservice.IntentAPI: Ghost 用户的操作接口
+func (m *Matrix) ghostIntent(evt *internal.Event) *appservice.IntentAPI {
+	return m.as.Intent(m.ghostUserID(evt.Platform, evt.Sender.ID))
+}
+
+// ghostUserID 返回代表其他平台用户的 Ghost 用户 ID
+// 本地部分为 namespace + 平台 + "_" + 用户 ID
+func (m *Matrix) ghostUserID(platform, userID string) id.UserID {
+	localpart := fmt.Sprintf("%s%s_%s", m.cfg.AppService.Namespace, platform, m.sanitize(userID))
+	return id.NewUserID(localpart, m.cfg.Domain)
+}
+
+// parseGhostUserID 从 Ghost 用户 ID 还原其代表的平台和用户 ID
+// 平台名称不含下划线，第一个下划线之后均为用户 ID
+// 返回:
+//   - string: 平台名称
+//   - string: 用户 ID
+//   - bool: 是否为 Ghost 用户
+func (m *Matrix) parseGhostUserID(userID id.UserID) (string, string, bool) {
+	localpart, server, err := userID.Parse()
+	if err != nil || server != m.cfg.Domain {
+		return "", "", false
+	}
+	rest, ok := strings.CutPrefix(localpart, m.cfg.AppService.Namespace)
+	if !ok {
+		return "", "", false
+	}
+	platform, uid, ok := strings.Cut(rest, "_")
+	return platform, uid, ok && platform != "" && uid != ""
+}
+
+// globalName 返回 Ghost 用户的全局显示名称
+// 优先使用全局昵称，其次是显示名称，都为空时使用用户 ID
+func globalName(sender *internal.Sender) string {
+	switch {
+	case sender.Nickname != "":
+		return sender.Nickname
+	case sender.Name != "":
+		return sender.Name
+	default:
+		return sender.ID
+	}
+}
+
+// updateGhostProfile 更新 Ghost 用户的全局显示名称和头像
+// 此操作异步执行，避免阻塞消息发送；与状态存储中记录的上次应用结果一致的部分跳过，
+// 头像按内容摘要比较，来源地址不变但内容变化时也会更新
+// 参数:
+//   - intent: Ghost 用户的操作接口
+//   - sender: 包含用户名称和头像的发送者信息
+func (m *Matrix) updateGhostProfile(intent *appservice.IntentAPI, sender internal.Sender) {
+	ctx := context.Background()
+	retry := func() { m.cache.Delete("ghost_" + intent.UserID.String()) } // 下次发送时重试
+
+	saved, err := m.store.GetGhost(ctx, intent.UserID)
+	if err != nil {
+		slog.Error("Matrix 读取Ghost用户资料失败",
+			"user_id", intent.UserID,
+			"error", err,
+		)
+		retry()
+		return
+	}
+
+	// 确保用户已注册
+	if err := intent.EnsureRegistered(ctx); err != nil {
+		slog.Error("Matrix Ghost用户注册失败",
+			"user_id", intent.UserID,
+			"error", err,
+		)
+		retry()
+		return
+	}
+
+	applied := *saved
+	defer func() {
+		if applied != *saved {
+			if err := m.store.SetGhost(ctx, intent.UserID, &applied); err != nil {
+				slog.Warn("Matrix 保存Ghost用户资料失败",
+					"user_id", intent.UserID,
+					"error", err,
+				)
+			}
+		}
+	}()
+
+	// 设置显示名称
+	if name := globalName(&sender); name != saved.Displayname {
+		slog.Debug("Matrix 更新Ghost用户名称",
+			"user_id", intent.UserID,
+			"name", name,
+			"original_user", sender.ID,
+		)
+		if err := intent.SetDisplayName(ctx, name); err != nil {
+			slog.Error("Matrix 设置显示名称失败",
+				"user_id", intent.UserID,
+				"name", name,
+				"error", err,
+			)
+			retry()
+			return
+		}
+		applied.Displayname = name
+	}
+
+	// 设置头像（如果有），相同内容的头像不会重复上传
+	if sender.Avatar == "" {
+		return
+	}
+	mxc, hash, err := m.uploadAvatar(ctx, intent, sender.Avatar)
+	if err != nil {
+		slog.Error("Matrix 上传头像失败",
+			"user_id", intent.UserID,
+			"avatar_url", sender.Avatar,
+			"error", err,
+		)
+		retry()
+		return
+	}
+	if mxc != saved.AvatarMXC {
+		avatarURI, err := id.ParseContentURI(mxc)
+		if err != nil {
+			slog.Error("Matrix 解析MXC URI失败",
+				"user_id", intent.UserID,
+				"mxc", mxc,
+				"error", err,
+			)
+			return
+		}
+		if err := intent.SetAvatarURL(ctx, avatarURI); err != nil {
+			slog.Error("Matrix 设置头像URL失败",
+				"user_id", intent.UserID,
+				"avatar_uri", avatarURI,
+				"error", err,
+			)
+			retry()
+			return
+		}
+		slog.Debug("Matrix Ghost用户头像已更新", "user_id", intent.UserID, "mxc", mxc)
+	}
+	applied.AvatarURL, applied.AvatarHash, applied.AvatarMXC = sender.Avatar, hash, mxc
+}
+
+// syncRoomName 设置 Ghost 用户在房间内的显示名称（m.room.member）
+// 房间成员状态与目标名称一致时跳过；全局资料更新后 Homeserver 可能覆盖房间内名称，收到成员事件时会重新检查
+// 参数:
+//   - ctx: 上下文
+//   - intent: Ghost 用户的操作接口
+//   - roomID: 房间 ID
+//   - name: 房间内显示名称
+func (m *Matrix) syncRoomName(ctx context.Context, intent *appservice.IntentAPI, roomID id.RoomID, name string) {
+	key := "roomname_" + roomID.String() + "_" + intent.UserID.String()
+	if cached := m.cache.Get(key); cached != nil && cached.Value() == name {
+		return
+	}
+
+	member, _ := m.store.TryGetMember(ctx, roomID, intent.UserID)
+	if member == nil || member.Membership != event.MembershipJoin || member.Displayname != name {
+		if err := intent.EnsureJoined(ctx, roomID); err != nil {
+			slog.Warn("Matrix Ghost用户加入房间失败",
+				"user_id", intent.UserID,
+				"room_id", roomID,
+				"error", err,
+			)
+			return
+		}
+
+		content := &event.MemberEventContent{Membership: event.MembershipJoin, Displayname: name}
+		if saved, err := m.store.GetGhost(ctx, intent.UserID); err == nil && saved.AvatarMXC != "" {
+			content.AvatarURL = id.ContentURIString(saved.AvatarMXC)
+		}
+		if _, err := intent.SendStateEvent(ctx, roomID, event.StateMember, intent.UserID.String(), content); err != nil {
+			slog.Warn("Matrix 设置房间内名称失败",
+				"user_id", intent.UserID,
+				"room_id", roomID,
+				"name", name,
+				"error", err,
+			)
+			return
+		}
+		slog.Debug("Matrix 已设置房间内名称",
+			"user_id", intent.UserID,
+			"room_id", roomID,
+			"name", name,
+		)
+	}
+	m.cache.Set(key, name, ttlcache.DefaultTTL)
+}
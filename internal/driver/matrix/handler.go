@@ -10,23 +10,43 @@ import (
 
 	"Relify/internal"
 
+	"github.com/jellydator/ttlcache/v3"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
 
+// sessionWait 是解密时等待发送方分享 Megolm 会话的最长时间
+const sessionWait = 10 * time.Second
+
 // processEvent 处理从 Matrix 接收的事件
-// 过滤掉 Bot 和 Ghost 用户的事件，处理消息和撤回事件
+// 过滤掉 Bot 和 Ghost 用户的事件，处理消息、撤回、加密事件以及输入状态、已读回执等临时事件
 // 参数:
+//   - ctx: 上下文（驱动停止时取消，用于等待 Megolm 会话）
 //   - evt: Matrix 事件
-func (m *Matrix) processEvent(evt *event.Event) {
-	// 忽略 Bot 自己发送的事件
-	if evt.Sender == m.botUserID {
-		return
+func (m *Matrix) processEvent(ctx context.Context, evt *event.Event) {
+	m.health.RecordEvent()
+
+	// 状态变更时清除该房间的相关缓存（状态存储本身由 AppService 在收到事件时更新）
+	switch evt.Type {
+	case event.StatePowerLevels:
+		m.cache.Delete("power_" + evt.RoomID.String())
+	case event.StateMember:
+		if evt.StateKey != nil {
+			m.cache.Delete("member_" + evt.RoomID.String() + "_" + *evt.StateKey)
+			m.cache.Delete("roomname_" + evt.RoomID.String() + "_" + *evt.StateKey)
+		}
 	}
 
-	// 忽略 Ghost 用户发送的事件（由其他平台桥接过来的）
-	prefix := "@" + m.cfg.AppService.Namespace
-	if strings.HasPrefix(evt.Sender.String(), prefix) {
+	// 成员变更需要通知加密模块（包括 Ghost 用户的加入和离开）
+	if evt.Type == event.StateMember {
+		if c := m.crypto.Load(); c != nil {
+			c.handleMember(context.Background(), evt)
+		}
+	}
+
+	// 忽略 Bot 和 Ghost 用户发送的事件（由其他平台桥接过来的）
+	// 临时事件没有发送者，在处理时按用户过滤
+	if evt.Sender != "" && m.isBridged(evt.Sender) {
 		return
 	}
 
@@ -42,15 +62,242 @@ func (m *Matrix) processEvent(evt *event.Event) {
 		}(),
 	)
 
-	// 根据事件类型分发处理
+	// 房间内有等待会话的加密事件时，后续事件排在其后，保证同一房间的事件按顺序处理
+	if m.enqueuePending(evt) {
+		return
+	}
+	if evt.Type == event.EventEncrypted {
+		m.handleEncrypted(ctx, evt)
+		return
+	}
+	m.dispatchEvent(evt)
+}
+
+// dispatchEvent 根据事件类型分发处理
+// 参数:
+//   - evt: Matrix 事件（加密事件为解密后的事件）
+func (m *Matrix) dispatchEvent(evt *event.Event) {
 	switch evt.Type {
 	case event.EventMessage:
 		m.handleMessage(evt) // 处理消息事件
 	case event.EventRedaction:
 		m.handleRedaction(evt) // 处理撤回事件
+	case event.EventReaction:
+		m.handleReaction(evt) // 处理表态事件
+	case event.EphemeralEventTyping:
+		m.handleTyping(evt) // 处理输入状态
+	case event.EphemeralEventReceipt:
+		m.handleReceipt(evt) // 处理已读回执
+	}
+}
+
+// isBridged 判断用户是否为 Bot 或 Ghost 用户
+// 参数:
+//   - userID: 用户 ID
+//
+// 返回:
+//   - bool: 是否由本桥接管理
+func (m *Matrix) isBridged(userID id.UserID) bool {
+	return userID == m.botUserID || strings.HasPrefix(userID.String(), "@"+m.cfg.AppService.Namespace)
+}
+
+// handleTyping 处理 Matrix 输入状态事件（m.typing）
+// 事件内容是房间内当前正在输入的全部用户，与上次的列表比较后分别转换为开始和停止输入事件
+// 参数:
+//   - evt: Matrix 输入状态事件
+func (m *Matrix) handleTyping(evt *event.Event) {
+	key := "typing_" + evt.RoomID.String()
+	var prev map[id.UserID]struct{}
+	if cached := m.cache.Get(key); cached != nil {
+		prev = cached.Value().(map[id.UserID]struct{})
+	}
+
+	current := make(map[id.UserID]struct{})
+	for _, userID := range evt.Content.AsTyping().UserIDs {
+		if !m.isBridged(userID) {
+			current[userID] = struct{}{}
+		}
+	}
+	m.cache.Set(key, current, ttlcache.DefaultTTL)
+
+	ctx := context.Background()
+	for userID := range current {
+		if _, ok := prev[userID]; !ok {
+			m.api.Receive(ctx, m.typingEvent(evt.RoomID, userID, true))
+		}
+	}
+	for userID := range prev {
+		if _, ok := current[userID]; !ok {
+			m.api.Receive(ctx, m.typingEvent(evt.RoomID, userID, false))
+		}
+	}
+}
+
+// typingEvent 构建内部输入状态事件
+// 参数:
+//   - roomID: 房间 ID
+//   - userID: 用户 ID
+//   - typing: 是否正在输入
+//
+// 返回:
+//   - *internal.Event: 内部输入状态事件
+func (m *Matrix) typingEvent(roomID id.RoomID, userID id.UserID, typing bool) *internal.Event {
+	return &internal.Event{
+		Type:     internal.TypeTyping,
+		Time:     time.Now(),
+		Platform: m.name(),
+		RoomID:   roomID.String(),
+		Sender:   m.getSender(userID, roomID),
+		Extra:    internal.Properties{"typing": typing},
+	}
+}
+
+// handleReceipt 处理 Matrix 已读回执事件（m.receipt）
+// 只转发公开的已读回执（m.read），私有回执和线程内回执忽略
+// 参数:
+//   - evt: Matrix 回执事件
+func (m *Matrix) handleReceipt(evt *event.Event) {
+	ctx := context.Background()
+	for eventID, receipts := range *evt.Content.AsReceipt() {
+		for userID, receipt := range receipts[event.ReceiptTypeRead] {
+			if m.isBridged(userID) || (receipt.ThreadID != "" && receipt.ThreadID != event.ReadReceiptThreadMain) {
+				continue
+			}
+			slog.Debug("Matrix 处理已读回执",
+				"id", eventID,
+				"user", userID,
+				"room", evt.RoomID,
+			)
+			m.api.Receive(ctx, &internal.Event{
+				Type:     internal.TypeRead,
+				Time:     receipt.Timestamp,
+				Platform: m.name(),
+				RoomID:   evt.RoomID.String(),
+				Sender:   m.getSender(userID, evt.RoomID),
+				RefID:    eventID.String(), // 已读的最后一条消息 ID
+			})
+		}
+	}
+}
+
+// handleEncrypted 解密 m.room.encrypted 事件后分发处理
+// 尚未收到 Megolm 会话时为该房间建立待处理队列，由单独的协程等待发送方分享会话，
+// 避免阻塞其他房间的事件，同时该房间的后续事件排在队列中按顺序处理
+// 参数:
+//   - ctx: 上下文
+//   - evt: Matrix 加密事件
+func (m *Matrix) handleEncrypted(ctx context.Context, evt *event.Event) {
+	c := m.crypto.Load()
+	if c == nil {
+		slog.Warn("Matrix 收到加密消息，但端到端加密未启用或未就绪",
+			"room", evt.RoomID,
+			"id", evt.ID,
+		)
+		return
+	}
+
+	decrypted, err := c.decrypt(ctx, evt)
+	if err != nil && c.missingSession(err) {
+		m.pendingMu.Lock()
+		m.pending[evt.RoomID] = []*event.Event{evt}
+		m.pendingMu.Unlock()
+
+		m.pendingWG.Add(1)
+		go func() {
+			defer m.pendingWG.Done()
+			m.drainPending(ctx, evt.RoomID)
+		}()
+		return
+	}
+	m.handleDecrypted(evt, decrypted, err)
+}
+
+// enqueuePending 房间存在待处理队列时将事件加入队列末尾
+// 参数:
+//   - evt: Matrix 事件
+//
+// 返回:
+//   - bool: 事件是否已加入队列
+func (m *Matrix) enqueuePending(evt *event.Event) bool {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	queue, ok := m.pending[evt.RoomID]
+	if ok {
+		m.pending[evt.RoomID] = append(queue, evt)
+	}
+	return ok
+}
+
+// drainPending 依次处理房间待处理队列中的事件，队列清空后删除队列
+// 加密事件缺少会话时原地等待，之后的事件继续排队
+// 参数:
+//   - ctx: 上下文（取消后不再等待会话）
+//   - roomID: 房间 ID
+func (m *Matrix) drainPending(ctx context.Context, roomID id.RoomID) {
+	for {
+		m.pendingMu.Lock()
+		queue := m.pending[roomID]
+		if len(queue) == 0 {
+			delete(m.pending, roomID)
+			m.pendingMu.Unlock()
+			return
+		}
+		evt := queue[0]
+		m.pending[roomID] = queue[1:]
+		m.pendingMu.Unlock()
+
+		if evt.Type != event.EventEncrypted {
+			m.dispatchEvent(evt)
+			continue
+		}
+		c := m.crypto.Load()
+		if c == nil {
+			continue
+		}
+		decrypted, err := c.decrypt(ctx, evt)
+		if err != nil && c.missingSession(err) && c.waitForSession(ctx, evt, sessionWait) {
+			decrypted, err = c.decrypt(ctx, evt)
+		}
+		m.handleDecrypted(evt, decrypted, err)
+	}
+}
+
+// waitPending 等待所有房间的待处理队列处理完毕
+// 参数:
+//   - ctx: 上下文（超时后不再等待）
+func (m *Matrix) waitPending(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		m.pendingWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("Matrix 等待加密事件处理超时")
 	}
 }
 
+// handleDecrypted 处理解密结果
+// 参数:
+//   - evt: 原始加密事件
+//   - decrypted: 解密后的事件
+//   - err: 解密错误
+func (m *Matrix) handleDecrypted(evt, decrypted *event.Event, err error) {
+	if err != nil {
+		slog.Warn("Matrix 解密消息失败",
+			"room", evt.RoomID,
+			"id", evt.ID,
+			"error", err,
+		)
+		return
+	}
+	if decrypted.Type == event.EventEncrypted {
+		return // 避免嵌套加密事件导致循环
+	}
+	m.dispatchEvent(decrypted)
+}
+
 // handleMessage 处理 Matrix 消息事件
 // 转换为统一的内部事件格式并路由到其他平台
 // 参数:
@@ -71,62 +318,127 @@ func (m *Matrix) handleMessage(evt *event.Event) {
 		}
 	}
 
-	// 获取发送者的显示名称和头像
-	name, avatar := m.getMemberInfo(evt.Sender, evt.RoomID)
-
 	slog.Debug("Matrix 处理消息",
 		"id", originID,
 		"is_edit", isEdit,
+		"thread", content.RelatesTo.GetThreadParent(),
 		"user", evt.Sender,
 		"room", evt.RoomID,
 	)
 
 	// 构建内部事件结构
 	e := &internal.Event{
-		ID:     originID,
-		Kind:   internal.Msg,
-		Time:   time.UnixMilli(evt.Timestamp),
-		Plat:   m.Name(),
-		Room:   evt.RoomID.String(),
-		User:   evt.Sender.String(),
-		Name:   name,
-		Avatar: avatar,
+		ID:       evt.ID.String(),
+		Type:     internal.TypeMessage,
+		Time:     time.UnixMilli(evt.Timestamp),
+		Platform: m.name(),
+		RoomID:   evt.RoomID.String(),
+		Sender:   m.getSender(evt.Sender, evt.RoomID),
 	}
 
 	// 设置编辑标记
 	if isEdit {
-		e.Kind = internal.Edit
-		e.Ref = originID
+		e.Type = internal.TypeEdit
+		e.RefID = originID
 	}
 
-	// 处理回复消息（不是编辑的情况下）
-	if !isEdit && content.RelatesTo != nil && content.RelatesTo.InReplyTo != nil {
-		e.Ref = content.RelatesTo.InReplyTo.EventID.String()
+	// 处理回复和话题消息（不是编辑的情况下），话题的回退引用不算作回复
+	if !isEdit && content.RelatesTo != nil {
+		e.RefID = content.RelatesTo.GetNonFallbackReplyTo().String()
+		if root := content.RelatesTo.GetThreadParent(); root != "" {
+			e.ThreadID = root.String()
+			if err := m.store.SaveThreadEvent(context.Background(), evt.RoomID, root, evt.ID); err != nil {
+				slog.Warn("Matrix 保存话题记录失败", "id", evt.ID, "error", err)
+			}
+		}
 	}
 
 	// 解析消息内容为段列表
-	e.Segs = m.parseMessageContent(content)
+	e.Segments = m.parseMessageContent(content)
+	e.Segments = m.parseMentions(e.Segments, content, evt.Sender, evt.RoomID)
+	m.resolveGhostMentions(e.Segments)
 
 	// 发送到路由器处理
-	m.router.Handle(context.Background(), e)
+	m.api.Receive(context.Background(), e)
 }
 
-// getMemberInfo 获取房间成员的显示信息
+// getSender 获取房间成员的发送者信息
 // 使用缓存减少 API 调用
 // 参数:
 //   - userID: 用户 ID
 //   - roomID: 房间 ID
 //
 // 返回:
+//   - *internal.Sender: 发送者信息
+func (m *Matrix) getSender(userID id.UserID, roomID id.RoomID) *internal.Sender {
+	name, avatar := m.getMemberInfo(userID, roomID)
+	sender := &internal.Sender{
+		ID:     userID.String(),
+		Name:   name,
+		Type:   internal.SenderUser,
+		Avatar: avatar,
+	}
+	if pl, ok := m.getPowerLevel(userID, roomID); ok {
+		sender.Role = internal.Properties{"power_level": pl}
+	}
+	return sender
+}
+
+// getPowerLevel 获取用户在房间中的权限等级
+// 参数:
+//   - userID: 用户 ID
+//   - roomID: 房间 ID
+//
+// 返回:
+//   - int: 权限等级
+//   - bool: 是否获取成功
+func (m *Matrix) getPowerLevel(userID id.UserID, roomID id.RoomID) (int, bool) {
+	pl, ok := m.getPowerLevels(roomID)
+	if !ok {
+		return 0, false
+	}
+	return pl.GetUserLevel(userID), true
+}
+
+// getPowerLevels 获取房间的权限设置
+// 权限状态持久化在状态存储中，并在内存中缓存，收到 m.room.power_levels 事件时失效
+// 参数:
+//   - roomID: 房间 ID
+//
+// 返回:
+//   - *event.PowerLevelsEventContent: 权限设置
+//   - bool: 是否获取成功
+func (m *Matrix) getPowerLevels(roomID id.RoomID) (*event.PowerLevelsEventContent, bool) {
+	cacheKey := "power_" + roomID.String()
+	if cached := m.cache.Get(cacheKey); cached != nil {
+		return cached.Value().(*event.PowerLevelsEventContent), true
+	}
+
+	pl, err := m.as.BotIntent().PowerLevels(context.Background(), roomID)
+	if err != nil {
+		slog.Debug("Matrix 获取权限等级失败", "room_id", roomID, "error", err)
+		return nil, false
+	}
+	m.cache.Set(cacheKey, pl, ttlcache.DefaultTTL)
+	return pl, true
+}
+
+// getMemberInfo 获取房间成员的显示信息
+// 优先使用状态存储中的成员信息，并在内存中缓存转换后的结果
+// 参数:
+//   - userID: 用户 ID
+//   - roomID: 房间 ID
+//
+// 返回:
 //   - name: 显示名称
 //   - avatar: 头像 URL
 func (m *Matrix) getMemberInfo(userID id.UserID, roomID id.RoomID) (name, avatar string) {
 	name = userID.String() // 默认使用用户 ID
-	cacheKey := "member_" + userID.String()
+	cacheKey := "member_" + roomID.String() + "_" + userID.String()
 
 	// 先查缓存
-	if cached, ok := m.cache.Load(cacheKey); ok {
-		if memberData, ok := cached.(map[string]string); ok {
+	if cached := m.cache.Get(cacheKey); cached != nil {
+		if memberData, ok := cached.Value().(map[string]string); ok {
 			return memberData["name"], memberData["avatar"]
 		}
 	}
@@ -145,17 +457,13 @@ func (m *Matrix) getMemberInfo(userID id.UserID, roomID id.RoomID) (name, avatar
 		}
 		if member.AvatarURL != "" {
 			avatar = m.mxcToURL(string(member.AvatarURL)) // 转换头像 URL
-			slog.Debug("Matrix 转换头像URL",
-				"mxc", member.AvatarURL,
-				"http_url", avatar,
-			)
 		}
 
 		// 缓存成员信息
-		m.cache.Store(cacheKey, map[string]string{
+		m.cache.Set(cacheKey, map[string]string{
 			"name":   name,
 			"avatar": avatar,
-		})
+		}, ttlcache.DefaultTTL)
 	} else {
 		slog.Warn("Matrix 获取成员信息失败",
 			"user_id", userID,
@@ -171,71 +479,280 @@ func (m *Matrix) getMemberInfo(userID id.UserID, roomID id.RoomID) (name, avatar
 //   - content: Matrix 消息内容
 //
 // 返回:
-//   - []internal.Seg: 消息段列表
-func (m *Matrix) parseMessageContent(content *event.MessageEventContent) []internal.Seg {
+//   - []internal.Segment: 消息段列表
+func (m *Matrix) parseMessageContent(content *event.MessageEventContent) []internal.Segment {
 	switch content.MsgType {
 	case event.MsgText, event.MsgNotice, event.MsgEmote:
-		// 文本类消息
-		body := stripFallback(content.Body) // 去除回复引用部分
+		// 文本类消息：优先解析 HTML 格式内容，保留样式和提及
+		var segs []internal.Segment
+		if content.Format == event.FormatHTML && content.FormattedBody != "" {
+			segs = parseHTML(content.FormattedBody)
+		}
+		if len(segs) == 0 {
+			segs = []internal.Segment{{Type: internal.SegText, Text: stripFallback(content.Body)}} // 去除回复引用部分
+		}
 		if content.MsgType == event.MsgEmote {
-			body = "* " + body // Emote 消息添加前缀
+			segs = append([]internal.Segment{{Type: internal.SegText, Text: "* "}}, segs...) // Emote 消息添加前缀
 		}
-		return []internal.Seg{{
-			Kind: "text",
-			Raw:  internal.Props{"txt": body},
-		}}
+		return segs
 
 	case event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile:
 		// 媒体类消息
-		kind := map[event.MessageType]string{
-			event.MsgImage: "image",
-			event.MsgVideo: "video",
-			event.MsgAudio: "audio",
-			event.MsgFile:  "file",
+		segType := map[event.MessageType]internal.SegmentType{
+			event.MsgImage: internal.SegImage,
+			event.MsgVideo: internal.SegVideo,
+			event.MsgAudio: internal.SegAudio,
+			event.MsgFile:  internal.SegFile,
 		}[content.MsgType]
 
-		url := m.mxcToURL(string(content.URL)) // 转换 MXC URL 为 HTTP URL
-		fileName := content.Body
-		if content.FileName != "" {
-			fileName = content.FileName // 使用文件名（如果有）
+		// 加密房间中的媒体以 File 给出，记录解密信息后由媒体代理下载并解密
+		mxc := content.URL
+		if content.File != nil {
+			mxc = content.File.URL
+			var mimeType string
+			if content.Info != nil {
+				mimeType = content.Info.MimeType
+			}
+			if err := m.store.SaveEncryptedMedia(context.Background(), content.File, mimeType); err != nil {
+				slog.Warn("Matrix 记录加密媒体失败", "mxc", mxc, "error", err)
+			}
 		}
 
-		props := internal.Props{"url": url, "name": fileName}
-		if content.Info != nil && content.Info.Size > 0 {
-			props["size"] = content.Info.Size // 添加文件大小
+		file := &internal.FileInfo{
+			ID:   string(mxc),
+			URL:  m.mxcToURL(string(mxc)), // 转换 MXC URL 为媒体代理链接
+			Name: content.Body,
+		}
+		if content.FileName != "" {
+			file.Name = content.FileName // 使用文件名（如果有）
+		}
+		if content.Info != nil {
+			file.MimeType = content.Info.MimeType
+			file.Size = int64(content.Info.Size)
+			file.Width = content.Info.Width
+			file.Height = content.Info.Height
+			file.Duration = content.Info.Duration / 1000 // Matrix 时长单位为毫秒
 		}
 
-		return []internal.Seg{{Kind: kind, Raw: props}}
+		return []internal.Segment{{Type: segType, File: file}}
 
 	default:
 		// 未知消息类型
-		return []internal.Seg{{
-			Kind: "text",
-			Raw:  internal.Props{"txt": fmt.Sprintf("[Matrix: %s]", content.MsgType)},
-		}}
+		return []internal.Segment{{Type: internal.SegText, Text: fmt.Sprintf("[Matrix: %s]", content.MsgType)}}
+	}
+}
+
+// replySender 获取被回复消息的发送者，结果会被缓存
+// 参数:
+//   - roomID: 房间 ID
+//   - eventID: 被回复消息的事件 ID，为空表示不是回复
+//
+// 返回:
+//   - id.UserID: 发送者，未知时为空
+func (m *Matrix) replySender(roomID id.RoomID, eventID id.EventID) id.UserID {
+	if eventID == "" {
+		return ""
+	}
+	cacheKey := "sender_" + eventID.String()
+	if cached := m.cache.Get(cacheKey); cached != nil {
+		if sender, ok := cached.Value().(id.UserID); ok {
+			return sender
+		}
+	}
+
+	evt, err := m.as.BotIntent().GetEvent(context.Background(), roomID, eventID)
+	if err != nil {
+		slog.Debug("Matrix 获取被回复消息失败", "id", eventID, "error", err)
+		return ""
+	}
+	m.cache.Set(cacheKey, evt.Sender, ttlcache.DefaultTTL)
+	return evt.Sender
+}
+
+// parseMentions 根据消息的 m.mentions 补充提及段
+// HTML 中没有对应 pill 的被提及用户补充在消息开头（被回复消息的发送者除外）；提及房间（@room）时，
+// 发送者有权限提醒全体成员则将文本中的 "@room" 转换为全体提及段。
+// 未携带 m.mentions 的旧版客户端以正文中的 "@room" 判断
+// 参数:
+//   - segs: 已解析的消息段
+//   - content: Matrix 消息内容
+//   - sender: 发送者
+//   - roomID: 房间 ID
+//
+// 返回:
+//   - []internal.Segment: 补充提及后的消息段
+func (m *Matrix) parseMentions(segs []internal.Segment, content *event.MessageEventContent, sender id.UserID, roomID id.RoomID) []internal.Segment {
+	switch content.MsgType {
+	case event.MsgText, event.MsgNotice, event.MsgEmote:
+	default:
+		return segs // 媒体消息的正文是文件名
+	}
+
+	room := strings.Contains(content.Body, "@room")
+	if content.Mentions != nil {
+		room = content.Mentions.Room
+
+		mentioned := make(map[string]bool)
+		for _, s := range segs {
+			if s.Type == internal.SegMention {
+				mentioned[s.ID] = true
+			}
+		}
+		var missing []id.UserID
+		for _, userID := range content.Mentions.UserIDs {
+			if !mentioned[userID.String()] {
+				missing = append(missing, userID)
+			}
+		}
+		if len(missing) > 0 {
+			// 客户端会自动提及被回复消息的发送者，回复本身已表达了这一关系
+			replied := m.replySender(roomID, content.RelatesTo.GetNonFallbackReplyTo())
+			var extra []internal.Segment
+			for _, userID := range missing {
+				if userID != replied {
+					name, _ := m.getMemberInfo(userID, roomID)
+					extra = append(extra, internal.Segment{Type: internal.SegMention, ID: userID.String(), Text: name})
+				}
+			}
+			segs = append(extra, segs...)
+		}
+	}
+
+	if !room {
+		return segs
+	}
+	if pl, ok := m.getPowerLevels(roomID); !ok || pl.GetUserLevel(sender) < pl.Notifications.Room() {
+		return segs
+	}
+	all := internal.Segment{Type: internal.SegMention, ID: internal.MentionAll, Text: "@room"}
+	for i, s := range segs {
+		if s.Type != internal.SegText || s.HasStyle(internal.StyleCode) || s.HasStyle(internal.StylePre) {
+			continue
+		}
+		if before, after, ok := strings.Cut(s.Text, "@room"); ok {
+			rest := []internal.Segment{all}
+			if after != "" {
+				rest = append(rest, internal.Segment{Type: internal.SegText, Text: after, Extra: s.Extra})
+			}
+			out := append([]internal.Segment{}, segs[:i]...)
+			if before != "" {
+				out = append(out, internal.Segment{Type: internal.SegText, Text: before, Extra: s.Extra})
+			}
+			return append(append(out, rest...), segs[i+1:]...)
+		}
+	}
+	return append([]internal.Segment{all}, segs...)
+}
+
+// resolveGhostMentions 将提及 Ghost 用户的提及段还原为其代表的其他平台用户
+// 参数:
+//   - segs: 消息段（原地修改）
+func (m *Matrix) resolveGhostMentions(segs []internal.Segment) {
+	for i := range segs {
+		s := &segs[i]
+		if s.Type != internal.SegMention || s.ID == internal.MentionAll {
+			continue
+		}
+		platform, userID, ok := m.parseGhostUserID(id.UserID(s.ID))
+		if !ok {
+			continue
+		}
+		s.ID = userID
+		s.Extra = internal.Properties{internal.MentionPlatform: platform}
 	}
 }
 
 // handleRedaction 处理 Matrix 撤回事件
-// 转换为内部通知事件
+// 转换为内部撤回事件
 // 参数:
 //   - evt: Matrix 撤回事件
 func (m *Matrix) handleRedaction(evt *event.Event) {
+	redacts := evt.Redacts
+	if redacts == "" {
+		// 新版房间中被撤回的事件 ID 位于 content 内
+		redacts = evt.Content.AsRedaction().Redacts
+	}
+
+	// 撤回的是表态事件时转换为取消表态
+	ctx := context.Background()
+	if r, err := m.store.GetReaction(ctx, redacts); err != nil {
+		slog.Warn("Matrix 查询表态记录失败", "id", redacts, "error", err)
+	} else if r != nil {
+		if err := m.store.DeleteReaction(ctx, redacts); err != nil {
+			slog.Warn("Matrix 删除表态记录失败", "id", redacts, "error", err)
+		}
+		m.api.Receive(ctx, m.reactionEvent(evt, r.Target, r.Key, true))
+		return
+	}
+
 	e := &internal.Event{
-		ID:   evt.ID.String(),
-		Kind: internal.Note,
-		Time: time.UnixMilli(evt.Timestamp),
-		Plat: m.Name(),
-		Room: evt.RoomID.String(),
-		User: evt.Sender.String(),
-		Ref:  evt.Redacts.String(), // 被撤回的消息 ID
-		Extra: internal.Props{
-			"subtype": internal.Revoke,
-		},
-	}
-	e.Segs = []internal.Seg{{Kind: "text", Raw: internal.Props{"txt": "撤回消息"}}}
-
-	m.router.Handle(context.Background(), e)
+		ID:       evt.ID.String(),
+		Type:     internal.TypeRevoke,
+		Time:     time.UnixMilli(evt.Timestamp),
+		Platform: m.name(),
+		RoomID:   evt.RoomID.String(),
+		Sender:   m.getSender(evt.Sender, evt.RoomID),
+		RefID:    redacts.String(), // 被撤回的消息 ID
+	}
+
+	m.api.Receive(ctx, e)
+}
+
+// handleReaction 处理 Matrix 表态事件（m.reaction）
+// 记录表态事件以便撤回时识别，并转换为内部表态事件
+// 参数:
+//   - evt: Matrix 表态事件
+func (m *Matrix) handleReaction(evt *event.Event) {
+	content := evt.Content.AsReaction()
+	if content.RelatesTo.Type != event.RelAnnotation || content.RelatesTo.EventID == "" || content.RelatesTo.Key == "" {
+		return
+	}
+
+	ctx := context.Background()
+	err := m.store.SaveReaction(ctx, &reaction{
+		EventID: evt.ID,
+		RoomID:  evt.RoomID,
+		Sender:  evt.Sender,
+		Target:  content.RelatesTo.EventID,
+		Key:     content.RelatesTo.Key,
+	})
+	if err != nil {
+		slog.Warn("Matrix 保存表态记录失败", "id", evt.ID, "error", err)
+	}
+
+	slog.Debug("Matrix 处理表态",
+		"id", evt.ID,
+		"target", content.RelatesTo.EventID,
+		"key", content.RelatesTo.Key,
+		"user", evt.Sender,
+	)
+	m.api.Receive(ctx, m.reactionEvent(evt, content.RelatesTo.EventID, content.RelatesTo.Key, false))
+}
+
+// reactionEvent 构建内部表态事件
+// 参数:
+//   - evt: 触发的 Matrix 事件（表态或撤回）
+//   - target: 被表态的事件 ID
+//   - key: 表情
+//   - remove: 是否为取消表态
+//
+// 返回:
+//   - *internal.Event: 内部表态事件
+func (m *Matrix) reactionEvent(evt *event.Event, target id.EventID, key string, remove bool) *internal.Event {
+	seg := internal.Segment{Type: internal.SegReaction, Text: key}
+	if remove {
+		seg.Extra = internal.Properties{"remove": true}
+	}
+	return &internal.Event{
+		ID:       evt.ID.String(),
+		Type:     internal.TypeReaction,
+		Time:     time.UnixMilli(evt.Timestamp),
+		Platform: m.name(),
+		RoomID:   evt.RoomID.String(),
+		Sender:   m.getSender(evt.Sender, evt.RoomID),
+		RefID:    target.String(), // 被表态的消息 ID
+		Segments: []internal.Segment{seg},
+	}
 }
 
 // stripFallback 去除 Matrix 回复消息的引用部分
@@ -261,34 +778,3 @@ func stripFallback(s string) string {
 	}
 	return s
 }
-
-// mxcToURL 将 Matrix MXC URI 转换为 HTTP URL
-// 参数:
-//   - mxc: MXC URI (mxc://服务器/媒体ID)
-//
-// 返回:
-//   - string: HTTP URL 或原始 MXC（如果格式不正确）
-func (m *Matrix) mxcToURL(mxc string) string {
-	if len(mxc) > 6 && mxc[:6] == "mxc://" {
-		uri, err := id.ParseContentURI(mxc)
-		if err != nil {
-			slog.Warn("Matrix 解析MXC URI失败",
-				"mxc", mxc,
-				"error", err,
-			)
-			return mxc
-		}
-		// 构建媒体下载 URL
-		httpURL := fmt.Sprintf("https://%s/_matrix/media/v3/download/%s/%s", m.cfg.ServerDomain, uri.Homeserver, uri.FileID)
-		slog.Debug("Matrix MXC转HTTP URL",
-			"mxc", mxc,
-			"homeserver", uri.Homeserver,
-			"file_id", uri.FileID,
-			"http_url", httpURL,
-			"server_domain", m.cfg.ServerDomain,
-		)
-		return httpURL
-	}
-	slog.Debug("Matrix MXC格式无效，返回原值", "mxc", mxc)
-	return mxc
-}
@@ -0,0 +1,183 @@
+package matrix
+
+import (
+	"reflect"
+	"testing"
+
+	"Relify/internal"
+
+	"github.com/jellydator/ttlcache/v3"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// newTestMatrix 创建只依赖缓存的驱动实例，成员信息和权限等级预先写入缓存，不访问 Homeserver。
+func newTestMatrix(roomID id.RoomID, pl *event.PowerLevelsEventContent, members map[id.UserID]string) *Matrix {
+	m := &Matrix{
+		cfg:   &Config{Domain: "example.org", AppService: AppServiceConfig{Namespace: "relify_"}},
+		cache: newCache(),
+	}
+	m.cache.Set("power_"+roomID.String(), pl, ttlcache.DefaultTTL)
+	for userID, name := range members {
+		m.cache.Set("member_"+roomID.String()+"_"+userID.String(), map[string]string{"name": name, "avatar": ""}, ttlcache.DefaultTTL)
+	}
+	return m
+}
+
+func TestParseMentions(t *testing.T) {
+	const roomID = id.RoomID("!room:example.org")
+	const (
+		mod  = id.UserID("@mod:example.org")
+		user = id.UserID("@user:example.org")
+		bob  = id.UserID("@bob:example.org")
+	)
+	const replyID = id.EventID("$reply")
+	pl := &event.PowerLevelsEventContent{Users: map[id.UserID]int{mod: 50}}
+	m := newTestMatrix(roomID, pl, map[id.UserID]string{bob: "Bob"})
+	m.cache.Set("sender_"+replyID.String(), user, ttlcache.DefaultTTL)
+	reply := (&event.RelatesTo{}).SetReplyTo(replyID)
+
+	text := func(s string) internal.Segment { return internal.Segment{Type: internal.SegText, Text: s} }
+	code := internal.Segment{Type: internal.SegText, Text: "@room", Extra: internal.Properties{internal.StyleCode: true}}
+	all := internal.Segment{Type: internal.SegMention, ID: internal.MentionAll, Text: "@room"}
+	pill := internal.Segment{Type: internal.SegMention, ID: bob.String(), Text: "Bob"}
+
+	tests := []struct {
+		name    string
+		segs    []internal.Segment
+		content event.MessageEventContent
+		sender  id.UserID
+		want    []internal.Segment
+	}{
+		{
+			name:    "媒体消息不处理",
+			segs:    []internal.Segment{text("@room.png")},
+			content: event.MessageEventContent{MsgType: event.MsgImage, Body: "@room.png", Mentions: &event.Mentions{Room: true}},
+			sender:  mod,
+			want:    []internal.Segment{text("@room.png")},
+		},
+		{
+			name:    "已有 pill 的用户不重复",
+			segs:    []internal.Segment{pill, text(" hi")},
+			content: event.MessageEventContent{MsgType: event.MsgText, Body: "Bob hi", Mentions: &event.Mentions{UserIDs: []id.UserID{bob}}},
+			sender:  user,
+			want:    []internal.Segment{pill, text(" hi")},
+		},
+		{
+			name:    "补充没有 pill 的用户",
+			segs:    []internal.Segment{text("hi")},
+			content: event.MessageEventContent{MsgType: event.MsgText, Body: "hi", Mentions: &event.Mentions{UserIDs: []id.UserID{bob}}},
+			sender:  user,
+			want:    []internal.Segment{pill, text("hi")},
+		},
+		{
+			name:    "不补充被回复消息的发送者",
+			segs:    []internal.Segment{text("ok")},
+			content: event.MessageEventContent{MsgType: event.MsgText, Body: "ok", Mentions: &event.Mentions{UserIDs: []id.UserID{user, bob}}, RelatesTo: reply},
+			sender:  mod,
+			want:    []internal.Segment{pill, text("ok")},
+		},
+		{
+			name:    "有权限时拆分 @room",
+			segs:    []internal.Segment{text("hi @room now")},
+			content: event.MessageEventContent{MsgType: event.MsgText, Body: "hi @room now", Mentions: &event.Mentions{Room: true}},
+			sender:  mod,
+			want:    []internal.Segment{text("hi "), all, text(" now")},
+		},
+		{
+			name:    "无权限时保留文本",
+			segs:    []internal.Segment{text("hi @room")},
+			content: event.MessageEventContent{MsgType: event.MsgText, Body: "hi @room", Mentions: &event.Mentions{Room: true}},
+			sender:  user,
+			want:    []internal.Segment{text("hi @room")},
+		},
+		{
+			name:    "m.mentions 未提及房间时忽略正文",
+			segs:    []internal.Segment{text("@room")},
+			content: event.MessageEventContent{MsgType: event.MsgText, Body: "@room", Mentions: &event.Mentions{}},
+			sender:  mod,
+			want:    []internal.Segment{text("@room")},
+		},
+		{
+			name:    "旧版客户端按正文判断",
+			segs:    []internal.Segment{text("@room")},
+			content: event.MessageEventContent{MsgType: event.MsgText, Body: "@room"},
+			sender:  mod,
+			want:    []internal.Segment{all},
+		},
+		{
+			name:    "代码中的 @room 不转换",
+			segs:    []internal.Segment{code, text(" see")},
+			content: event.MessageEventContent{MsgType: event.MsgNotice, Body: "@room see", Mentions: &event.Mentions{Room: true}},
+			sender:  mod,
+			want:    []internal.Segment{all, code, text(" see")},
+		},
+		{
+			name:    "同时提及用户和房间",
+			segs:    []internal.Segment{text("@room")},
+			content: event.MessageEventContent{MsgType: event.MsgText, Body: "@room", Mentions: &event.Mentions{Room: true, UserIDs: []id.UserID{bob}}},
+			sender:  mod,
+			want:    []internal.Segment{pill, all},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := m.parseMentions(tt.segs, &tt.content, tt.sender, roomID)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMentions = %#v\n期望 %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveGhostMentions(t *testing.T) {
+	m := newTestMatrix("!room:example.org", &event.PowerLevelsEventContent{}, nil)
+
+	tests := []struct {
+		name string
+		in   internal.Segment
+		want internal.Segment
+	}{
+		{
+			name: "Ghost 用户",
+			in:   internal.Segment{Type: internal.SegMention, ID: "@relify_qq_10001:example.org", Text: "Alice"},
+			want: internal.Segment{Type: internal.SegMention, ID: "10001", Text: "Alice", Extra: internal.Properties{internal.MentionPlatform: "qq"}},
+		},
+		{
+			name: "Ghost 用户 ID 含下划线",
+			in:   internal.Segment{Type: internal.SegMention, ID: "@relify_telegram_a_b:example.org"},
+			want: internal.Segment{Type: internal.SegMention, ID: "a_b", Extra: internal.Properties{internal.MentionPlatform: "telegram"}},
+		},
+		{
+			name: "普通 Matrix 用户",
+			in:   internal.Segment{Type: internal.SegMention, ID: "@alice:example.org"},
+			want: internal.Segment{Type: internal.SegMention, ID: "@alice:example.org"},
+		},
+		{
+			name: "其他服务器上的同名用户",
+			in:   internal.Segment{Type: internal.SegMention, ID: "@relify_qq_10001:other.org"},
+			want: internal.Segment{Type: internal.SegMention, ID: "@relify_qq_10001:other.org"},
+		},
+		{
+			name: "Bot 用户",
+			in:   internal.Segment{Type: internal.SegMention, ID: "@relify:example.org"},
+			want: internal.Segment{Type: internal.SegMention, ID: "@relify:example.org"},
+		},
+		{
+			name: "全体提及",
+			in:   internal.Segment{Type: internal.SegMention, ID: internal.MentionAll},
+			want: internal.Segment{Type: internal.SegMention, ID: internal.MentionAll},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segs := []internal.Segment{tt.in}
+			m.resolveGhostMentions(segs)
+			if !reflect.DeepEqual(segs[0], tt.want) {
+				t.Errorf("resolveGhostMentions = %#v\n期望 %#v", segs[0], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package matrix
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// mediaPath 是媒体代理在 AppService 监听端口上的路径前缀
+const mediaPath = "/_relify/media/"
+
+// defaultMediaTTL 是媒体代理链接的默认有效期
+const defaultMediaTTL = time.Hour
+
+// defaultUploadLimit 是无法获取 Homeserver 上传大小限制时使用的上限
+const defaultUploadLimit = 100 << 20
+
+// mxcToURL 将 Matrix MXC URI 转换为媒体代理的短期链接
+// 许多 Homeserver 已停止提供未认证的媒体下载，其他平台通过代理获取媒体，由代理使用认证接口从 Homeserver 下载。
+// 链接的过期时间按有效期对齐，同一媒体在一个有效期内生成的链接相同，实际有效时间为一到两个有效期
+// 参数:
+//   - mxc: MXC URI (mxc://服务器/媒体ID)
+//
+// 返回:
+//   - string: 代理链接或原始 MXC（如果格式不正确）
+func (m *Matrix) mxcToURL(mxc string) string {
+	if !strings.HasPrefix(mxc, "mxc://") {
+		slog.Debug("Matrix MXC格式无效，返回原值", "mxc", mxc)
+		return mxc
+	}
+	uri, err := id.ParseContentURI(mxc)
+	if err != nil {
+		slog.Warn("Matrix 解析MXC URI失败",
+			"mxc", mxc,
+			"error", err,
+		)
+		return mxc
+	}
+
+	ttl := int64(m.mediaTTL() / time.Second)
+	expires := (time.Now().Unix()/ttl + 2) * ttl
+	path := fmt.Sprintf("%s/%s/%d", uri.Homeserver, uri.FileID, expires)
+	return fmt.Sprintf("%s%s%s/%s", strings.TrimRight(m.cfg.Media.PublicURL, "/"), mediaPath, path, m.mediaSignature(path))
+}
+
+// mediaTTL 返回媒体代理链接的有效期
+func (m *Matrix) mediaTTL() time.Duration {
+	if m.cfg.Media.TTL > 0 {
+		return time.Duration(m.cfg.Media.TTL) * time.Second
+	}
+	return defaultMediaTTL
+}
+
+// mediaSignature 计算媒体代理链接的签名
+// 签名密钥由 hs_token 派生，重启后已生成的链接仍然有效
+func (m *Matrix) mediaSignature(path string) string {
+	mac := hmac.New(sha256.New, []byte(m.as.Registration.ServerToken))
+	mac.Write([]byte("relify-media:" + path))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// serveMedia 处理媒体代理请求
+// 校验链接签名和过期时间后，通过 Homeserver 的认证媒体接口（MSC3916）下载并转发媒体内容；
+// 加密房间中的媒体下载完整内容并校验摘要、解密后再返回。
+// 路径格式为 /_relify/media/<服务器>/<媒体ID>/<过期时间>/<签名>
+func (m *Matrix) serveMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, mediaPath), "/")
+	if len(parts) != 4 {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.Join(parts[:3], "/")
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || !hmac.Equal([]byte(parts[3]), []byte(m.mediaSignature(path))) {
+		http.NotFound(w, r)
+		return
+	}
+	remaining := expires - time.Now().Unix()
+	if remaining <= 0 {
+		http.Error(w, "link expired", http.StatusGone)
+		return
+	}
+
+	uri := id.ContentURI{Homeserver: parts[0], FileID: parts[1]}
+	resp, err := m.as.BotClient().Download(r.Context(), uri)
+	if err != nil {
+		status := http.StatusBadGateway
+		var httpErr mautrix.HTTPError
+		if errors.As(err, &httpErr) && httpErr.IsStatus(http.StatusNotFound) {
+			status = http.StatusNotFound
+		}
+		slog.Warn("Matrix 媒体代理下载失败", "mxc", uri, "error", err)
+		http.Error(w, "download failed", status)
+		return
+	}
+	defer resp.Body.Close()
+
+	file, mimeType, err := m.store.GetEncryptedMedia(r.Context(), uri.String())
+	if err != nil {
+		slog.Warn("Matrix 媒体代理查询加密媒体失败", "mxc", uri, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if file != nil {
+		m.serveEncryptedMedia(w, r, resp, file, mimeType, remaining)
+		return
+	}
+
+	for _, h := range []string{"Content-Type", "Content-Length", "Content-Disposition"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", remaining))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		slog.Debug("Matrix 媒体代理传输中断", "mxc", uri, "error", err)
+	}
+}
+
+// serveEncryptedMedia 解密并返回加密媒体
+// 参数:
+//   - w: 响应
+//   - r: 请求
+//   - resp: Homeserver 的下载响应（密文）
+//   - file: 解密信息
+//   - mimeType: 消息中声明的 MIME 类型
+//   - remaining: 链接剩余有效秒数
+func (m *Matrix) serveEncryptedMedia(w http.ResponseWriter, r *http.Request, resp *http.Response, file *event.EncryptedFileInfo, mimeType string, remaining int64) {
+	// 解密需要完整内容，按 Homeserver 的上传上限读取，避免异常响应耗尽内存
+	limit := m.uploadLimit(r.Context())
+	if resp.ContentLength > limit {
+		slog.Warn("Matrix 媒体代理拒绝超过上传上限的媒体", "mxc", file.URL, "size", resp.ContentLength, "limit", limit)
+		http.Error(w, "media too large", http.StatusBadGateway)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err == nil && int64(len(data)) > limit {
+		slog.Warn("Matrix 媒体代理拒绝超过上传上限的媒体", "mxc", file.URL, "limit", limit)
+		http.Error(w, "media too large", http.StatusBadGateway)
+		return
+	}
+	if err == nil {
+		err = file.DecryptInPlace(data)
+	}
+	if err != nil {
+		slog.Warn("Matrix 媒体代理解密失败", "mxc", file.URL, "error", err)
+		http.Error(w, "decrypt failed", http.StatusBadGateway)
+		return
+	}
+
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", remaining))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(data); err != nil {
+		slog.Debug("Matrix 媒体代理传输中断", "mxc", file.URL, "error", err)
+	}
+}
+
+// uploadLimit 返回 Homeserver 允许上传的最大字节数，结果会被缓存
+// 获取失败或 Homeserver 未声明时使用 defaultUploadLimit
+func (m *Matrix) uploadLimit(ctx context.Context) int64 {
+	if cached := m.cache.Get("media_upload_size"); cached != nil {
+		if size, ok := cached.Value().(int64); ok {
+			return size
+		}
+	}
+
+	size := int64(defaultUploadLimit)
+	resp, err := m.as.BotClient().GetMediaConfig(ctx)
+	if err != nil {
+		slog.Debug("Matrix 获取媒体配置失败，使用默认上传上限", "error", err)
+		return size
+	}
+	if resp.UploadSize > 0 {
+		size = resp.UploadSize
+	}
+	m.cache.Set("media_upload_size", size, ttlcache.DefaultTTL)
+	return size
+}
@@ -0,0 +1,138 @@
+package matrix
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+	"maunium.net/go/mautrix/appservice"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// newMediaTestMatrix 创建只用于生成和校验媒体代理链接的驱动实例。
+func newMediaTestMatrix(token string, ttl int) *Matrix {
+	return &Matrix{
+		cfg:   &Config{Media: MediaConfig{PublicURL: "https://relify.example.org/", TTL: ttl}},
+		as:    &appservice.AppService{Registration: &appservice.Registration{ServerToken: token}},
+		cache: newCache(),
+	}
+}
+
+func TestMxcToURL(t *testing.T) {
+	m := newMediaTestMatrix("hs", 600)
+
+	for _, bad := range []string{"https://example.org/a.png", "mxc://example.org", ""} {
+		if got := m.mxcToURL(bad); got != bad {
+			t.Errorf("mxcToURL(%q) = %q，期望原样返回", bad, got)
+		}
+	}
+
+	const prefix = "https://relify.example.org" + mediaPath + "example.org/abc/"
+	before := time.Now().Unix()
+	link := m.mxcToURL("mxc://example.org/abc")
+	after := time.Now().Unix()
+	rest, ok := strings.CutPrefix(link, prefix)
+	if !ok {
+		t.Fatalf("mxcToURL = %q，期望以 %q 开头", link, prefix)
+	}
+	expiresStr, sig, _ := strings.Cut(rest, "/")
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		t.Fatalf("过期时间无效: %q", expiresStr)
+	}
+	if sig != m.mediaSignature("example.org/abc/"+expiresStr) {
+		t.Errorf("签名 %q 与路径不符", sig)
+	}
+
+	// 过期时间按有效期对齐，实际有效时间为一到两个有效期
+	if expires%600 != 0 || expires <= before+600 || expires > after+1200 {
+		t.Errorf("过期时间 %d 不在 (%d, %d] 内或未按有效期对齐", expires, before+600, after+1200)
+	}
+	// 同一有效期内生成的链接相同
+	if again := m.mxcToURL("mxc://example.org/abc"); again != link && time.Now().Unix()/600 == before/600 {
+		t.Errorf("同一有效期内链接不同: %q, %q", link, again)
+	}
+}
+
+func TestMediaSignature(t *testing.T) {
+	a, b := newMediaTestMatrix("hs", 0), newMediaTestMatrix("other", 0)
+	const path = "example.org/abc/1700000000"
+
+	if a.mediaSignature(path) != newMediaTestMatrix("hs", 0).mediaSignature(path) {
+		t.Error("相同 hs_token 的签名应相同")
+	}
+	if a.mediaSignature(path) == b.mediaSignature(path) {
+		t.Error("不同 hs_token 的签名应不同")
+	}
+	if a.mediaSignature(path) == a.mediaSignature("example.org/abc/1700003600") {
+		t.Error("不同过期时间的签名应不同")
+	}
+	if got := len(a.mediaSignature(path)); got != 22 {
+		t.Errorf("签名长度 = %d，期望 22", got)
+	}
+}
+
+func TestServeMedia(t *testing.T) {
+	m := newMediaTestMatrix("hs", 0)
+	link := func(expires int64) string {
+		path := fmt.Sprintf("example.org/abc/%d", expires)
+		return mediaPath + path + "/" + m.mediaSignature(path)
+	}
+	expired := time.Now().Unix() - 10
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{"链接已过期", http.MethodGet, link(expired), http.StatusGone},
+		{"签名错误", http.MethodGet, mediaPath + "example.org/abc/9999999999/bad", http.StatusNotFound},
+		{"篡改过期时间", http.MethodGet, strings.Replace(link(expired), strconv.FormatInt(expired, 10), "9999999999", 1), http.StatusNotFound},
+		{"过期时间无效", http.MethodGet, mediaPath + "example.org/abc/never/" + m.mediaSignature("example.org/abc/never"), http.StatusNotFound},
+		{"路径段数不符", http.MethodGet, mediaPath + "example.org/abc", http.StatusNotFound},
+		{"不支持的方法", http.MethodPost, link(expired), http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			m.serveMedia(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("状态码 = %d，期望 %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeEncryptedMediaLimit(t *testing.T) {
+	m := newMediaTestMatrix("hs", 0)
+	m.cache.Set("media_upload_size", int64(16), ttlcache.DefaultTTL)
+	file := &event.EncryptedFileInfo{URL: id.ContentURIString("mxc://example.org/abc")}
+
+	tests := []struct {
+		name          string
+		contentLength int64
+	}{
+		{"声明的长度超过上限", 32},
+		{"未声明长度", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{ContentLength: tt.contentLength, Body: io.NopCloser(strings.NewReader(strings.Repeat("x", 32)))}
+			rec := httptest.NewRecorder()
+			m.serveEncryptedMedia(rec, httptest.NewRequest(http.MethodGet, "/", nil), resp, file, "", 60)
+			if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "too large") {
+				t.Errorf("状态码 = %d (%s)，期望拒绝超过上限的媒体", rec.Code, strings.TrimSpace(rec.Body.String()))
+			}
+		})
+	}
+}
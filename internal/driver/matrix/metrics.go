@@ -0,0 +1,19 @@
+package matrix
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// uploadSize 记录上传到 Matrix 媒体仓库的文件大小
+var uploadSize = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "relify_matrix_upload_bytes",
+	Help:    "上传到 Matrix 媒体仓库的文件大小（字节）。",
+	Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+})
+
+// rejectedRequests 记录 AppService 监听端口拒绝的请求数
+var rejectedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "relify_matrix_rejected_requests_total",
+	Help: "AppService 监听端口拒绝的请求数，按原因（ip、missing_token、invalid_token）分类。",
+}, []string{"reason"})
@@ -0,0 +1,147 @@
+package matrix
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+
+	"Relify/internal"
+
+	"maunium.net/go/mautrix/appservice"
+)
+
+// botLocalpart 是 Bot 用户的本地部分
+const botLocalpart = "relify"
+
+// Registration 返回 Matrix 平台配置对应的 AppService 注册信息及注册文件路径
+// 与驱动启动时的行为一致：首次调用时生成令牌并写入注册文件，供 relify matrix registration 命令使用
+// 参数:
+//   - props: Matrix 平台的 config 段
+//
+// 返回:
+//   - *appservice.Registration: 注册信息
+//   - string: 注册文件路径
+//   - error: 解析或写入错误
+func Registration(props internal.Properties) (*appservice.Registration, string, error) {
+	cfg, err := parseConfig(props)
+	if err != nil {
+		return nil, "", err
+	}
+	reg, err := ensureRegistration(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	return reg, cfg.AppService.Registration, nil
+}
+
+// ensureRegistration 生成驱动使用的 AppService 注册信息，并与注册文件保持一致
+// 令牌来源依次为：配置中的 as_token / hs_token、旧版 token、注册文件中已持久化的令牌、新生成的随机令牌
+// 注册文件不存在或内容与当前配置不一致时重新写入
+// 参数:
+//   - cfg: Matrix 配置
+//
+// 返回:
+//   - *appservice.Registration: 注册信息
+//   - error: 读取或写入注册文件错误
+func ensureRegistration(cfg *Config) (*appservice.Registration, error) {
+	path := cfg.AppService.Registration
+	saved, err := appservice.LoadRegistration(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("读取注册文件失败: %w", err)
+	}
+
+	if cfg.AppService.Token != "" && cfg.AppService.ASToken == "" && cfg.AppService.HSToken == "" {
+		slog.Warn("Matrix 旧版 token 同时用作 as_token 和 hs_token，建议分别配置以免出站令牌泄露后被伪造推送")
+	}
+
+	reg := buildRegistration(cfg)
+	reg.AppToken = firstToken(cfg.AppService.ASToken, cfg.AppService.Token, savedToken(saved, true))
+	reg.ServerToken = firstToken(cfg.AppService.HSToken, cfg.AppService.Token, savedToken(saved, false))
+	if reg.AppToken == "" {
+		reg.AppToken = randomToken()
+	}
+	if reg.ServerToken == "" {
+		reg.ServerToken = randomToken()
+	}
+
+	if saved != nil && reflect.DeepEqual(saved, reg) {
+		return reg, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := reg.Save(path); err != nil {
+		return nil, fmt.Errorf("写入注册文件失败: %w", err)
+	}
+	if saved == nil {
+		slog.Info("已生成 Matrix AppService 注册文件，请在 Homeserver 中注册", "path", path)
+	} else {
+		slog.Warn("Matrix AppService 注册文件已更新，请在 Homeserver 中重新加载", "path", path)
+	}
+	return reg, nil
+}
+
+// buildRegistration 根据配置构建不含令牌的注册信息
+// 命名空间与驱动实际使用的一致：Ghost 用户为 @<namespace>*:<domain>，房间别名为 #<namespace>*:<domain>
+// 同时开启临时事件推送（输入状态、已读回执），兼容仅支持 MSC2409 的 Homeserver
+// 参数:
+//   - cfg: Matrix 配置
+//
+// 返回:
+//   - *appservice.Registration: 注册信息
+func buildRegistration(cfg *Config) *appservice.Registration {
+	ns := regexp.QuoteMeta(cfg.AppService.Namespace)
+	domain := regexp.QuoteMeta(cfg.Domain)
+	return &appservice.Registration{
+		ID:              cfg.AppService.ID,
+		URL:             cfg.AppService.Listen,
+		SenderLocalpart: botLocalpart,
+		Namespaces: appservice.Namespaces{
+			// 独占用户命名空间（用于 Ghost 用户）
+			UserIDs: appservice.NamespaceList{{Exclusive: true, Regex: fmt.Sprintf("@%s.*:%s", ns, domain)}},
+			// 独占房间别名命名空间
+			RoomAliases: appservice.NamespaceList{{Exclusive: true, Regex: fmt.Sprintf("#%s.*:%s", ns, domain)}},
+		},
+		EphemeralEvents:     true,
+		SoruEphemeralEvents: true,
+	}
+}
+
+// savedToken 返回已持久化注册信息中的令牌
+// 参数:
+//   - reg: 注册文件内容（可能为 nil）
+//   - app: true 返回 as_token，false 返回 hs_token
+func savedToken(reg *appservice.Registration, app bool) string {
+	switch {
+	case reg == nil:
+		return ""
+	case app:
+		return reg.AppToken
+	default:
+		return reg.ServerToken
+	}
+}
+
+// firstToken 返回第一个非空的令牌
+func firstToken(tokens ...string) string {
+	for _, t := range tokens {
+		if t != "" {
+			return t
+		}
+	}
+	return ""
+}
+
+// randomToken 生成 64 位十六进制随机令牌
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -3,18 +3,26 @@ package matrix
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
 	"log/slog"
+	"net/http"
 	"strings"
+	"time"
 
 	"Relify/internal"
 
+	"github.com/jellydator/ttlcache/v3"
+	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/appservice"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
 
+// typingTimeout 是 Ghost 用户输入状态的超时时间，源平台未发送停止输入事件时由 Homeserver 自动结束
+const typingTimeout = 30 * time.Second
+
 // Send 向 Matrix 发送消息
 // 根据事件类型调用相应的发送函数
 // 参数:
@@ -23,13 +31,12 @@ import (
 //   - evt: 要发送的事件
 //
 // 返回:
-//   - string: Matrix 事件 ID
+//   - []internal.SendResult: 发送结果（Matrix 事件 ID）
 //   - error: 错误信息
-func (m *Matrix) Send(ctx context.Context, node *internal.Node, evt *internal.Event) (string, error) {
+func (m *Matrix) Send(ctx context.Context, node *internal.BridgeNode, evt *internal.Event) ([]internal.SendResult, error) {
 	slog.Debug("Matrix 发送事件",
-		"room", node.Room,
-		"kind", evt.Kind,
-		"user", evt.User,
+		"room", node.RoomID,
+		"type", evt.Type,
 		"raw", func() string {
 			if data, err := json.Marshal(evt); err == nil {
 				return string(data)
@@ -38,161 +45,269 @@ func (m *Matrix) Send(ctx context.Context, node *internal.Node, evt *internal.Ev
 		}(),
 	)
 
-	switch evt.Kind {
-	case internal.Msg:
+	var results []internal.SendResult
+	var err error
+	switch evt.Type {
+	case internal.TypeMessage:
 		// 普通消息
-		return m.sendMessage(ctx, node.Room, evt)
-	case internal.Edit:
-		// 编辑消息
-		return m.sendEdit(ctx, node.Room, evt)
-	case internal.Note:
+		results, err = m.sendMessage(ctx, node.RoomID, evt)
+	case internal.TypeNotice:
 		// 通知事件
-		if internal.Revoke == evt.Extra["subtype"] {
-			// 撤回消息
-			return "", m.sendRedact(ctx, node.Room, evt.Ref)
+		results, err = m.sendNotice(ctx, node.RoomID, evt)
+	case internal.TypeEdit:
+		// 编辑消息
+		results, err = m.sendEdit(ctx, node.RoomID, evt)
+	case internal.TypeRevoke:
+		// 撤回消息
+		eventID, ok := m.mapRef(evt)
+		if !ok {
+			return nil, internal.PermanentError(fmt.Errorf("未找到被撤回消息的映射: %s", evt.RefID))
 		}
+		err = classifyError(m.sendRedact(ctx, node.RoomID, eventID))
+	case internal.TypeReaction:
+		// 表态或取消表态
+		results, err = m.sendReaction(ctx, node.RoomID, evt)
+	case internal.TypeTyping:
+		// 输入状态
+		err = classifyError(m.sendTyping(ctx, node.RoomID, evt))
+	case internal.TypeRead:
+		// 已读回执
+		err = m.sendRead(ctx, node.RoomID, evt)
+	default:
+		return nil, nil
+	}
+
+	// 永久错误源于请求本身（如无权限），不代表与 Homeserver 的连接异常
+	var de *internal.DeliveryError
+	if err == nil {
+		m.health.RecordSuccess()
+	} else if !errors.As(err, &de) || !de.Permanent {
+		m.health.RecordError(err)
 	}
-	return "", nil
+	return results, err
 }
 
-// getGhost 获取或创建 Ghost 用户的 Intent API
-// Ghost 用户是 AppService 为其他平台用户创建的傀儡账号
+// mapRef 将事件引用的源平台消息 ID 转换为 Matrix 事件 ID
 // 参数:
-//   - evt: 原始事件（包含用户信息）
+//   - evt: 内部事件
 //
 // 返回:
-//   - *appservice.IntentAPI: Ghost 用户的操作接口
-func (m *Matrix) getGhost(evt *internal.Event) *appservice.IntentAPI {
-	// 构建 Ghost 用户的本地部分: namespace_平台_用户ID
-	localpart := fmt.Sprintf("%s%s_%s", m.cfg.AppService.Namespace, evt.Plat, m.sanitize(evt.User))
-	mxid := id.NewUserID(localpart, m.cfg.Domain)
-	intent := m.as.Intent(mxid)
-
-	// 缓存键包含用户名和头像信息（用于检测更新）
-	key := fmt.Sprintf("ghost_%s_%s_%s", mxid.String(), evt.Name, evt.Avatar)
-
-	// 如果缓存中不存在，异步更新 Ghost 用户资料
-	if _, loaded := m.cache.LoadOrStore(key, true); !loaded {
-		go m.updateGhostProfile(intent, evt)
+//   - string: Matrix 事件 ID
+//   - bool: 是否找到映射
+func (m *Matrix) mapRef(evt *internal.Event) (string, bool) {
+	if evt.RefID == "" {
+		return "", false
 	}
-	return intent
+	return m.api.FindMapping(evt.Platform, evt.RefID, m.name())
 }
 
-// updateGhostProfile 更新 Ghost 用户的显示名称和头像
-// 此操作异步执行，避免阻塞消息发送
+// sendMessage 发送普通消息到 Matrix 房间
+// 文本与提及合并为一条消息，每个媒体片段单独发送
 // 参数:
-//   - intent: Ghost 用户的操作接口
-//   - evt: 包含用户名称和头像的事件
-func (m *Matrix) updateGhostProfile(intent *appservice.IntentAPI, evt *internal.Event) {
-	ctx := context.Background()
-
-	slog.Debug("Matrix 开始更新Ghost用户资料",
-		"user_id", intent.UserID,
-		"name", evt.Name,
-		"avatar", evt.Avatar,
-		"platform", evt.Plat,
-		"original_user", evt.User,
-	)
+//   - ctx: 上下文
+//   - roomID: 目标房间 ID
+//   - evt: 要发送的事件
+//
+// 返回:
+//   - []internal.SendResult: 每条 Matrix 消息的发送结果
+//   - error: 错误信息（全部发送失败时）
+func (m *Matrix) sendMessage(ctx context.Context, roomID string, evt *internal.Event) ([]internal.SendResult, error) {
+	intent := m.getGhost(ctx, evt, id.RoomID(roomID)) // 获取发送者的 Ghost 用户
 
-	// 确保用户已注册
-	if err := intent.EnsureRegistered(ctx); err != nil {
-		slog.Error("Matrix Ghost用户注册失败",
-			"user_id", intent.UserID,
-			"error", err,
-		)
-		return
+	// 渲染消息内容（将内部格式转换为 Matrix 格式）
+	contents := m.renderContents(ctx, intent, evt.Platform, evt.Segments)
+	if len(contents) == 0 {
+		return nil, nil
 	}
 
-	// 设置显示名称
-	name := evt.Name
-	if name == "" {
-		name = evt.User // 如果没有昵称，使用用户 ID
+	// 如果是回复消息，在第一条消息上设置关联关系
+	mapped, _ := m.mapRef(evt)
+	replyTo := id.EventID(mapped)
+	root := m.threadRoot(ctx, evt, replyTo)
+	if root == "" {
+		if replyTo != "" {
+			contents[0].RelatesTo = (&event.RelatesTo{}).SetReplyTo(replyTo)
+		}
+		return m.sendContents(ctx, intent, roomID, contents)
 	}
 
-	slog.Debug("Matrix 设置显示名称",
-		"user_id", intent.UserID,
-		"name", name,
-	)
-
-	if err := intent.SetDisplayName(ctx, name); err != nil {
-		slog.Error("Matrix 设置显示名称失败",
-			"user_id", intent.UserID,
-			"name", name,
-			"error", err,
-		)
+	// 话题内的消息全部归入话题，没有直接回复时回退引用话题内最新的消息
+	fallback := replyTo
+	if fallback == "" {
+		fallback, _ = m.store.LatestThreadEvent(ctx, id.RoomID(roomID), root)
+	}
+	if fallback == "" {
+		fallback = root
+	}
+	for i, content := range contents {
+		content.RelatesTo = &event.RelatesTo{}
+		if i == 0 && replyTo != "" {
+			content.RelatesTo.SetReplyTo(replyTo)
+		}
+		content.RelatesTo.SetThread(root, fallback)
 	}
 
-	// 设置头像（如果有）
-	if evt.Avatar != "" {
-		mxc, err := m.uploadMedia(ctx, intent, evt.Avatar, "image/jpeg")
-		if err != nil {
-			slog.Error("Matrix 上传头像失败",
-				"user_id", intent.UserID,
-				"avatar_url", evt.Avatar,
-				"error", err,
-			)
-		} else if mxc == "" {
-			slog.Warn("Matrix 上传头像返回空MXC",
-				"user_id", intent.UserID,
-				"avatar_url", evt.Avatar,
-			)
-		} else {
-			avatarURI, err := id.ParseContentURI(mxc)
-			if err != nil {
-				slog.Error("Matrix 解析MXC URI失败",
-					"user_id", intent.UserID,
-					"mxc", mxc,
-					"error", err,
-				)
-			} else {
-				slog.Debug("Matrix 设置头像URL",
-					"user_id", intent.UserID,
-					"avatar_uri", avatarURI,
-				)
-
-				if err := intent.SetAvatarURL(ctx, avatarURI); err != nil {
-					slog.Error("Matrix 设置头像URL失败",
-						"user_id", intent.UserID,
-						"avatar_uri", avatarURI,
-						"error", err,
-					)
-				}
+	results, err := m.sendContents(ctx, intent, roomID, contents)
+	for _, res := range results {
+		if res.Error == nil {
+			if err := m.store.SaveThreadEvent(ctx, id.RoomID(roomID), root, id.EventID(res.MsgID)); err != nil {
+				slog.Warn("Matrix 保存话题记录失败", "id", res.MsgID, "error", err)
 			}
 		}
 	}
+	return results, err
 }
 
-// sendMessage 发送普通消息到 Matrix 房间
+// threadRoot 确定消息在 Matrix 上所属话题的根消息
+// 源平台的话题按消息映射转换；源平台没有话题但回复了 Matrix 话题内的消息时，同样归入该话题
 // 参数:
 //   - ctx: 上下文
-//   - roomID: 目标房间 ID
 //   - evt: 要发送的事件
+//   - replyTo: 被回复消息的 Matrix 事件 ID（可能为空）
 //
 // 返回:
-//   - string: Matrix 事件 ID
+//   - id.EventID: 话题根消息 ID，不属于话题时为空
+func (m *Matrix) threadRoot(ctx context.Context, evt *internal.Event, replyTo id.EventID) id.EventID {
+	if evt.ThreadID != "" {
+		if root, ok := m.api.FindMapping(evt.Platform, evt.ThreadID, m.name()); ok {
+			return id.EventID(root)
+		}
+	}
+	if replyTo == "" {
+		return ""
+	}
+	root, err := m.store.GetThreadRoot(ctx, replyTo)
+	if err != nil {
+		slog.Warn("Matrix 查询话题记录失败", "id", replyTo, "error", err)
+	}
+	return root
+}
+
+// sendNotice 以 Bot 身份发送通知消息
+// 参数:
+//   - ctx: 上下文
+//   - roomID: 目标房间 ID
+//   - evt: 通知事件
+//
+// 返回:
+//   - []internal.SendResult: 发送结果
 //   - error: 错误信息
-func (m *Matrix) sendMessage(ctx context.Context, roomID string, evt *internal.Event) (string, error) {
-	intent := m.getGhost(evt) // 获取发送者的 Ghost 用户
+func (m *Matrix) sendNotice(ctx context.Context, roomID string, evt *internal.Event) ([]internal.SendResult, error) {
+	intent := m.as.BotIntent()
+	contents := m.renderContents(ctx, intent, evt.Platform, evt.Segments)
+	if len(contents) == 0 {
+		return nil, nil
+	}
 
-	// 渲染消息内容（将内部格式转换为 Matrix 格式）
-	content, err := m.renderContent(ctx, intent, evt.Segs)
-	if err != nil {
-		return "", err
+	// 通知前缀标明触发者
+	prefix := ""
+	if evt.Sender != nil && evt.Sender.Name != "" {
+		prefix = evt.Sender.Name + ": "
+	}
+	for _, c := range contents {
+		if c.MsgType == event.MsgText {
+			c.MsgType = event.MsgNotice
+			c.Body = prefix + c.Body
+			c.FormattedBody = html.EscapeString(prefix) + c.FormattedBody
+		}
 	}
 
-	// 如果是回复消息，设置关联关系
-	if evt.Ref != "" {
-		content.RelatesTo = &event.RelatesTo{
-			InReplyTo: &event.InReplyTo{EventID: id.EventID(evt.Ref)},
+	return m.sendContents(ctx, intent, roomID, contents)
+}
+
+// sendContents 依次发送渲染好的消息内容
+// 参数:
+//   - ctx: 上下文
+//   - intent: 发送者的 Intent API
+//   - roomID: 目标房间 ID
+//   - contents: 消息内容列表
+//
+// 返回:
+//   - []internal.SendResult: 每条消息的发送结果
+//   - error: 错误信息（全部发送失败时）
+func (m *Matrix) sendContents(ctx context.Context, intent *appservice.IntentAPI, roomID string, contents []*event.MessageEventContent) ([]internal.SendResult, error) {
+	results := make([]internal.SendResult, 0, len(contents))
+	var lastErr error
+	succeeded := 0
+
+	for _, content := range contents {
+		evtType, payload, err := m.encryptContent(ctx, id.RoomID(roomID), event.EventMessage, content)
+		if err != nil {
+			lastErr = err
+			results = append(results, internal.SendResult{Error: err})
+			continue
+		}
+		resp, err := intent.SendMessageEvent(ctx, id.RoomID(roomID), evtType, payload)
+		if err != nil {
+			lastErr = classifyError(err)
+			results = append(results, internal.SendResult{Error: err})
+			continue
 		}
+		succeeded++
+		results = append(results, internal.SendResult{MsgID: resp.EventID.String()})
 	}
 
-	// 发送消息事件
-	resp, err := intent.SendMessageEvent(ctx, id.RoomID(roomID), event.EventMessage, content)
+	if succeeded == 0 {
+		return results, lastErr
+	}
+	return results, nil
+}
+
+// encryptContent 在加密房间中加密事件内容
+// 未启用端到端加密时原样发送；启用后加密未就绪则拒绝发送，避免明文泄露到加密房间
+// 参数:
+//   - ctx: 上下文
+//   - roomID: 目标房间 ID
+//   - evtType: 事件类型（消息或表态）
+//   - content: 事件内容
+//
+// 返回:
+//   - event.Type: 实际发送的事件类型
+//   - any: 实际发送的内容
+//   - error: 加密错误
+func (m *Matrix) encryptContent(ctx context.Context, roomID id.RoomID, evtType event.Type, content any) (event.Type, any, error) {
+	if !m.cfg.Encryption.Enabled {
+		return evtType, content, nil
+	}
+	encrypted, err := m.isEncrypted(ctx, roomID)
 	if err != nil {
-		return "", err
+		return evtType, nil, fmt.Errorf("无法确认房间是否加密: %w", err)
+	}
+	if !encrypted {
+		return evtType, content, nil
 	}
-	return resp.EventID.String(), nil
+
+	c := m.crypto.Load()
+	if c == nil {
+		return evtType, nil, fmt.Errorf("房间已加密，但端到端加密未就绪")
+	}
+	payload, err := c.encrypt(ctx, roomID, evtType, content)
+	if err != nil {
+		return evtType, nil, fmt.Errorf("加密消息失败: %w", err)
+	}
+	return event.EventEncrypted, payload, nil
+}
+
+// isEncrypted 检查房间是否已启用加密
+// 每个房间首次检查时拉取完整状态，之后依赖 Homeserver 推送的状态事件更新。
+// 拉取成功后才标记为已加载，同一房间的并发首次检查会各自拉取，不会在状态到达前误判为未加密
+// 参数:
+//   - ctx: 上下文
+//   - roomID: 房间 ID
+//
+// 返回:
+//   - bool: 是否已加密
+//   - error: 拉取房间状态错误
+func (m *Matrix) isEncrypted(ctx context.Context, roomID id.RoomID) (bool, error) {
+	cacheKey := "state_" + roomID.String()
+	if !m.cache.Has(cacheKey) {
+		if _, err := m.as.BotClient().State(ctx, roomID); err != nil {
+			slog.Warn("Matrix 获取房间状态失败", "room_id", roomID, "error", err)
+			return false, err
+		}
+		m.cache.Set(cacheKey, true, ttlcache.NoTTL)
+	}
+	return m.as.StateStore.IsEncrypted(ctx, roomID)
 }
 
 // sendEdit 发送编辑消息到 Matrix 房间
@@ -202,28 +317,34 @@ func (m *Matrix) sendMessage(ctx context.Context, roomID string, evt *internal.E
 //   - evt: 包含新内容的编辑事件
 //
 // 返回:
-//   - string: Matrix 事件 ID
+//   - []internal.SendResult: 发送结果
 //   - error: 错误信息
-func (m *Matrix) sendEdit(ctx context.Context, roomID string, evt *internal.Event) (string, error) {
-	intent := m.getGhost(evt)
-	newContent, _ := m.renderContent(ctx, intent, evt.Segs) // 渲染新内容
+func (m *Matrix) sendEdit(ctx context.Context, roomID string, evt *internal.Event) ([]internal.SendResult, error) {
+	targetID, ok := m.mapRef(evt)
+	if !ok {
+		return nil, internal.PermanentError(fmt.Errorf("未找到被编辑消息的映射: %s", evt.RefID))
+	}
+
+	intent := m.getGhost(ctx, evt, id.RoomID(roomID))
+	contents := m.renderContents(ctx, intent, evt.Platform, evt.Segments) // 渲染新内容
+	if len(contents) == 0 {
+		return nil, nil
+	}
+	newContent := contents[0]
 
 	// 构建编辑消息（Body 以 "* " 开头表示编辑）
 	content := &event.MessageEventContent{
-		MsgType:    event.MsgText,
+		MsgType:    newContent.MsgType,
 		Body:       "* " + newContent.Body, // 旧客户端显示格式
 		NewContent: newContent,             // 新客户端使用的内容
+		Mentions:   &event.Mentions{},      // 编辑不重复提醒原消息中已提及的用户
 		RelatesTo: &event.RelatesTo{
-			Type:    event.RelReplace,    // 替换关系类型
-			EventID: id.EventID(evt.Ref), // 被编辑的原始消息 ID
+			Type:    event.RelReplace,     // 替换关系类型
+			EventID: id.EventID(targetID), // 被编辑的原始消息 ID
 		},
 	}
 
-	resp, err := intent.SendMessageEvent(ctx, id.RoomID(roomID), event.EventMessage, content)
-	if err != nil {
-		return "", err
-	}
-	return resp.EventID.String(), nil
+	return m.sendContents(ctx, intent, roomID, []*event.MessageEventContent{content})
 }
 
 // sendRedact 撤回 Matrix 房间中的消息
@@ -240,134 +361,319 @@ func (m *Matrix) sendRedact(ctx context.Context, roomID, eventID string) error {
 	return err
 }
 
-// renderContent 将内部消息段列表渲染为 Matrix 消息内容
+// sendReaction 以发送者的 Ghost 用户发送表态（m.reaction）
+// 取消表态时撤回之前记录的表态事件，未找到记录时忽略
+// 参数:
+//   - ctx: 上下文
+//   - roomID: 目标房间 ID
+//   - evt: 表态事件
+//
+// 返回:
+//   - []internal.SendResult: 每个表态的发送结果
+//   - error: 错误信息（全部发送失败时）
+func (m *Matrix) sendReaction(ctx context.Context, roomID string, evt *internal.Event) ([]internal.SendResult, error) {
+	targetID, ok := m.mapRef(evt)
+	if !ok {
+		return nil, internal.PermanentError(fmt.Errorf("未找到被表态消息的映射: %s", evt.RefID))
+	}
+	rid, target := id.RoomID(roomID), id.EventID(targetID)
+	intent := m.getGhost(ctx, evt, rid)
+
+	var results []internal.SendResult
+	var lastErr error
+	succeeded := 0
+
+	for _, seg := range evt.Segments {
+		if seg.Type != internal.SegReaction || seg.Text == "" {
+			continue
+		}
+
+		if remove, _ := seg.Extra["remove"].(bool); remove {
+			eventID, err := m.store.FindReaction(ctx, rid, intent.UserID, target, seg.Text)
+			if err == nil && eventID == "" {
+				continue // 没有可取消的表态
+			}
+			if err == nil {
+				_, err = intent.RedactEvent(ctx, rid, eventID)
+			}
+			if err != nil {
+				lastErr = classifyError(err)
+				results = append(results, internal.SendResult{Error: err})
+				continue
+			}
+			if err := m.store.DeleteReaction(ctx, eventID); err != nil {
+				slog.Warn("Matrix 删除表态记录失败", "id", eventID, "error", err)
+			}
+			succeeded++
+			results = append(results, internal.SendResult{MsgID: eventID.String()})
+			continue
+		}
+
+		evtType, payload, err := m.encryptContent(ctx, rid, event.EventReaction, &event.ReactionEventContent{
+			RelatesTo: event.RelatesTo{Type: event.RelAnnotation, EventID: target, Key: seg.Text},
+		})
+		if err != nil {
+			lastErr = err
+			results = append(results, internal.SendResult{Error: err})
+			continue
+		}
+		resp, err := intent.SendMessageEvent(ctx, rid, evtType, payload)
+		if err != nil {
+			lastErr = classifyError(err)
+			results = append(results, internal.SendResult{Error: err})
+			continue
+		}
+		err = m.store.SaveReaction(ctx, &reaction{
+			EventID: resp.EventID,
+			RoomID:  rid,
+			Sender:  intent.UserID,
+			Target:  target,
+			Key:     seg.Text,
+		})
+		if err != nil {
+			slog.Warn("Matrix 保存表态记录失败", "id", resp.EventID, "error", err)
+		}
+		succeeded++
+		results = append(results, internal.SendResult{MsgID: resp.EventID.String()})
+	}
+
+	if len(results) > 0 && succeeded == 0 {
+		return results, lastErr
+	}
+	return results, nil
+}
+
+// sendTyping 设置 Ghost 用户的输入状态
+// 输入状态只用于已在房间中的 Ghost 用户，避免仅因输入就创建空白资料的 Ghost 用户
+// 参数:
+//   - ctx: 上下文
+//   - roomID: 目标房间 ID
+//   - evt: 输入状态事件
+//
+// 返回:
+//   - error: 错误信息
+func (m *Matrix) sendTyping(ctx context.Context, roomID string, evt *internal.Event) error {
+	if evt.Sender == nil || evt.Sender.ID == "" {
+		return nil
+	}
+	rid, intent := id.RoomID(roomID), m.ghostIntent(evt)
+	if !m.store.IsInRoom(ctx, rid, intent.UserID) {
+		return nil
+	}
+	typing := true
+	if v, ok := evt.Extra["typing"].(bool); ok {
+		typing = v
+	}
+	_, err := intent.UserTyping(ctx, rid, typing, typingTimeout)
+	return err
+}
+
+// sendRead 将 Ghost 用户的已读位置设置为已读消息对应的 Matrix 事件
+// 参数:
+//   - ctx: 上下文
+//   - roomID: 目标房间 ID
+//   - evt: 已读回执事件
+//
+// 返回:
+//   - error: 错误信息
+func (m *Matrix) sendRead(ctx context.Context, roomID string, evt *internal.Event) error {
+	if evt.Sender == nil || evt.Sender.ID == "" {
+		return nil
+	}
+	eventID, ok := m.mapRef(evt)
+	if !ok {
+		return internal.PermanentError(fmt.Errorf("未找到已读消息的映射: %s", evt.RefID))
+	}
+	rid, intent := id.RoomID(roomID), m.ghostIntent(evt)
+	if !m.store.IsInRoom(ctx, rid, intent.UserID) {
+		return nil
+	}
+	return classifyError(intent.MarkRead(ctx, rid, id.EventID(eventID)))
+}
+
+// classifyError 根据 Homeserver 的响应将错误归类，供路由器决定是否重试
+// 参数:
+//   - err: 原始错误
+//
+// 返回:
+//   - error: 限流错误附带重试时间，其余 4xx 错误标记为永久错误，其他错误原样返回
+func classifyError(err error) error {
+	var httpErr mautrix.HTTPError
+	if err == nil || !errors.As(err, &httpErr) || httpErr.Response == nil {
+		return err
+	}
+
+	code := httpErr.Response.StatusCode
+	switch {
+	case code == http.StatusTooManyRequests:
+		retryAfter := time.Duration(0)
+		if httpErr.RespError != nil {
+			if ms, ok := httpErr.RespError.ExtraData["retry_after_ms"].(float64); ok {
+				retryAfter = time.Duration(ms) * time.Millisecond
+			}
+		}
+		return internal.RetryAfterError(err, retryAfter)
+	case code >= 400 && code < 500:
+		return internal.PermanentError(err)
+	}
+	return err
+}
+
+// renderContents 将内部消息段列表渲染为 Matrix 消息内容
+// 连续的文本与提及合并为一条文本消息，每个媒体片段单独成为一条消息
 // 参数:
 //   - ctx: 上下文
 //   - intent: 发送者的 Intent API
+//   - source: 事件的源平台
 //   - segs: 内部消息段列表
 //
 // 返回:
-//   - *event.MessageEventContent: Matrix 消息内容
-//   - error: 错误信息
-func (m *Matrix) renderContent(ctx context.Context, intent *appservice.IntentAPI, segs []internal.Seg) (*event.MessageEventContent, error) {
-	var body strings.Builder     // 纯文本内容
-	var htmlBody strings.Builder // HTML 格式内容
-	content := &event.MessageEventContent{MsgType: event.MsgText}
-
-	for _, s := range segs {
-		switch s.Kind {
-		case "text":
-			// 文本段
-			txt := s.Raw["txt"].(string)
-			body.WriteString(txt)
-			htmlBody.WriteString(html.EscapeString(txt)) // HTML 转义
-
-		case "image", "file", "video", "audio":
-			// 媒体段：上传后添加到消息中
-			if err := m.renderMediaSegment(ctx, intent, &s, content, &body, &htmlBody); err != nil {
+//   - []*event.MessageEventContent: Matrix 消息内容列表
+func (m *Matrix) renderContents(ctx context.Context, intent *appservice.IntentAPI, source string, segs []internal.Segment) []*event.MessageEventContent {
+	var contents []*event.MessageEventContent
+	var body strings.Builder      // 纯文本内容
+	var htmlBody strings.Builder  // HTML 格式内容
+	mentions := &event.Mentions{} // 被提及的用户（m.mentions）
+
+	// flush 将已累积的文本输出为一条文本消息
+	flush := func() {
+		if strings.TrimSpace(body.String()) == "" {
+			body.Reset()
+			htmlBody.Reset()
+			return
+		}
+		contents = append(contents, &event.MessageEventContent{
+			MsgType:       event.MsgText,
+			Body:          body.String(),
+			Format:        event.FormatHTML,
+			FormattedBody: htmlBody.String(),
+			Mentions:      mentions,
+		})
+		body.Reset()
+		htmlBody.Reset()
+		mentions = &event.Mentions{}
+	}
+
+	plain := internal.PlainText(segs) // 纯文本内容使用降级后的文本
+	for i := range segs {
+		s := &segs[i]
+		switch s.Type {
+		case internal.SegText:
+			// 文本段（HTML 内容按样式渲染）
+			body.WriteString(plain[i].Text)
+			htmlBody.WriteString(renderStyledText(s))
+
+		case internal.SegImage, internal.SegFile, internal.SegVideo, internal.SegAudio:
+			// 媒体段：上传后作为独立消息发送
+			if s.File == nil {
+				continue
+			}
+			content, err := m.renderMediaSegment(ctx, intent, s)
+			if err != nil {
 				// 上传失败时，降级为链接文本
-				urlStr := s.Raw["url"].(string)
-				name, _ := s.Raw["name"].(string)
+				slog.Warn("Matrix 上传媒体失败，降级为链接", "url", s.File.URL, "error", err)
+				name := s.File.Name
 				if name == "" {
-					name = s.Kind
+					name = string(s.Type)
 				}
-				link := fmt.Sprintf(" [%s: %s] ", name, urlStr)
+				link := fmt.Sprintf(" [%s: %s] ", name, s.File.URL)
 				body.WriteString(link)
-				htmlBody.WriteString(link)
+				htmlBody.WriteString(html.EscapeString(link))
+				continue
 			}
+			flush()
+			contents = append(contents, content)
 
-		case "mention":
+		case internal.SegMention:
 			// 提及段：转换为 Matrix 用户提及
-			m.renderMention(&s, &body, &htmlBody)
+			m.renderMention(ctx, s, source, &body, &htmlBody, mentions)
 		}
 	}
+	flush()
 
-	// 如果消息类型仍为文本（没有媒体），设置文本内容
-	if content.MsgType == event.MsgText {
-		content.Body = body.String()
-		content.Format = event.FormatHTML
-		content.FormattedBody = htmlBody.String()
-	}
-
-	return content, nil
+	return contents
 }
 
-// renderMediaSegment 渲染媒体段（上传并设置消息内容）
+// renderMediaSegment 渲染媒体段（上传并构建媒体消息内容）
 // 参数:
 //   - ctx: 上下文
 //   - intent: 发送者的 Intent API
 //   - seg: 媒体段
-//   - content: 消息内容（将被修改为媒体消息）
-//   - body: 纯文本内容（不使用，用于 API 兼容）
-//   - htmlBody: HTML 内容（不使用，用于 API 兼容）
 //
 // 返回:
+//   - *event.MessageEventContent: 媒体消息内容
 //   - error: 错误信息
-func (m *Matrix) renderMediaSegment(ctx context.Context, intent *appservice.IntentAPI, seg *internal.Seg, content *event.MessageEventContent, body, htmlBody *strings.Builder) error {
-	urlStr := seg.Raw["url"].(string)
-	name, _ := seg.Raw["name"].(string)
+func (m *Matrix) renderMediaSegment(ctx context.Context, intent *appservice.IntentAPI, seg *internal.Segment) (*event.MessageEventContent, error) {
+	name := seg.File.Name
 	if name == "" {
-		name = seg.Kind // 如果没有文件名，使用段类型
+		name = string(seg.Type) // 如果没有文件名，使用段类型
 	}
 
 	// 上传媒体文件
-	mxc, err := m.uploadMedia(ctx, intent, urlStr, "")
+	mxc, err := m.uploadMedia(ctx, intent, seg.File.URL, seg.File.MimeType)
 	if err != nil {
-		return err
-	}
-
-	// 设置消息内容为媒体消息
-	content.URL = id.ContentURIString(mxc)
-	content.Body = name
-	content.FileName = name
-
-	// 初始化文件信息
-	if content.Info == nil {
-		content.Info = &event.FileInfo{}
+		return nil, err
 	}
 
-	// 设置文件大小（如果有）
-	if size, ok := seg.Raw["size"]; ok {
-		switch v := size.(type) {
-		case int64:
-			content.Info.Size = int(v)
-		case int:
-			content.Info.Size = v
-		case float64:
-			content.Info.Size = int(v)
-		}
+	content := &event.MessageEventContent{
+		URL:      id.ContentURIString(mxc),
+		Body:     name,
+		FileName: name,
+		Info: &event.FileInfo{
+			MimeType: seg.File.MimeType,
+			Size:     int(seg.File.Size),
+			Width:    seg.File.Width,
+			Height:   seg.File.Height,
+			Duration: seg.File.Duration * 1000, // Matrix 时长单位为毫秒
+		},
 	}
 
 	// 设置消息类型（图片/视频/音频/文件）
-	content.MsgType = map[string]event.MessageType{
-		"image": event.MsgImage,
-		"video": event.MsgVideo,
-		"audio": event.MsgAudio,
-		"file":  event.MsgFile,
-	}[seg.Kind]
-
-	return nil
+	content.MsgType = map[internal.SegmentType]event.MessageType{
+		internal.SegImage: event.MsgImage,
+		internal.SegVideo: event.MsgVideo,
+		internal.SegAudio: event.MsgAudio,
+		internal.SegFile:  event.MsgFile,
+	}[seg.Type]
+
+	return content, nil
 }
 
-// renderMention 渲染提及段（转换为 Matrix 用户 ID）
+// renderMention 渲染提及段为 Matrix 用户提及
+// 正文使用显示名称，HTML 中为 matrix.to 链接，并将用户加入 m.mentions；提及全体成员时渲染为 @room
 // 参数:
+//   - ctx: 上下文
 //   - seg: 提及段
-//   - body: 纯文本内容
-//   - htmlBody: HTML 内容（包含超链接）
-func (m *Matrix) renderMention(seg *internal.Seg, body, htmlBody *strings.Builder) {
-	u := seg.Raw["user"].(string)
+//   - source: 事件的源平台（提及段未指定平台时被提及用户所在的平台）
+//   - body: 纯文本内容构建器
+//   - htmlBody: HTML 内容构建器
+//   - mentions: 当前消息的 m.mentions
+func (m *Matrix) renderMention(ctx context.Context, seg *internal.Segment, source string, body, htmlBody *strings.Builder, mentions *event.Mentions) {
+	if seg.ID == internal.MentionAll {
+		mentions.Room = true
+		body.WriteString("@room ")
+		htmlBody.WriteString("@room ")
+		return
+	}
 
-	var mxid string
-	// 如果是纯数字（QQ 号），构建 Ghost 用户 ID
-	if _, err := fmt.Sscanf(u, "%d", new(int64)); err == nil {
-		localpart := fmt.Sprintf("%s%s_%s", m.cfg.AppService.Namespace, "qq", u)
-		mxid = id.NewUserID(localpart, m.cfg.Domain).String()
-	} else {
-		// 否则直接使用（可能已是 Matrix ID）
-		mxid = u
+	u := seg.ID
+	// 其他平台的用户提及其 Ghost 用户，Matrix 用户（由其他平台还原的提及）直接使用
+	mxid := id.UserID(u)
+	if platform := seg.MentionedPlatform(source); platform != m.name() {
+		mxid = m.ghostUserID(platform, u)
+	}
+	mentions.Add(mxid)
+
+	// 提及段没有名称时使用 Ghost 用户已同步的显示名称
+	name := strings.TrimPrefix(seg.Text, "@")
+	if name == "" || name == u {
+		name = mxid.String()
+		if saved, err := m.store.GetGhost(ctx, mxid); err == nil && saved.Displayname != "" {
+			name = saved.Displayname
+		}
 	}
 
 	// 添加到内容中（HTML 格式包含 matrix.to 链接）
-	body.WriteString(mxid + " ")
-	htmlBody.WriteString(fmt.Sprintf(`<a href="https://matrix.to/#/%s">%s</a> `, mxid, mxid))
+	body.WriteString(name + " ")
+	htmlBody.WriteString(fmt.Sprintf(`<a href="%s">%s</a> `, mxid.URI().MatrixToURL(), html.EscapeString(name)))
 }
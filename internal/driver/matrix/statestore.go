@@ -0,0 +1,283 @@
+package matrix
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/sqlstatestore"
+)
+
+// AppServiceStateStore 是持久化到核心数据库的状态存储
+// 房间成员、权限等级、加密状态和 Ghost 用户注册状态由 mautrix 的 SQL 状态存储维护（mx_ 前缀的数据表），
+// Ghost 用户上次应用的资料保存在 matrix_ghosts 表中，已上传媒体的内容摘要保存在 matrix_media 表中，
+// 重启后无需重新注册 Ghost 用户、同步资料或重复上传相同的头像；
+// 表态事件记录在 matrix_reactions 表中，用于识别表态的撤回和取消 Ghost 用户的表态；
+// 话题内的消息记录在 matrix_threads 表中，用于将回复话题消息的消息归入话题并生成回退引用；
+// 加密房间中媒体的解密密钥记录在 matrix_encrypted_media 表中，供媒体代理在链接有效期内解密
+type AppServiceStateStore struct {
+	*sqlstatestore.SQLStateStore
+}
+
+// newStateStore 在核心数据库中创建或升级状态存储的数据表
+// 参数:
+//   - ctx: 上下文
+//   - db: 核心数据库连接
+//
+// 返回:
+//   - *AppServiceStateStore: 状态存储
+//   - error: 建表或升级错误
+func newStateStore(ctx context.Context, db *sql.DB) (*AppServiceStateStore, error) {
+	wrapped, err := dbutil.NewWithDB(db, "sqlite3")
+	if err != nil {
+		return nil, err
+	}
+	store := &AppServiceStateStore{SQLStateStore: sqlstatestore.NewSQLStateStore(wrapped, dbutil.NoopLogger, false)}
+	if err := store.Upgrade(ctx); err != nil {
+		return nil, fmt.Errorf("升级 Matrix 状态存储失败: %w", err)
+	}
+	if err := migrateGhostTable(ctx, db); err != nil {
+		return nil, err
+	}
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS matrix_ghosts (
+			user_id TEXT PRIMARY KEY,
+			displayname TEXT NOT NULL DEFAULT '',
+			avatar_url TEXT NOT NULL DEFAULT '',
+			avatar_hash TEXT NOT NULL DEFAULT '',
+			avatar_mxc TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE TABLE IF NOT EXISTS matrix_media (
+			hash TEXT PRIMARY KEY,
+			mxc TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS matrix_reactions (
+			event_id TEXT PRIMARY KEY,
+			room_id TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			target TEXT NOT NULL,
+			reaction_key TEXT NOT NULL,
+			timestamp INTEGER NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_matrix_reaction_target ON matrix_reactions(room_id, target, sender, reaction_key)`,
+		`CREATE TABLE IF NOT EXISTS matrix_threads (
+			event_id TEXT PRIMARY KEY,
+			room_id TEXT NOT NULL,
+			root TEXT NOT NULL,
+			timestamp INTEGER NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_matrix_thread_root ON matrix_threads(room_id, root, timestamp)`,
+		`CREATE TABLE IF NOT EXISTS matrix_encrypted_media (
+			mxc TEXT PRIMARY KEY,
+			file TEXT NOT NULL,
+			mimetype TEXT NOT NULL DEFAULT '',
+			timestamp INTEGER NOT NULL
+		)`,
+	}
+	for _, q := range queries {
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// migrateGhostTable 删除旧版只记录资料摘要的 matrix_ghosts 表，Ghost 资料会在下次发送消息时重新同步
+func migrateGhostTable(ctx context.Context, db *sql.DB) error {
+	var old int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info('matrix_ghosts') WHERE name='profile_hash'").Scan(&old)
+	if err != nil || old == 0 {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "DROP TABLE matrix_ghosts")
+	return err
+}
+
+// ghostProfile 是 Ghost 用户上次成功应用的全局资料
+type ghostProfile struct {
+	Displayname string // 显示名称
+	AvatarURL   string // 头像来源 URL
+	AvatarHash  string // 头像内容摘要
+	AvatarMXC   string // 头像 MXC URI
+}
+
+// GetGhost 获取 Ghost 用户上次应用的资料，未同步过时返回零值
+func (s *AppServiceStateStore) GetGhost(ctx context.Context, userID id.UserID) (*ghostProfile, error) {
+	var p ghostProfile
+	err := s.QueryRow(ctx, "SELECT displayname, avatar_url, avatar_hash, avatar_mxc FROM matrix_ghosts WHERE user_id=$1", userID).
+		Scan(&p.Displayname, &p.AvatarURL, &p.AvatarHash, &p.AvatarMXC)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	return &p, err
+}
+
+// SetGhost 记录 Ghost 用户已应用的资料
+func (s *AppServiceStateStore) SetGhost(ctx context.Context, userID id.UserID, p *ghostProfile) error {
+	_, err := s.Exec(ctx, `INSERT INTO matrix_ghosts (user_id, displayname, avatar_url, avatar_hash, avatar_mxc)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET displayname=excluded.displayname, avatar_url=excluded.avatar_url,
+			avatar_hash=excluded.avatar_hash, avatar_mxc=excluded.avatar_mxc`,
+		userID, p.Displayname, p.AvatarURL, p.AvatarHash, p.AvatarMXC)
+	return err
+}
+
+// GetMedia 按内容摘要查找已上传的媒体，未上传过时返回空字符串
+func (s *AppServiceStateStore) GetMedia(ctx context.Context, hash string) (string, error) {
+	var mxc string
+	err := s.QueryRow(ctx, "SELECT mxc FROM matrix_media WHERE hash=$1", hash).Scan(&mxc)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return mxc, err
+}
+
+// SetMedia 记录内容摘要对应的 MXC URI
+func (s *AppServiceStateStore) SetMedia(ctx context.Context, hash, mxc string) error {
+	_, err := s.Exec(ctx, "INSERT INTO matrix_media (hash, mxc) VALUES ($1, $2) ON CONFLICT (hash) DO UPDATE SET mxc=excluded.mxc", hash, mxc)
+	return err
+}
+
+// reaction 是一条 m.reaction 表态记录
+type reaction struct {
+	EventID id.EventID // 表态事件 ID
+	RoomID  id.RoomID  // 房间 ID
+	Sender  id.UserID  // 表态者
+	Target  id.EventID // 被表态的事件 ID
+	Key     string     // 表情
+}
+
+// SaveReaction 记录表态事件
+func (s *AppServiceStateStore) SaveReaction(ctx context.Context, r *reaction) error {
+	_, err := s.Exec(ctx, `INSERT INTO matrix_reactions (event_id, room_id, sender, target, reaction_key, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (event_id) DO NOTHING`,
+		r.EventID, r.RoomID, r.Sender, r.Target, r.Key, time.Now().UnixMilli())
+	return err
+}
+
+// GetReaction 按事件 ID 获取表态记录，不是已记录的表态时返回 nil
+func (s *AppServiceStateStore) GetReaction(ctx context.Context, eventID id.EventID) (*reaction, error) {
+	r := &reaction{EventID: eventID}
+	err := s.QueryRow(ctx, "SELECT room_id, sender, target, reaction_key FROM matrix_reactions WHERE event_id=$1", eventID).
+		Scan(&r.RoomID, &r.Sender, &r.Target, &r.Key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return r, err
+}
+
+// FindReaction 查找用户对某条消息使用指定表情的表态事件 ID，未找到时返回空字符串
+func (s *AppServiceStateStore) FindReaction(ctx context.Context, roomID id.RoomID, sender id.UserID, target id.EventID, key string) (id.EventID, error) {
+	var eventID id.EventID
+	err := s.QueryRow(ctx, `SELECT event_id FROM matrix_reactions
+		WHERE room_id=$1 AND target=$2 AND sender=$3 AND reaction_key=$4 ORDER BY timestamp DESC LIMIT 1`,
+		roomID, target, sender, key).Scan(&eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return eventID, err
+}
+
+// DeleteReaction 删除表态记录
+func (s *AppServiceStateStore) DeleteReaction(ctx context.Context, eventID id.EventID) error {
+	_, err := s.Exec(ctx, "DELETE FROM matrix_reactions WHERE event_id=$1", eventID)
+	return err
+}
+
+// PruneReactions 删除早于指定时间的表态记录
+func (s *AppServiceStateStore) PruneReactions(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.Exec(ctx, "DELETE FROM matrix_reactions WHERE timestamp < $1", before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SaveThreadEvent 记录话题内的消息
+func (s *AppServiceStateStore) SaveThreadEvent(ctx context.Context, roomID id.RoomID, root, eventID id.EventID) error {
+	_, err := s.Exec(ctx, `INSERT INTO matrix_threads (event_id, room_id, root, timestamp)
+		VALUES ($1, $2, $3, $4) ON CONFLICT (event_id) DO NOTHING`,
+		eventID, roomID, root, time.Now().UnixMilli())
+	return err
+}
+
+// GetThreadRoot 获取消息所属话题的根消息 ID，不在已记录的话题中时返回空字符串
+func (s *AppServiceStateStore) GetThreadRoot(ctx context.Context, eventID id.EventID) (id.EventID, error) {
+	var root id.EventID
+	err := s.QueryRow(ctx, "SELECT root FROM matrix_threads WHERE event_id=$1", eventID).Scan(&root)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return root, err
+}
+
+// LatestThreadEvent 获取话题内最新一条已记录的消息 ID，没有记录时返回空字符串
+func (s *AppServiceStateStore) LatestThreadEvent(ctx context.Context, roomID id.RoomID, root id.EventID) (id.EventID, error) {
+	var eventID id.EventID
+	err := s.QueryRow(ctx, "SELECT event_id FROM matrix_threads WHERE room_id=$1 AND root=$2 ORDER BY timestamp DESC LIMIT 1",
+		roomID, root).Scan(&eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return eventID, err
+}
+
+// PruneThreads 删除早于指定时间的话题记录
+func (s *AppServiceStateStore) PruneThreads(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.Exec(ctx, "DELETE FROM matrix_threads WHERE timestamp < $1", before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SaveEncryptedMedia 记录加密媒体的解密信息，同一媒体再次出现时刷新记录时间
+func (s *AppServiceStateStore) SaveEncryptedMedia(ctx context.Context, file *event.EncryptedFileInfo, mimeType string) error {
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	_, err = s.Exec(ctx, `INSERT INTO matrix_encrypted_media (mxc, file, mimetype, timestamp) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (mxc) DO UPDATE SET file=excluded.file, mimetype=excluded.mimetype, timestamp=excluded.timestamp`,
+		file.URL, string(data), mimeType, time.Now().UnixMilli())
+	return err
+}
+
+// GetEncryptedMedia 获取加密媒体的解密信息和 MIME 类型，不是已记录的加密媒体时返回 nil
+func (s *AppServiceStateStore) GetEncryptedMedia(ctx context.Context, mxc string) (*event.EncryptedFileInfo, string, error) {
+	var data, mimeType string
+	err := s.QueryRow(ctx, "SELECT file, mimetype FROM matrix_encrypted_media WHERE mxc=$1", mxc).Scan(&data, &mimeType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	var file event.EncryptedFileInfo
+	if err := json.Unmarshal([]byte(data), &file); err != nil {
+		return nil, "", err
+	}
+	return &file, mimeType, nil
+}
+
+// PruneEncryptedMedia 删除早于指定时间的加密媒体记录
+func (s *AppServiceStateStore) PruneEncryptedMedia(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.Exec(ctx, "DELETE FROM matrix_encrypted_media WHERE timestamp < $1", before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// contentHash 计算媒体内容的 SHA-256 摘要
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
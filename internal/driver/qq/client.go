@@ -11,10 +11,13 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 	"sync"
 	"time"
 
+	"Relify/internal"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -25,6 +28,30 @@ type Config struct {
 	Listen   string `json:"listen" yaml:"listen"`     // HTTP 监听地址（HTTP 模式）
 	Secret   string `json:"secret" yaml:"secret"`     // 鉴权密钥
 	Group    string `json:"group" yaml:"group"`       // 群组 ID 列表（逗号分隔）
+
+	MentionAll bool `json:"mention_all" yaml:"mention_all"` // 将全体提及（如 Matrix 的 @room）转换为 @全体成员（需要 Bot 为群管理员）
+}
+
+// Validate 校验 QQ 驱动配置
+// 参数:
+//   - report: 问题报告函数
+func (c *Config) Validate(report func(path, msg string)) {
+	switch c.Protocol {
+	case "", "ws", "wss":
+		if c.URL == "" {
+			report("url", "WebSocket 模式必须设置 OneBot 服务地址")
+		} else if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+			report("url", fmt.Sprintf("无效的 WebSocket 地址: %s", c.URL))
+		}
+	case "http":
+		if c.URL == "" {
+			report("url", "HTTP 模式必须设置 OneBot HTTP API 地址")
+		} else if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			report("url", fmt.Sprintf("无效的 HTTP 地址: %s", c.URL))
+		}
+	default:
+		report("protocol", fmt.Sprintf("不支持的协议: %s（可选 ws、wss、http）", c.Protocol))
+	}
 }
 
 // Client OneBot 协议客户端
@@ -33,12 +60,18 @@ type Client struct {
 	cfg     *Config
 	handler func([]byte) // 事件处理函数
 
-	conn    *websocket.Conn // WebSocket 连接
-	mu      sync.Mutex      // 连接锁
-	echos   sync.Map        // API 调用响应通道 map[string]chan []byte
-	closeCh chan struct{}   // 关闭信号
+	conn    *websocket.Conn     // WebSocket 连接
+	mu      sync.Mutex          // 连接锁
+	echos   sync.Map            // API 调用响应通道 map[string]chan []byte
+	events  *internal.Sequencer // 按会话串行处理事件
+	closeCh chan struct{}       // 关闭信号
+
+	health internal.HealthTracker // 连接健康状态
 }
 
+// eventConcurrency 是同时处理事件的会话数上限
+const eventConcurrency = 64
+
 // NewClient 创建 OneBot 客户端
 // 参数:
 //   - cfg: 配置信息
@@ -50,6 +83,7 @@ func NewClient(cfg *Config, handler func([]byte)) *Client {
 	return &Client{
 		cfg:     cfg,
 		handler: handler,
+		events:  internal.NewSequencer(make(chan struct{}, eventConcurrency)),
 		closeCh: make(chan struct{}),
 	}
 }
@@ -95,6 +129,7 @@ func (c *Client) startWSClient(ctx context.Context) {
 		conn, _, err := websocket.DefaultDialer.Dial(c.cfg.URL, header)
 		if err != nil {
 			slog.Warn("QQ 连接失败", "error", err)
+			c.health.SetConnected(false, err)
 			// 连接失败，等待重试
 			select {
 			case <-ticker.C:
@@ -112,12 +147,14 @@ func (c *Client) startWSClient(ctx context.Context) {
 		c.mu.Unlock()
 
 		slog.Info("QQ 连接成功")
+		c.health.SetConnected(true, nil)
 
 		// 读取消息循环
 		for {
 			_, msg, err := conn.ReadMessage()
 			if err != nil {
 				slog.Warn("QQ 连接断开", "error", err)
+				c.health.SetConnected(false, err)
 				break // 连接断开，重新连接
 			}
 			c.processMessage(msg) // 处理消息
@@ -159,7 +196,37 @@ func (c *Client) startHTTPServer(ctx context.Context) {
 		_ = server.Shutdown(context.Background())
 	}()
 
-	_ = server.ListenAndServe()
+	// HTTP 模式没有长连接，定期调用 API 探测 OneBot 实现是否可达
+	go c.probeHTTP(ctx)
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Error("QQ HTTP 服务错误", "error", err)
+		c.health.SetConnected(false, err)
+	}
+}
+
+// probeHTTP 定期调用 get_status 更新 HTTP 模式下的连接状态
+// 参数:
+//   - ctx: 上下文
+func (c *Client) probeHTTP(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.Call(ctx, "get_status", nil); err != nil {
+			c.health.SetConnected(false, err)
+		} else {
+			c.health.SetConnected(true, nil)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		case <-c.closeCh:
+			return
+		}
+	}
 }
 
 // handleHTTPRequest 处理 HTTP 请求（OneBot 事件推送）
@@ -187,10 +254,8 @@ func (c *Client) handleHTTPRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 异步处理事件
-	if c.handler != nil {
-		go c.handler(body)
-	}
+	// 交由所属会话的处理队列按顺序处理
+	c.pushEvent(body)
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -238,8 +303,50 @@ func (c *Client) processMessage(msg []byte) {
 	}
 
 	// 否则视为事件推送
-	if c.handler != nil {
-		go c.handler(msg)
+	c.pushEvent(msg)
+}
+
+// pushEvent 将事件提交到所属会话的处理队列
+// 读取循环同时负责接收 API 响应，而事件处理中会调用 API，因此提交不能阻塞读取循环；
+// 同一群或私聊的事件按接收顺序处理，不同会话之间互不阻塞
+// 参数:
+//   - msg: 事件内容
+func (c *Client) pushEvent(msg []byte) {
+	if c.handler == nil {
+		return
+	}
+	c.health.RecordEvent()
+	c.events.Submit(eventKey(msg), func() {
+		select {
+		case <-c.closeCh:
+			return // 已关闭，丢弃尚未处理的事件
+		default:
+		}
+		c.handler(msg)
+	})
+}
+
+// eventKey 返回事件所属会话的键：群事件为群号，私聊事件为对方 QQ 号，其余事件（如元事件）共用空键
+// 参数:
+//   - msg: 事件内容
+//
+// 返回:
+//   - string: 会话键
+func eventKey(msg []byte) string {
+	var evt struct {
+		GroupID int64 `json:"group_id"`
+		UserID  int64 `json:"user_id"`
+	}
+	if json.Unmarshal(msg, &evt) != nil {
+		return ""
+	}
+	switch {
+	case evt.GroupID != 0:
+		return "group:" + strconv.FormatInt(evt.GroupID, 10)
+	case evt.UserID != 0:
+		return "private:" + strconv.FormatInt(evt.UserID, 10)
+	default:
+		return ""
 	}
 }
 
@@ -267,10 +374,24 @@ func (c *Client) Call(ctx context.Context, action string, params any) ([]byte, e
 	ctx, cancel := context.WithTimeout(ctx, 1*time.Minute)
 	defer cancel()
 
+	start := time.Now()
+	var resp []byte
+	var err error
 	if c.cfg.Protocol == "http" {
-		return c.callHTTP(ctx, action, params)
+		resp, err = c.callHTTP(ctx, action, params)
+	} else {
+		resp, err = c.callWS(ctx, action, params)
+	}
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+		c.health.RecordError(err)
+	} else {
+		c.health.RecordSuccess()
 	}
-	return c.callWS(ctx, action, params)
+	apiCallDuration.WithLabelValues(action, result).Observe(time.Since(start).Seconds())
+	return resp, err
 }
 
 // callWS 通过 WebSocket 调用 API
@@ -0,0 +1,88 @@
+package qq
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventKey(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{`{"post_type":"message","message_type":"group","group_id":123,"user_id":456}`, "group:123"},
+		{`{"post_type":"message","message_type":"private","user_id":456}`, "private:456"},
+		{`{"post_type":"notice","notice_type":"group_recall","group_id":123,"user_id":789}`, "group:123"},
+		{`{"post_type":"meta_event","meta_event_type":"heartbeat"}`, ""},
+		{`not json`, ""},
+	}
+
+	for _, tt := range tests {
+		if got := eventKey([]byte(tt.msg)); got != tt.want {
+			t.Errorf("eventKey(%s) = %q，期望 %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestPushEventPerConversation(t *testing.T) {
+	block := make(chan struct{})
+	var mu sync.Mutex
+	got := make(map[string][]int)
+	c := NewClient(&Config{}, func(msg []byte) {
+		key := eventKey(msg)
+		if key == "group:1" {
+			<-block // 模拟处理缓慢的群
+		}
+		var seq int
+		fmt.Sscanf(string(msg), `{"group_id":%d,"seq":%d}`, new(int), &seq)
+		mu.Lock()
+		got[key] = append(got[key], seq)
+		mu.Unlock()
+	})
+	defer c.Close()
+
+	// 读取循环提交事件时不能被处理缓慢的会话阻塞
+	done := make(chan struct{})
+	go func() {
+		for i := range 2000 {
+			c.pushEvent(fmt.Appendf(nil, `{"group_id":%d,"seq":%d}`, 1+i%2, i))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pushEvent 被阻塞")
+	}
+
+	// 其他群不受影响
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(got["group:2"])
+		mu.Unlock()
+		if n == 1000 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("群 2 只处理了 %d 条事件", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(block)
+	c.events.Wait()
+
+	for key, first := range map[string]int{"group:1": 0, "group:2": 1} {
+		want := make([]int, 1000)
+		for i := range want {
+			want[i] = first + 2*i
+		}
+		if !slices.Equal(got[key], want) {
+			t.Errorf("%s 的处理顺序不符", key)
+		}
+	}
+}
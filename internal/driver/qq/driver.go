@@ -10,23 +10,28 @@ import (
 	"Relify/internal"
 )
 
+func init() {
+	internal.RegisterDriver("qq", func(props internal.Properties) (internal.Driver, error) {
+		return NewQQ(props)
+	}, func() internal.ConfigSchema { return &Config{} })
+}
+
 // QQ 实现 QQ 平台的驱动
 // 使用 OneBot 11 协议与 QQ 客户端通信
 type QQ struct {
-	cfg    *Config          // QQ 配置
-	router *internal.Router // 消息路由器
-	client *Client          // OneBot 客户端
+	cfg    *Config      // QQ 配置
+	api    internal.API // 核心接口（消息提交与映射查询）
+	client *Client      // OneBot 客户端
 }
 
 // NewQQ 创建新的 QQ 驱动实例
 // 参数:
 //   - props: 配置属性
-//   - router: 消息路由器
 //
 // 返回:
 //   - *QQ: QQ 驱动实例
 //   - error: 初始化错误
-func NewQQ(props internal.Props, router *internal.Router) (*QQ, error) {
+func NewQQ(props internal.Properties) (*QQ, error) {
 	b, _ := json.Marshal(props)
 	var cfg Config
 	if err := json.Unmarshal(b, &cfg); err != nil {
@@ -41,33 +46,31 @@ func NewQQ(props internal.Props, router *internal.Router) (*QQ, error) {
 		"url", cfg.URL,
 	)
 
-	q := &QQ{
-		cfg:    &cfg,
-		router: router,
-	}
+	q := &QQ{cfg: &cfg}
 	q.client = NewClient(&cfg, q.handleMsg) // 创建 OneBot 客户端
 
-	slog.Info("QQ 驱动初始化完成")
-
 	return q, nil
 }
 
-// Name 返回驱动名称
-func (q *QQ) Name() string { return "qq" }
+// name 返回驱动在系统内部使用的平台名称
+func (q *QQ) name() string { return "qq" }
 
-// Route 返回路由模式（混合模式）
-// QQ 将所有桥接消息发送到同一个群组
-func (q *QQ) Route() internal.Route { return internal.RouteMix }
-
-// Start 启动 QQ 驱动
+// Init 初始化 QQ 驱动并异步连接 OneBot 服务
+// QQ 将所有桥接消息发送到同一个群组（混合模式）
 // 参数:
 //   - ctx: 上下文
+//   - api: 核心接口
 //
 // 返回:
-//   - error: 启动错误
-func (q *QQ) Start(ctx context.Context) error {
+//   - string: 平台名称
+//   - internal.RoutePolicy: 路由策略
+//   - error: 初始化错误
+func (q *QQ) Init(ctx context.Context, api internal.API) (string, internal.RoutePolicy, error) {
+	q.api = api
 	go q.client.Connect(ctx) // 异步连接 OneBot 服务
-	return nil
+
+	slog.Info("QQ 驱动初始化完成")
+	return q.name(), internal.PolicyMix, nil
 }
 
 // Stop 停止 QQ 驱动
@@ -81,20 +84,28 @@ func (q *QQ) Stop(ctx context.Context) error {
 	return nil
 }
 
-// Info 获取 QQ 群组或用户的信息
+// Health 返回与 OneBot 实现之间的连接健康状态
+// WebSocket 模式以连接状态为准，HTTP 模式定期调用 get_status 探测
+// 返回:
+//   - internal.Health: 健康状态
+func (q *QQ) Health() internal.Health {
+	return q.client.health.Health()
+}
+
+// GetRoomInfo 获取 QQ 群组或私聊对象的信息
 // 参数:
 //   - ctx: 上下文
-//   - room: 房间 ID（群号或 "p:用户QQ号"）
+//   - roomID: 房间 ID（群号或 "p:用户QQ号"）
 //
 // 返回:
-//   - *internal.Info: 群组或用户信息
+//   - *internal.RoomInfo: 群组或用户信息
 //   - error: 获取错误
-func (q *QQ) Info(ctx context.Context, room string) (*internal.Info, error) {
-	info := &internal.Info{ID: room, Name: room}
+func (q *QQ) GetRoomInfo(ctx context.Context, roomID string) (*internal.RoomInfo, error) {
+	info := &internal.RoomInfo{ID: roomID, Name: roomID}
 
 	// 检查是否为私聊
-	isPrivate := strings.HasPrefix(room, "p:")
-	realID := strings.TrimPrefix(room, "p:")
+	isPrivate := strings.HasPrefix(roomID, "p:")
+	realID := strings.TrimPrefix(roomID, "p:")
 
 	if !isPrivate {
 		// 尝试获取群组信息
@@ -104,8 +115,11 @@ func (q *QQ) Info(ctx context.Context, room string) (*internal.Info, error) {
 	}
 
 	// 尝试获取用户信息
-	if err := q.getUserInfo(ctx, realID, info); err == nil {
-		return info, nil
+	if user, err := q.GetUserInfo(ctx, realID); err == nil {
+		info.ID = "p:" + realID // 标记为私聊
+		info.Name = user.Name
+		info.Avatar = user.Avatar
+		info.Topic = fmt.Sprintf("用户: %s", realID)
 	}
 
 	return info, nil
@@ -119,7 +133,7 @@ func (q *QQ) Info(ctx context.Context, room string) (*internal.Info, error) {
 //
 // 返回:
 //   - error: 获取错误
-func (q *QQ) getGroupInfo(ctx context.Context, groupID string, info *internal.Info) error {
+func (q *QQ) getGroupInfo(ctx context.Context, groupID string, info *internal.RoomInfo) error {
 	resp, err := q.client.Call(ctx, "get_group_info", map[string]any{
 		"group_id": groupID,
 		"no_cache": true, // 不使用缓存，获取最新信息
@@ -144,21 +158,21 @@ func (q *QQ) getGroupInfo(ctx context.Context, groupID string, info *internal.In
 	return nil
 }
 
-// getUserInfo 获取 QQ 用户信息
+// GetUserInfo 获取 QQ 用户信息
 // 参数:
 //   - ctx: 上下文
 //   - userID: 用户 QQ 号
-//   - info: 用于填充的信息对象
 //
 // 返回:
+//   - *internal.Sender: 用户信息
 //   - error: 获取错误
-func (q *QQ) getUserInfo(ctx context.Context, userID string, info *internal.Info) error {
+func (q *QQ) GetUserInfo(ctx context.Context, userID string) (*internal.Sender, error) {
 	resp, err := q.client.Call(ctx, "get_stranger_info", map[string]any{
 		"user_id":  userID,
 		"no_cache": true, // 不使用缓存
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var d struct {
@@ -168,17 +182,18 @@ func (q *QQ) getUserInfo(ctx context.Context, userID string, info *internal.Info
 		} `json:"data"`
 	}
 	if err := json.Unmarshal(resp, &d); err != nil || d.Data.Nickname == "" {
-		return fmt.Errorf("无效响应")
+		return nil, fmt.Errorf("无效响应")
 	}
 
-	info.Name = d.Data.Nickname
-	info.Topic = fmt.Sprintf("用户: %d", d.Data.UserID)
-	info.Avatar = fmt.Sprintf("https://q1.qlogo.cn/g?b=qq&nk=%s&s=640", userID) // QQ 用户头像 URL
-	info.ID = "p:" + userID                                                     // 标记为私聊
-	return nil
+	return &internal.Sender{
+		ID:     userID,
+		Name:   d.Data.Nickname,
+		Type:   internal.SenderUser,
+		Avatar: avatarURL(userID), // QQ 用户头像 URL
+	}, nil
 }
 
-// Make 获取或返回目标房间 ID
+// CreateRoom 获取或返回目标房间 ID
 // 参数:
 //   - ctx: 上下文
 //   - info: 房间信息（混合模式下可为 nil）
@@ -186,7 +201,7 @@ func (q *QQ) getUserInfo(ctx context.Context, userID string, info *internal.Info
 // 返回:
 //   - string: 房间 ID
 //   - error: 错误
-func (q *QQ) Make(ctx context.Context, info *internal.Info) (string, error) {
+func (q *QQ) CreateRoom(ctx context.Context, info *internal.RoomInfo) (string, error) {
 	// 如果提供了房间信息，直接返回
 	if info != nil && info.ID != "" {
 		return info.ID, nil
@@ -198,3 +213,8 @@ func (q *QQ) Make(ctx context.Context, info *internal.Info) (string, error) {
 	}
 	return "", fmt.Errorf("需要配置'group'字段")
 }
+
+// avatarURL 返回 QQ 用户头像的公开 URL
+func avatarURL(userID string) string {
+	return fmt.Sprintf("https://q1.qlogo.cn/g?b=qq&nk=%s&s=640", userID)
+}
@@ -32,6 +32,7 @@ type onebotEvent struct {
 	OperatorID int64    `json:"operator_id"` // 操作者 QQ 号
 	TargetID   int64    `json:"target_id"`   // 目标 QQ 号
 	File       fileInfo `json:"file"`        // 文件信息
+	StatusText string   `json:"status_text"` // 输入状态文本（为空表示停止输入）
 
 	// 请求事件字段
 	RequestType string `json:"request_type"` // 请求类型
@@ -43,6 +44,7 @@ type onebotEvent struct {
 type senderInfo struct {
 	Nickname string `json:"nickname"` // 昵称
 	Card     string `json:"card"`     // 群名片
+	Role     string `json:"role"`     // 群角色: owner/admin/member
 }
 
 // fileInfo 文件信息
@@ -68,6 +70,11 @@ func (q *QQ) handleMsg(data []byte) {
 		return
 	}
 
+	// 驱动尚未初始化完成时丢弃事件
+	if q.api == nil {
+		return
+	}
+
 	slog.Debug("QQ 接收事件",
 		"post_type", evt.PostType,
 		"msg_type", evt.MsgType,
@@ -78,9 +85,9 @@ func (q *QQ) handleMsg(data []byte) {
 	ctx := context.Background()
 	// 构建基础事件
 	base := &internal.Event{
-		Time: time.Unix(evt.Time, 0),
-		Plat: q.Name(),
-		Extra: internal.Props{
+		Time:     time.Unix(evt.Time, 0),
+		Platform: q.name(),
+		Extra: internal.Properties{
 			"self_id": evt.SelfID,
 		},
 	}
@@ -102,44 +109,49 @@ func (q *QQ) handleMsg(data []byte) {
 //   - src: OneBot 事件
 //   - dst: 内部事件（将被填充）
 func (q *QQ) handleMessage(ctx context.Context, src *onebotEvent, dst *internal.Event) {
+	userID := strconv.FormatInt(src.UserID, 10)
+
 	dst.ID = strconv.Itoa(int(src.MsgID))
-	dst.Kind = internal.Msg
-	dst.User = strconv.FormatInt(src.UserID, 10)
-	dst.Avatar = fmt.Sprintf("https://q1.qlogo.cn/g?b=qq&nk=%d&s=640", src.UserID) // QQ 头像 URL
+	dst.Type = internal.TypeMessage
+	dst.Sender = &internal.Sender{
+		ID:       userID,
+		Nickname: src.Sender.Nickname, // 全局昵称（Name 可能是群名片）
+		Type:     internal.SenderUser,
+		Avatar:   avatarURL(userID), // QQ 头像 URL
+	}
 
 	// 获取发送者昵称（优先使用群名片）
-	dst.Name = src.Sender.Card
-	if dst.Name == "" {
-		dst.Name = src.Sender.Nickname
+	dst.Sender.Name = src.Sender.Card
+	if dst.Sender.Name == "" {
+		dst.Sender.Name = src.Sender.Nickname
+	}
+	if dst.Sender.Name == "" {
+		dst.Sender.Name = userID
 	}
-	if dst.Name == "" {
-		dst.Name = dst.User
+	if src.Sender.Role != "" {
+		dst.Sender.Role = internal.Properties{"role": src.Sender.Role}
 	}
 
 	// 区分群聊和私聊
 	if src.MsgType == "group" {
-		dst.Room = strconv.FormatInt(src.GroupID, 10)
+		dst.RoomID = strconv.FormatInt(src.GroupID, 10)
 		dst.Extra["chat_type"] = "group"
 	} else {
-		dst.Room = fmt.Sprintf("p:%d", src.UserID) // 私聊房间 ID 使用 "p:" 前缀
+		dst.RoomID = fmt.Sprintf("p:%d", src.UserID) // 私聊房间 ID 使用 "p:" 前缀
 		dst.Extra["chat_type"] = "private"
 	}
 
 	slog.Debug("QQ 处理消息",
 		"id", dst.ID,
-		"user", dst.User,
-		"room", dst.Room,
+		"user", userID,
+		"room", dst.RoomID,
 		"type", dst.Extra["chat_type"],
 	)
 
 	// 解析消息段（提取回复引用）
-	var refID string
-	dst.Segs, refID = q.parseSegs(ctx, src.Message)
-	if refID != "" {
-		dst.Ref = refID
-	}
+	dst.Segments, dst.RefID = q.parseSegs(ctx, src.Message)
 
-	q.router.Handle(ctx, dst)
+	q.api.Receive(ctx, dst)
 }
 
 // handleNotice 处理通知事件
@@ -148,16 +160,16 @@ func (q *QQ) handleMessage(ctx context.Context, src *onebotEvent, dst *internal.
 //   - src: OneBot 事件
 //   - dst: 内部事件（将被填充）
 func (q *QQ) handleNotice(ctx context.Context, src *onebotEvent, dst *internal.Event) {
-	dst.Kind = internal.Note
+	dst.Type = internal.TypeNotice
 	if src.UserID != 0 {
-		dst.User = strconv.FormatInt(src.UserID, 10)
+		dst.Sender = q.noticeSender(src.UserID)
 	}
 
 	// 设置房间 ID
 	if src.GroupID != 0 {
-		dst.Room = strconv.FormatInt(src.GroupID, 10)
+		dst.RoomID = strconv.FormatInt(src.GroupID, 10)
 	} else if src.UserID != 0 {
-		dst.Room = fmt.Sprintf("p:%d", src.UserID)
+		dst.RoomID = fmt.Sprintf("p:%d", src.UserID)
 	}
 
 	// 根据通知类型处理
@@ -169,12 +181,31 @@ func (q *QQ) handleNotice(ctx context.Context, src *onebotEvent, dst *internal.E
 	case "group_upload":
 		q.handleFileUpload(src, dst) // 文件上传
 	case "friend_add":
-		dst.Segs = []internal.Seg{{Kind: "text", Raw: internal.Props{"txt": "成为好友"}}}
+		dst.Segments = []internal.Segment{{Type: internal.SegText, Text: "成为好友"}}
 	}
 
-	// 只有有内容或引用的通知才转发
-	if len(dst.Segs) > 0 || dst.Ref != "" {
-		q.router.Handle(ctx, dst)
+	// 只有有内容或引用的通知以及输入状态才转发
+	if len(dst.Segments) > 0 || dst.RefID != "" || dst.Type == internal.TypeTyping {
+		if dst.ID == "" {
+			dst.ID = fmt.Sprintf("%s_%d", src.NoticeType, dst.Time.UnixNano())
+		}
+		q.api.Receive(ctx, dst)
+	}
+}
+
+// noticeSender 构建通知事件的触发者信息
+// 参数:
+//   - userID: 用户 QQ 号
+//
+// 返回:
+//   - *internal.Sender: 触发者信息
+func (q *QQ) noticeSender(userID int64) *internal.Sender {
+	uid := strconv.FormatInt(userID, 10)
+	return &internal.Sender{
+		ID:     uid,
+		Name:   uid,
+		Type:   internal.SenderUser,
+		Avatar: avatarURL(uid),
 	}
 }
 
@@ -183,25 +214,28 @@ func (q *QQ) handleNotice(ctx context.Context, src *onebotEvent, dst *internal.E
 //   - src: OneBot 事件
 //   - dst: 内部事件（将被填充）
 func (q *QQ) handleRecallNotice(src *onebotEvent, dst *internal.Event) {
-	dst.Extra["subtype"] = internal.Revoke
+	dst.Type = internal.TypeRevoke
 	if src.OperatorID != 0 {
-		dst.User = strconv.FormatInt(src.OperatorID, 10) // 撤回操作者
+		dst.Sender = q.noticeSender(src.OperatorID) // 撤回操作者
 	}
-	dst.Ref = strconv.Itoa(int(src.MsgID))  // 被撤回的消息 ID
-	dst.ID = fmt.Sprintf("rev_%s", dst.Ref) // 撤回事件 ID
-	dst.Segs = []internal.Seg{{Kind: "text", Raw: internal.Props{"txt": "撤回消息"}}}
+	dst.RefID = strconv.Itoa(int(src.MsgID))  // 被撤回的消息 ID
+	dst.ID = fmt.Sprintf("rev_%s", dst.RefID) // 撤回事件 ID
 }
 
-// handleNotifyEvent 处理戳一戳等通知事件
+// handleNotifyEvent 处理戳一戳、输入状态等通知事件
 // 参数:
 //   - src: OneBot 事件
 //   - dst: 内部事件（将被填充）
 func (q *QQ) handleNotifyEvent(src *onebotEvent, dst *internal.Event) {
 	switch src.SubType {
+	case "input_status":
+		// 私聊对方正在输入（NapCat 扩展）
+		dst.Type = internal.TypeTyping
+		dst.Extra["typing"] = src.StatusText != ""
 	case "poke":
-		dst.Segs = []internal.Seg{{Kind: "text", Raw: internal.Props{"txt": fmt.Sprintf("戳了戳 %d", src.TargetID)}}}
+		dst.Segments = []internal.Segment{{Type: internal.SegText, Text: fmt.Sprintf("戳了戳 %d", src.TargetID)}}
 	case "lucky_king":
-		dst.Segs = []internal.Seg{{Kind: "text", Raw: internal.Props{"txt": "成为运气王"}}}
+		dst.Segments = []internal.Segment{{Type: internal.SegText, Text: "成为运气王"}}
 	}
 }
 
@@ -210,14 +244,20 @@ func (q *QQ) handleNotifyEvent(src *onebotEvent, dst *internal.Event) {
 //   - src: OneBot 事件
 //   - dst: 内部事件（将被填充）
 func (q *QQ) handleFileUpload(src *onebotEvent, dst *internal.Event) {
-	dst.Segs = []internal.Seg{
-		{Kind: "text", Raw: internal.Props{"txt": fmt.Sprintf("[文件] %s (%d 字节)", src.File.Name, src.File.Size)}},
+	dst.Segments = []internal.Segment{
+		{Type: internal.SegText, Text: fmt.Sprintf("[文件] %s (%d 字节)", src.File.Name, src.File.Size)},
 	}
 	// 如果有下载链接，添加文件段
 	if src.File.Url != "" {
-		dst.Segs = append(dst.Segs, internal.Seg{
-			Kind: "file",
-			Raw:  internal.Props{"url": src.File.Url, "name": src.File.Name},
+		dst.Segments = append(dst.Segments, internal.Segment{
+			Type: internal.SegFile,
+			ID:   src.File.ID,
+			File: &internal.FileInfo{
+				ID:   src.File.ID,
+				URL:  src.File.Url,
+				Name: src.File.Name,
+				Size: src.File.Size,
+			},
 		})
 	}
 }
@@ -228,18 +268,19 @@ func (q *QQ) handleFileUpload(src *onebotEvent, dst *internal.Event) {
 //   - src: OneBot 事件
 //   - dst: 内部事件（将被填充）
 func (q *QQ) handleRequest(ctx context.Context, src *onebotEvent, dst *internal.Event) {
-	dst.Kind = internal.Note
-	dst.User = strconv.FormatInt(src.UserID, 10)
+	dst.ID = src.Flag
+	dst.Type = internal.TypeNotice
+	dst.Sender = q.noticeSender(src.UserID)
 	if src.GroupID != 0 {
-		dst.Room = strconv.FormatInt(src.GroupID, 10)
+		dst.RoomID = strconv.FormatInt(src.GroupID, 10)
 	} else {
-		dst.Room = fmt.Sprintf("p:%d", src.UserID)
+		dst.RoomID = fmt.Sprintf("p:%d", src.UserID)
 	}
 
 	txt := fmt.Sprintf("请求 [%s]: %s (标识: %s)", src.RequestType, src.Comment, src.Flag)
-	dst.Segs = []internal.Seg{{Kind: "text", Raw: internal.Props{"txt": txt}}}
+	dst.Segments = []internal.Segment{{Type: internal.SegText, Text: txt}}
 
-	q.router.Handle(ctx, dst)
+	q.api.Receive(ctx, dst)
 }
 
 // parseSegs 解析 OneBot 消息段数组
@@ -248,22 +289,22 @@ func (q *QQ) handleRequest(ctx context.Context, src *onebotEvent, dst *internal.
 //   - raw: OneBot 消息段 JSON
 //
 // 返回:
-//   - []internal.Seg: 内部消息段列表
+//   - []internal.Segment: 内部消息段列表
 //   - string: 回复引用的消息 ID（如果有）
-func (q *QQ) parseSegs(ctx context.Context, raw json.RawMessage) ([]internal.Seg, string) {
+func (q *QQ) parseSegs(ctx context.Context, raw json.RawMessage) ([]internal.Segment, string) {
 	var arr []segmentItem
 
 	// 如果解析失败，视为纯文本
 	if json.Unmarshal(raw, &arr) != nil {
-		return []internal.Seg{{Kind: "text", Raw: internal.Props{"txt": string(raw)}}}, ""
+		return []internal.Segment{{Type: internal.SegText, Text: string(raw)}}, ""
 	}
 
-	var segs []internal.Seg
+	var segs []internal.Segment
 	var refID string
 
 	for _, item := range arr {
 		seg, ref := q.parseSegment(ctx, item)
-		if seg.Kind != "" {
+		if seg.Type != "" {
 			segs = append(segs, seg)
 		}
 		if ref != "" {
@@ -285,100 +326,88 @@ type segmentItem struct {
 //   - item: OneBot 消息段
 //
 // 返回:
-//   - internal.Seg: 内部消息段
+//   - internal.Segment: 内部消息段
 //   - string: 回复引用的消息 ID（仅 reply 类型返回）
-func (q *QQ) parseSegment(ctx context.Context, item segmentItem) (internal.Seg, string) {
+func (q *QQ) parseSegment(ctx context.Context, item segmentItem) (internal.Segment, string) {
 	switch item.Type {
 	case "text":
 		// 文本段
 		if t, ok := item.Data["text"].(string); ok {
-			return internal.Seg{Kind: "text", Raw: internal.Props{"txt": t}}, ""
+			return internal.Segment{Type: internal.SegText, Text: t}, ""
 		}
 
 	case "image", "flash":
 		// 图片段（包括闪照）
-		return internal.Seg{
-			Kind: "image",
-			Raw: internal.Props{
-				"url":  item.Data["url"],
-				"name": item.Data["file"],
-				"size": item.Data["file_size"],
-			},
-		}, ""
+		return internal.Segment{Type: internal.SegImage, File: q.parseFile(item.Data, "file")}, ""
 
 	case "record":
 		// 语音段
-		return internal.Seg{
-			Kind: "audio",
-			Raw: internal.Props{
-				"url":  item.Data["url"],
-				"name": item.Data["file"],
-			},
-		}, ""
+		return internal.Segment{Type: internal.SegAudio, File: q.parseFile(item.Data, "file")}, ""
 
 	case "video":
 		// 视频段
-		return internal.Seg{
-			Kind: "video",
-			Raw: internal.Props{
-				"url":  item.Data["url"],
-				"name": item.Data["file"],
-			},
-		}, ""
+		return internal.Segment{Type: internal.SegVideo, File: q.parseFile(item.Data, "file")}, ""
 
 	case "file":
 		// 文件段
-		return internal.Seg{
-			Kind: "file",
-			Raw: internal.Props{
-				"url":  item.Data["url"],
-				"name": item.Data["name"],
-				"size": item.Data["file_size"],
-			},
-		}, ""
+		return internal.Segment{Type: internal.SegFile, File: q.parseFile(item.Data, "name")}, ""
 
 	case "face":
 		// 表情段
-		return internal.Seg{
-			Kind: "text",
-			Raw:  internal.Props{"txt": fmt.Sprintf("[表情:%v]", item.Data["id"])},
-		}, ""
+		return internal.Segment{Type: internal.SegText, Text: fmt.Sprintf("[表情:%v]", item.Data["id"])}, ""
 
 	case "reply":
 		// 回复段：返回被回复的消息 ID
 		if id, ok := item.Data["id"]; ok {
-			return internal.Seg{}, fmt.Sprintf("%v", id)
+			return internal.Segment{}, fmt.Sprintf("%v", id)
 		}
 
 	case "at":
 		// @提及段
-		return internal.Seg{
-			Kind: "mention",
-			Raw:  internal.Props{"user": fmt.Sprintf("%v", item.Data["qq"])},
-		}, ""
+		uid := fmt.Sprintf("%v", item.Data["qq"])
+		if uid == "all" {
+			return internal.Segment{Type: internal.SegMention, ID: internal.MentionAll, Text: "全体成员"}, ""
+		}
+		name, _ := item.Data["name"].(string)
+		if name == "" {
+			name = uid
+		}
+		return internal.Segment{Type: internal.SegMention, ID: uid, Text: name}, ""
 
 	case "forward":
 		// 转发消息段：递归获取内容
 		if id, ok := item.Data["id"].(string); ok {
 			content := q.fetchForwardMsg(ctx, id, 0)
-			return internal.Seg{Kind: "text", Raw: internal.Props{"txt": content}}, ""
+			return internal.Segment{Type: internal.SegText, Text: content}, ""
 		}
-		return internal.Seg{Kind: "text", Raw: internal.Props{"txt": "[转发消息]"}}, ""
+		return internal.Segment{Type: internal.SegText, Text: "[转发消息]"}, ""
 
 	case "node":
 		// 转发节点段
-		return internal.Seg{Kind: "text", Raw: internal.Props{"txt": "[转发节点]"}}, ""
+		return internal.Segment{Type: internal.SegText, Text: "[转发节点]"}, ""
 
 	default:
 		// 未知类型：序列化为 JSON 显示
 		bs, _ := json.Marshal(item.Data)
-		return internal.Seg{
-			Kind: "text",
-			Raw:  internal.Props{"txt": fmt.Sprintf("[%s: %s]", item.Type, string(bs))},
-		}, ""
+		return internal.Segment{Type: internal.SegText, Text: fmt.Sprintf("[%s: %s]", item.Type, string(bs))}, ""
 	}
 
-	return internal.Seg{}, ""
+	return internal.Segment{}, ""
+}
+
+// parseFile 从 OneBot 消息段数据中提取文件元数据
+// 参数:
+//   - data: 段数据
+//   - nameKey: 文件名所在的字段（不同段类型不一致）
+//
+// 返回:
+//   - *internal.FileInfo: 文件元数据
+func (q *QQ) parseFile(data map[string]any, nameKey string) *internal.FileInfo {
+	info := &internal.FileInfo{Size: q.extractSize(data["file_size"])}
+	info.URL, _ = data["url"].(string)
+	info.Name, _ = data[nameKey].(string)
+	info.ID, _ = data["file_id"].(string)
+	return info
 }
 
 // fetchForwardMsg 递归获取转发消息内容
@@ -0,0 +1,13 @@
+package qq
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// apiCallDuration 记录 OneBot API 调用耗时，按动作和结果（success、failure）区分
+var apiCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "relify_onebot_call_duration_seconds",
+	Help:    "OneBot API 调用耗时，按动作和结果区分。",
+	Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+}, []string{"action", "result"})
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -19,13 +20,12 @@ import (
 //   - evt: 要发送的事件
 //
 // 返回:
-//   - string: OneBot 消息 ID
+//   - []internal.SendResult: 发送结果（OneBot 消息 ID）
 //   - error: 错误信息
-func (q *QQ) Send(ctx context.Context, node *internal.Node, evt *internal.Event) (string, error) {
+func (q *QQ) Send(ctx context.Context, node *internal.BridgeNode, evt *internal.Event) ([]internal.SendResult, error) {
 	slog.Debug("QQ 发送事件",
-		"room", node.Room,
-		"kind", evt.Kind,
-		"user", evt.User,
+		"room", node.RoomID,
+		"type", evt.Type,
 		"raw", func() string {
 			if data, err := json.Marshal(evt); err == nil {
 				return string(data)
@@ -34,21 +34,57 @@ func (q *QQ) Send(ctx context.Context, node *internal.Node, evt *internal.Event)
 		}(),
 	)
 
-	switch evt.Kind {
-	case internal.Msg:
-		// 普通消息
+	switch evt.Type {
+	case internal.TypeMessage, internal.TypeNotice:
+		// 普通消息与通知
 		return q.sendMsg(ctx, node, evt)
-	case internal.Edit:
+	case internal.TypeEdit:
 		// 编辑消息（QQ 不支持编辑，使用删除后重发）
 		return q.handleEdit(ctx, node, evt)
-	case internal.Note:
-		// 通知事件
-		if internal.Revoke == evt.Extra["subtype"] {
-			// 撤回消息
-			return "", q.deleteMsg(ctx, evt.Ref)
+	case internal.TypeRevoke:
+		// 撤回消息
+		msgID, ok := q.mapRef(evt)
+		if !ok {
+			return nil, internal.PermanentError(fmt.Errorf("未找到被撤回消息的映射: %s", evt.RefID))
 		}
+		return nil, q.deleteMsg(ctx, msgID)
+	case internal.TypeTyping:
+		// 输入状态
+		return nil, q.setInputStatus(ctx, node, evt)
+	case internal.TypeRead:
+		// 已读回执
+		return nil, q.markRead(ctx, node, evt)
 	}
-	return "", nil
+	return nil, nil
+}
+
+// mapRef 将事件引用的源平台消息 ID 转换为 QQ 消息 ID
+// 参数:
+//   - evt: 内部事件
+//
+// 返回:
+//   - string: QQ 消息 ID
+//   - bool: 是否找到映射
+func (q *QQ) mapRef(evt *internal.Event) (string, bool) {
+	if evt.RefID == "" {
+		return "", false
+	}
+	return q.api.FindMapping(evt.Platform, evt.RefID, q.name())
+}
+
+// mapThread 将事件所属的话题转换为 QQ 上可回复的消息 ID
+// QQ 没有话题，优先回复话题内最新转发到 QQ 的消息，其次回复话题的根消息
+// 参数:
+//   - evt: 内部事件
+//
+// 返回:
+//   - string: QQ 消息 ID
+//   - bool: 是否找到
+func (q *QQ) mapThread(evt *internal.Event) (string, bool) {
+	if msgID, ok := q.api.FindThread(evt.Platform, evt.ThreadID, q.name()); ok {
+		return msgID, true
+	}
+	return q.api.FindMapping(evt.Platform, evt.ThreadID, q.name())
 }
 
 // handleEdit 处理编辑消息（删除旧消息 + 发送新消息）
@@ -59,15 +95,17 @@ func (q *QQ) Send(ctx context.Context, node *internal.Node, evt *internal.Event)
 //   - evt: 编辑事件
 //
 // 返回:
-//   - string: 新消息 ID
+//   - []internal.SendResult: 新消息的发送结果
 //   - error: 错误信息
-func (q *QQ) handleEdit(ctx context.Context, node *internal.Node, evt *internal.Event) (string, error) {
-	if evt.Ref == "" {
-		return "", fmt.Errorf("编辑事件缺少引用")
+func (q *QQ) handleEdit(ctx context.Context, node *internal.BridgeNode, evt *internal.Event) ([]internal.SendResult, error) {
+	if evt.RefID == "" {
+		return nil, fmt.Errorf("编辑事件缺少引用")
 	}
 
 	// 删除原消息（忽略错误）
-	_ = q.deleteMsg(ctx, evt.Ref)
+	if msgID, ok := q.mapRef(evt); ok {
+		_ = q.deleteMsg(ctx, msgID)
+	}
 
 	// 发送新消息
 	return q.sendMsg(ctx, node, evt)
@@ -80,21 +118,20 @@ func (q *QQ) handleEdit(ctx context.Context, node *internal.Node, evt *internal.
 //   - evt: 要发送的事件
 //
 // 返回:
-//   - string: OneBot 消息 ID
+//   - []internal.SendResult: 发送结果（OneBot 消息 ID）
 //   - error: 错误信息
-func (q *QQ) sendMsg(ctx context.Context, node *internal.Node, evt *internal.Event) (string, error) {
-	// 判断是否为私聊（房间 ID 以 "p:" 开头）
-	isPrivate := strings.HasPrefix(node.Room, "p:")
-	roomID := strings.TrimPrefix(node.Room, "p:")
-
-	// 解析房间 ID（群号或 QQ 号）
-	idInt, err := strconv.ParseInt(roomID, 10, 64)
+func (q *QQ) sendMsg(ctx context.Context, node *internal.BridgeNode, evt *internal.Event) ([]internal.SendResult, error) {
+	idInt, isPrivate, err := parseRoomID(node.RoomID)
 	if err != nil {
-		return "", fmt.Errorf("无效的房间ID: %s", roomID)
+		return nil, err
 	}
 
 	// 构建 OneBot 消息段
-	obMsg := q.buildSegments(evt)
+	atAll := !isPrivate && q.canAtAll(ctx, idInt, evt)
+	obMsg := q.buildSegments(evt, atAll)
+	if len(obMsg) == 0 {
+		return nil, nil
+	}
 
 	// 根据聊天类型选择 API 动作
 	action := "send_group_msg"
@@ -108,42 +145,157 @@ func (q *QQ) sendMsg(ctx context.Context, node *internal.Node, evt *internal.Eve
 	// 调用 OneBot API
 	resp, err := q.client.Call(ctx, action, params)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// 解析响应，提取消息 ID
 	var d struct {
-		Data struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Data    struct {
 			ID int32 `json:"message_id"`
 		} `json:"data"`
 	}
 	if json.Unmarshal(resp, &d) != nil {
-		return "", fmt.Errorf("解析响应失败")
+		return nil, fmt.Errorf("解析响应失败")
+	}
+	if d.Status == "failed" {
+		// OneBot 实现已明确拒绝该请求（如无权限、被禁言），重试无意义
+		return nil, internal.PermanentError(fmt.Errorf("发送失败: %s", d.Message))
+	}
+
+	return []internal.SendResult{{MsgID: strconv.Itoa(int(d.Data.ID))}}, nil
+}
+
+// parseRoomID 解析房间 ID
+// 参数:
+//   - roomID: 房间 ID（群号，或以 "p:" 开头的私聊 QQ 号）
+//
+// 返回:
+//   - int64: 群号或 QQ 号
+//   - bool: 是否为私聊
+//   - error: 房间 ID 无效时的永久错误
+func parseRoomID(roomID string) (int64, bool, error) {
+	isPrivate := strings.HasPrefix(roomID, "p:")
+	idInt, err := strconv.ParseInt(strings.TrimPrefix(roomID, "p:"), 10, 64)
+	if err != nil {
+		return 0, false, internal.PermanentError(fmt.Errorf("无效的房间ID: %s", roomID))
+	}
+	return idInt, isPrivate, nil
+}
+
+// setInputStatus 在私聊中显示 Bot 正在输入
+// OneBot 没有群聊输入状态接口，也无法主动结束输入状态，群聊和停止输入事件直接忽略
+// 参数:
+//   - ctx: 上下文
+//   - node: 目标节点
+//   - evt: 输入状态事件
+//
+// 返回:
+//   - error: 错误信息
+func (q *QQ) setInputStatus(ctx context.Context, node *internal.BridgeNode, evt *internal.Event) error {
+	userID, isPrivate, err := parseRoomID(node.RoomID)
+	if err != nil || !isPrivate {
+		return err
+	}
+	if typing, ok := evt.Extra["typing"].(bool); ok && !typing {
+		return nil
+	}
+	_, err = q.client.Call(ctx, "set_input_status", map[string]any{"user_id": userID, "event_type": 1})
+	return err
+}
+
+// markRead 将群聊或私聊中的消息标记为已读
+// 只处理已读到 QQ 转发消息的回执：回执指向的消息没有 QQ 映射时（如其他平台自己的消息）忽略。
+// QQ 只能按会话标记已读，无法指定已读到的消息
+// 参数:
+//   - ctx: 上下文
+//   - node: 目标节点
+//   - evt: 已读回执事件
+//
+// 返回:
+//   - error: 错误信息
+func (q *QQ) markRead(ctx context.Context, node *internal.BridgeNode, evt *internal.Event) error {
+	msgID, ok := q.mapRef(evt)
+	if !ok {
+		slog.Debug("QQ 已读回执没有对应的 QQ 消息，忽略", "ref_id", evt.RefID)
+		return nil
+	}
+	idInt, isPrivate, err := parseRoomID(node.RoomID)
+	if err != nil {
+		return err
+	}
+	if isPrivate {
+		_, err = q.client.Call(ctx, "mark_private_msg_as_read", map[string]any{"user_id": idInt})
+	} else {
+		_, err = q.client.Call(ctx, "mark_group_msg_as_read", map[string]any{"group_id": idInt})
+	}
+	if err == nil {
+		slog.Debug("QQ 已标记已读", "room", node.RoomID, "msg_id", msgID)
 	}
+	return err
+}
 
-	return strconv.Itoa(int(d.Data.ID)), nil
+// canAtAll 判断消息中的全体提及能否以 @全体成员 发送
+// 需要配置 mention_all，且 OneBot 实现报告 Bot 在该群仍有 @全体成员 的权限和次数
+// 参数:
+//   - ctx: 上下文
+//   - groupID: 群号
+//   - evt: 要发送的事件
+//
+// 返回:
+//   - bool: 是否可以 @全体成员
+func (q *QQ) canAtAll(ctx context.Context, groupID int64, evt *internal.Event) bool {
+	if !q.cfg.MentionAll || !slices.ContainsFunc(evt.Segments, func(s internal.Segment) bool {
+		return s.Type == internal.SegMention && s.ID == internal.MentionAll
+	}) {
+		return false
+	}
+
+	resp, err := q.client.Call(ctx, "get_group_at_all_remain", map[string]any{"group_id": groupID})
+	if err != nil {
+		slog.Warn("QQ 查询 @全体成员 权限失败", "group_id", groupID, "error", err)
+		return false
+	}
+	var d struct {
+		Data struct {
+			CanAtAll bool `json:"can_at_all"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &d); err != nil {
+		return false
+	}
+	return d.Data.CanAtAll
 }
 
 // buildSegments 将内部消息段列表转换为 OneBot 格式
 // 参数:
 //   - evt: 内部事件
+//   - atAll: 全体提及是否以 @全体成员 发送（否则降级为文本）
 //
 // 返回:
 //   - []map[string]any: OneBot 消息段数组
-func (q *QQ) buildSegments(evt *internal.Event) []map[string]any {
+func (q *QQ) buildSegments(evt *internal.Event, atAll bool) []map[string]any {
 	var obMsg []map[string]any
 
-	// 如果是回复消息，添加 reply 段
-	if evt.Ref != "" && evt.Kind == internal.Msg {
-		obMsg = append(obMsg, map[string]any{
-			"type": "reply",
-			"data": map[string]string{"id": evt.Ref},
-		})
+	// 如果是回复消息，添加 reply 段；话题内的消息没有直接回复时回复话题
+	if evt.Type == internal.TypeMessage {
+		refID, ok := q.mapRef(evt)
+		if !ok && evt.ThreadID != "" {
+			refID, ok = q.mapThread(evt)
+		}
+		if ok {
+			obMsg = append(obMsg, map[string]any{
+				"type": "reply",
+				"data": map[string]string{"id": refID},
+			})
+		}
 	}
 
-	// 转换所有消息段
-	for _, s := range evt.Segs {
-		seg := q.buildSegment(&s)
+	// 转换所有消息段（QQ 不支持文本样式，使用降级后的纯文本）
+	segs := internal.PlainText(evt.Segments)
+	for i := range segs {
+		seg := q.buildSegment(&segs[i], evt.Platform, atAll)
 		if seg != nil {
 			obMsg = append(obMsg, seg)
 		}
@@ -155,54 +307,85 @@ func (q *QQ) buildSegments(evt *internal.Event) []map[string]any {
 // buildSegment 将单个内部消息段转换为 OneBot 格式
 // 参数:
 //   - s: 内部消息段
+//   - source: 事件的源平台（提及段未指定平台时被提及用户所在的平台）
+//   - atAll: 全体提及是否以 @全体成员 发送
 //
 // 返回:
 //   - map[string]any: OneBot 消息段（如果无法转换则返回 nil）
-func (q *QQ) buildSegment(s *internal.Seg) map[string]any {
-	switch s.Kind {
-	case "text":
+func (q *QQ) buildSegment(s *internal.Segment, source string, atAll bool) map[string]any {
+	switch s.Type {
+	case internal.SegText:
 		// 文本段
 		return map[string]any{
 			"type": "text",
-			"data": map[string]any{"text": s.Raw["txt"]},
+			"data": map[string]any{"text": s.Text},
 		}
 
-	case "image":
+	case internal.SegImage:
 		// 图片段
+		if s.File == nil {
+			return nil
+		}
 		return map[string]any{
 			"type": "image",
-			"data": map[string]any{"file": s.Raw["url"]},
+			"data": map[string]any{"file": s.File.URL},
 		}
 
-	case "audio":
+	case internal.SegAudio:
 		// 语音段
+		if s.File == nil {
+			return nil
+		}
 		return map[string]any{
 			"type": "record",
-			"data": map[string]any{"file": s.Raw["url"]},
+			"data": map[string]any{"file": s.File.URL},
 		}
 
-	case "video":
+	case internal.SegVideo:
 		// 视频段
+		if s.File == nil {
+			return nil
+		}
 		return map[string]any{
 			"type": "video",
-			"data": map[string]any{"file": s.Raw["url"]},
+			"data": map[string]any{"file": s.File.URL},
 		}
 
-	case "file":
+	case internal.SegFile:
 		// 文件段
-		data := map[string]any{"file": s.Raw["url"]}
-		if name, ok := s.Raw["name"].(string); ok && name != "" {
-			data["name"] = name // 添加文件名
+		if s.File == nil {
+			return nil
+		}
+		data := map[string]any{"file": s.File.URL}
+		if s.File.Name != "" {
+			data["name"] = s.File.Name // 添加文件名
 		}
-		if size := q.extractSize(s.Raw["size"]); size != 0 {
-			data["file_size"] = size // 添加文件大小
+		if s.File.Size != 0 {
+			data["file_size"] = s.File.Size // 添加文件大小
 		}
 		return map[string]any{"type": "file", "data": data}
 
-	case "mention":
+	case internal.SegMention:
 		// 提及段（@用户）
-		if u, ok := s.Raw["user"].(string); ok {
-			qqID := q.extractQQFromMXID(u) // 从 Matrix ID 提取 QQ 号
+		if s.ID == internal.MentionAll {
+			if !atAll {
+				return map[string]any{"type": "text", "data": map[string]any{"text": "@全体成员 "}}
+			}
+			return map[string]any{"type": "at", "data": map[string]any{"qq": "all"}}
+		}
+		if s.ID != "" {
+			qqID := s.ID
+			if _, err := strconv.ParseInt(qqID, 10, 64); err != nil || s.MentionedPlatform(source) != q.name() {
+				// 不是 QQ 用户（如 Matrix 用户），降级为文本
+				name := s.Text
+				if name == "" {
+					name = s.ID
+				}
+				return map[string]any{
+					"type": "text",
+					"data": map[string]any{"text": "@" + strings.TrimPrefix(name, "@") + " "},
+				}
+			}
 			return map[string]any{
 				"type": "at",
 				"data": map[string]any{"qq": qqID},
@@ -233,26 +416,6 @@ func (q *QQ) extractSize(size any) int64 {
 	return 0
 }
 
-// extractQQFromMXID 从 Matrix 用户 ID 提取 QQ 号
-// 参数:
-//   - userID: Matrix 用户 ID 或 QQ 号
-//
-// 返回:
-//   - string: QQ 号
-func (q *QQ) extractQQFromMXID(userID string) string {
-	// 检查是否为 Ghost 用户格式: @relify_qq_<QQ号>:<域名>
-	if strings.HasPrefix(userID, "@relify_qq_") && strings.Contains(userID, ":") {
-		parts := strings.Split(userID, ":")
-		if len(parts) > 0 {
-			localpart := parts[0]
-			qqID := strings.TrimPrefix(localpart, "@relify_qq_")
-			return qqID
-		}
-	}
-	// 否则直接返回（可能已是 QQ 号）
-	return userID
-}
-
 // deleteMsg 删除（撤回）QQ 消息
 // 参数:
 //   - ctx: 上下文
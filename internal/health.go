@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// stuckAfter 是驱动持续断开多久后视为卡死，/healthz 将返回失败以便外部重启实例。
+const stuckAfter = 5 * time.Minute
+
+// Health 描述驱动与其上游服务之间的连接健康状态。
+type Health struct {
+	// Connected 表示驱动当前与上游服务（OneBot 实现、Homeserver）连接正常。
+	Connected bool `json:"connected"`
+	// Degraded 表示连接正常但最近一次调用失败。
+	Degraded bool `json:"degraded"`
+	// LastError 是最近一次错误信息。
+	LastError string `json:"last_error,omitempty"`
+	// LastErrorAt 是最近一次错误的时间。
+	LastErrorAt time.Time `json:"last_error_at,omitzero"`
+	// LastEventAt 是最近一次收到上游事件的时间。
+	LastEventAt time.Time `json:"last_event_at,omitzero"`
+	// Since 是当前连接状态开始的时间。
+	Since time.Time `json:"since,omitzero"`
+}
+
+// HealthReporter 是驱动可选实现的健康检查接口。
+// 未实现该接口的驱动在初始化成功后始终视为已连接。
+type HealthReporter interface {
+	Health() Health
+}
+
+// HealthTracker 是并发安全的健康状态记录器，供驱动嵌入或持有。
+// 零值表示尚未连接。
+type HealthTracker struct {
+	mu sync.Mutex
+	h  Health
+}
+
+// SetConnected 更新连接状态，断开时记录原因。
+func (t *HealthTracker) SetConnected(connected bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.h.Connected != connected || t.h.Since.IsZero() {
+		t.h.Since = time.Now()
+	}
+	t.h.Connected = connected
+	if connected {
+		t.h.Degraded = false
+	}
+	if err != nil {
+		t.h.LastError = err.Error()
+		t.h.LastErrorAt = time.Now()
+	}
+}
+
+// RecordError 记录一次调用失败，连接状态不变但标记为降级。
+func (t *HealthTracker) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.h.Degraded = true
+	t.h.LastError = err.Error()
+	t.h.LastErrorAt = time.Now()
+}
+
+// RecordSuccess 记录一次调用成功，清除降级标记。
+func (t *HealthTracker) RecordSuccess() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.h.Degraded = false
+}
+
+// RecordEvent 记录收到上游事件的时间。
+func (t *HealthTracker) RecordEvent() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.h.LastEventAt = time.Now()
+}
+
+// Health 返回当前健康状态的快照。
+func (t *HealthTracker) Health() Health {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.h
+}
+
+// DriverState 汇总单个平台的启动结果与运行时健康状态。
+type DriverState struct {
+	PlatformReport
+	Health *Health `json:"health,omitempty"`
+}
+
+// DriverStates 返回按名称排序的全部平台状态。
+// 已加载但未实现 HealthReporter 的驱动视为已连接。
+func (c *Core) DriverStates() []DriverState {
+	reports := c.Report.Platforms()
+	states := make([]DriverState, 0, len(reports))
+	for _, rep := range reports {
+		state := DriverState{PlatformReport: rep}
+		if rep.Status == StatusLoaded {
+			h := Health{Connected: true}
+			if drv, ok := c.Registry.GetDriver(rep.Name); ok {
+				if hr, ok := drv.(HealthReporter); ok {
+					h = hr.Health()
+				}
+			}
+			state.Health = &h
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// Live 判断实例是否存活：存储层可用，且没有已加载的驱动持续断开超过 stuckAfter。
+// 返回失败原因列表，为空表示存活。
+func (c *Core) Live() []string {
+	var problems []string
+	if err := c.Store.Ping(); err != nil {
+		problems = append(problems, "存储层不可用: "+err.Error())
+	}
+	for _, st := range c.DriverStates() {
+		if h := st.Health; h != nil && !h.Connected && !h.Since.IsZero() && time.Since(h.Since) > stuckAfter {
+			problems = append(problems, st.Name+": 持续断开超过 "+stuckAfter.String())
+		}
+	}
+	return problems
+}
+
+// Ready 判断实例是否可以处理消息：启动完成，且所有已加载的驱动均已连接。
+// 返回未就绪原因列表，为空表示就绪。
+func (c *Core) Ready() []string {
+	if !c.started.Load() {
+		return []string{"启动未完成"}
+	}
+	var problems []string
+	for _, st := range c.DriverStates() {
+		if st.Health != nil && !st.Health.Connected {
+			problems = append(problems, st.Name+": 未连接")
+		}
+	}
+	return problems
+}
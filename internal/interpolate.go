@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// envPrefix 是覆盖顶层配置项的环境变量前缀，如 RELIFY_LOG_LEVEL、RELIFY_ADMIN_TOKEN。
+const envPrefix = "RELIFY_"
+
+// fileSuffix 是文件间接引用的键名后缀：token_file: /run/secrets/token 等价于 token: <文件内容>。
+const fileSuffix = "_file"
+
+// envPattern 匹配 ${NAME} 与 ${NAME:-默认值}。
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandEnv 替换字符串中的 ${NAME} 与 ${NAME:-默认值}，引用未设置且无默认值的环境变量时返回错误。
+func expandEnv(s string) (string, error) {
+	var missing []string
+	out := envPattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := envPattern.FindStringSubmatch(m)
+		if v, ok := os.LookupEnv(sub[1]); ok {
+			return v
+		}
+		if strings.Contains(m, ":-") {
+			return sub[2]
+		}
+		missing = append(missing, sub[1])
+		return m
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("环境变量未设置: %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// resolveProperties 递归处理驱动配置中的环境变量插值和文件间接引用，path 用于错误信息。
+// 以 _file 结尾的键会被替换为去掉后缀的键，值为文件内容（去除首尾空白）；
+// 同时存在两者时文件引用优先。
+func resolveProperties(props map[string]any, path string) error {
+	for key, value := range props {
+		resolved, err := resolveValue(value, path+"."+key)
+		if err != nil {
+			return err
+		}
+		props[key] = resolved
+	}
+
+	for key, value := range props {
+		name, ok := strings.CutSuffix(key, fileSuffix)
+		if !ok || name == "" {
+			continue
+		}
+		file, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s.%s: 文件路径必须是字符串", path, key)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", path, key, err)
+		}
+		delete(props, key)
+		props[name] = strings.TrimSpace(string(data))
+	}
+	return nil
+}
+
+// resolveValue 处理单个配置值，字符串做环境变量插值，映射与列表递归处理。
+func resolveValue(value any, path string) (any, error) {
+	switch v := value.(type) {
+	case string:
+		s, err := expandEnv(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return s, nil
+	case Properties:
+		return v, resolveProperties(v, path)
+	case map[string]any:
+		return v, resolveProperties(v, path)
+	case []any:
+		for i := range v {
+			r, err := resolveValue(v[i], fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = r
+		}
+	}
+	return value, nil
+}
+
+// applyEnvOverrides 使用 RELIFY_* 环境变量覆盖配置中的标量字段。
+// 变量名由字段的 yaml 路径转为大写并以下划线连接，如 admin.token 对应 RELIFY_ADMIN_TOKEN。
+// 映射与列表类型的字段（如 platforms）不支持覆盖。
+func applyEnvOverrides(c *Config) error {
+	return overrideStruct(reflect.ValueOf(c).Elem(), envPrefix)
+}
+
+// overrideStruct 递归覆盖结构体中带 yaml 标签的标量字段。
+func overrideStruct(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + strings.ToUpper(tag)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			if err := overrideStruct(field, name+"_"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(raw)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s: 需要整数: %s", name, raw)
+			}
+			field.SetInt(n)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				return fmt.Errorf("%s: 需要布尔值: %s", name, raw)
+			}
+			field.SetBool(b)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("RELIFY_TEST_HOST", "example.org")
+	t.Setenv("RELIFY_TEST_EMPTY", "")
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "plain", false},
+		{"${RELIFY_TEST_HOST}", "example.org", false},
+		{"https://${RELIFY_TEST_HOST}:8008", "https://example.org:8008", false},
+		{"${RELIFY_TEST_UNSET:-localhost}", "localhost", false},
+		{"${RELIFY_TEST_UNSET:-}", "", false},
+		{"${RELIFY_TEST_HOST:-localhost}", "example.org", false},
+		{"${RELIFY_TEST_EMPTY:-default}", "", false},
+		{"${RELIFY_TEST_HOST}/${RELIFY_TEST_UNSET:-x}", "example.org/x", false},
+		{"$RELIFY_TEST_HOST", "$RELIFY_TEST_HOST", false},
+		{"${1INVALID}", "${1INVALID}", false},
+		{"${RELIFY_TEST_UNSET}", "", true},
+		{"${RELIFY_TEST_UNSET}-${RELIFY_TEST_UNSET2:-ok}", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := expandEnv(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandEnv(%q) error = %v，期望出错 %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("expandEnv(%q) = %q，期望 %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveProperties(t *testing.T) {
+	t.Setenv("RELIFY_TEST_DOMAIN", "example.org")
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "token")
+	if err := os.WriteFile(secret, []byte("  s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		props   map[string]any
+		want    map[string]any
+		wantErr bool
+	}{
+		{
+			name:  "字符串插值",
+			props: map[string]any{"domain": "${RELIFY_TEST_DOMAIN}", "port": 8008},
+			want:  map[string]any{"domain": "example.org", "port": 8008},
+		},
+		{
+			name: "嵌套映射与列表",
+			props: map[string]any{
+				"appservice": map[string]any{"listen": "http://${RELIFY_TEST_HOST:-localhost}:6168"},
+				"allow_ips":  []any{"${RELIFY_TEST_IP:-127.0.0.1}", "10.0.0.0/8"},
+			},
+			want: map[string]any{
+				"appservice": map[string]any{"listen": "http://localhost:6168"},
+				"allow_ips":  []any{"127.0.0.1", "10.0.0.0/8"},
+			},
+		},
+		{
+			name:  "文件引用",
+			props: map[string]any{"token_file": secret},
+			want:  map[string]any{"token": "s3cret"},
+		},
+		{
+			name:  "文件引用优先",
+			props: map[string]any{"token": "inline", "token_file": secret},
+			want:  map[string]any{"token": "s3cret"},
+		},
+		{
+			name:  "文件路径插值",
+			props: map[string]any{"appservice": map[string]any{"hs_token_file": "${RELIFY_TEST_DIR:-" + dir + "}/token"}},
+			want:  map[string]any{"appservice": map[string]any{"hs_token": "s3cret"}},
+		},
+		{
+			name:  "单独的后缀不是文件引用",
+			props: map[string]any{"_file": "x"},
+			want:  map[string]any{"_file": "x"},
+		},
+		{name: "环境变量未设置", props: map[string]any{"nested": []any{"${RELIFY_TEST_UNSET}"}}, wantErr: true},
+		{name: "文件不存在", props: map[string]any{"token_file": filepath.Join(dir, "missing")}, wantErr: true},
+		{name: "文件路径不是字符串", props: map[string]any{"token_file": 1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := resolveProperties(tt.props, "platforms.test.config")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveProperties error = %v，期望出错 %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(tt.props, tt.want) {
+				t.Errorf("resolveProperties = %v，期望 %v", tt.props, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		check   func(c *Config) bool
+		wantErr bool
+	}{
+		{
+			name:  "字符串",
+			env:   map[string]string{"RELIFY_LOG_LEVEL": "debug"},
+			check: func(c *Config) bool { return c.LogLevel == "debug" },
+		},
+		{
+			name:  "嵌套结构体",
+			env:   map[string]string{"RELIFY_ADMIN_TOKEN": "t0ken", "RELIFY_AUTO_BRIDGE_DEFAULT": "deny"},
+			check: func(c *Config) bool { return c.Admin.Token == "t0ken" && c.AutoBridge.Default == "deny" },
+		},
+		{
+			name:  "整数与布尔",
+			env:   map[string]string{"RELIFY_RETENT_DAY": "30", "RELIFY_FAIL_FAST": "true"},
+			check: func(c *Config) bool { return c.RetentDay == 30 && c.FailFast },
+		},
+		{
+			name:  "未设置的字段保持原值",
+			env:   map[string]string{},
+			check: func(c *Config) bool { return c.LogLevel == "info" && c.RetentDay == 7 },
+		},
+		{name: "整数格式错误", env: map[string]string{"RELIFY_RETENT_DAY": "week"}, wantErr: true},
+		{name: "布尔格式错误", env: map[string]string{"RELIFY_FAIL_FAST": "maybe"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c := &Config{LogLevel: "info", RetentDay: 7}
+			err := applyEnvOverrides(c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyEnvOverrides error = %v，期望出错 %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !tt.check(c) {
+				t.Errorf("覆盖结果不符: %+v", c)
+			}
+		})
+	}
+}
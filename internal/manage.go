@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+)
+
+// OpenDataStore 打开数据目录中的 SQLite 数据库。
+func OpenDataStore(config *Config, dataDir string) (*Store, error) {
+	return NewStore(filepath.Join(dataDir, "relify.db"), config.RetentDay)
+}
+
+// OpenCore 打开数据目录中的存储并返回不加载驱动的 Core，供命令行工具离线管理桥接与映射。
+// 返回的 Core 不能 Start，也不能执行需要驱动参与的操作（如批准建桥），使用完毕后调用 Close。
+// 运行中的实例会缓存桥接关系，离线修改在其重启后生效。
+func OpenCore(config *Config, dataDir string) (*Core, error) {
+	store, err := OpenDataStore(config, dataDir)
+	if err != nil {
+		return nil, err
+	}
+	core := &Core{
+		Registry: NewRegistry(),
+		Store:    store,
+		Report:   &StartupReport{},
+		stops:    make(map[string]context.CancelFunc),
+	}
+	core.config.Store(config)
+	return core, nil
+}
+
+// Close 关闭由 OpenCore 打开的存储。
+func (c *Core) Close() error {
+	return c.Store.Close()
+}
+
+// ParseNode 解析 "平台:房间ID" 格式的节点描述，如 "qq:123456" 或 "matrix:!abc:example.org"。
+// 仅以第一个冒号分隔，因此房间 ID 本身可以包含冒号。
+func ParseNode(s string) (BridgeNode, error) {
+	platform, roomID, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok || platform == "" || roomID == "" {
+		return BridgeNode{}, fmt.Errorf("节点格式应为 平台:房间ID: %s", s)
+	}
+	return BridgeNode{Platform: platform, RoomID: roomID}, nil
+}
+
+// checkNode 验证节点所属平台已在配置中声明。
+func (c *Core) checkNode(node BridgeNode) error {
+	return validateNode(c.Config(), node)
+}
+
+// validateNode 验证节点所属平台已在配置中声明且房间ID不为空。
+func validateNode(config *Config, node BridgeNode) error {
+	if _, ok := config.Platforms[node.Platform]; !ok {
+		return fmt.Errorf("未知平台: %s", node.Platform)
+	}
+	if node.RoomID == "" {
+		return fmt.Errorf("房间ID不能为空")
+	}
+	return nil
+}
+
+// validateLink 验证待桥接的节点，要求至少两个、平台均已声明且互不重复。
+// 返回节点的 "平台:房间ID" 描述，用于日志。
+func validateLink(config *Config, nodes []BridgeNode) ([]string, error) {
+	if len(nodes) < 2 {
+		return nil, fmt.Errorf("至少需要两个节点")
+	}
+	seen := make(map[string]bool, len(nodes))
+	keys := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if err := validateNode(config, node); err != nil {
+			return nil, err
+		}
+		key := node.Platform + ":" + node.RoomID
+		if seen[key] {
+			return nil, fmt.Errorf("节点重复: %s", key)
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ListBridges 返回所有桥接组。
+func (c *Core) ListBridges() ([]*BridgeGroup, error) {
+	return c.Store.ListBridges()
+}
+
+// LinkNodes 手动桥接多个已存在的房间（例如将已有 QQ 群与已有 Matrix 房间关联），整个操作在一个事务中完成。
+// 若其中部分房间已桥接，其余房间会加入该桥接组；已桥接的房间分属不同组时需先合并。
+func (c *Core) LinkNodes(nodes []BridgeNode) (*BridgeGroup, error) {
+	keys, err := validateLink(c.Config(), nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := c.Store.LinkNodes(nodes)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("手动桥接成功", "nodes", keys, "bridge_id", group.ID)
+	return group, nil
+}
+
+// AttachNode 将房间加入已存在的桥接组。
+func (c *Core) AttachNode(bridgeID int64, node BridgeNode) (*BridgeGroup, error) {
+	if err := c.checkNode(node); err != nil {
+		return nil, err
+	}
+	group, err := c.Store.AttachNode(bridgeID, node)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("节点加入桥接", "node", node.Platform+":"+node.RoomID, "bridge_id", bridgeID)
+	return group, nil
+}
+
+// DetachNode 将房间从其所在的桥接组中移除，返回剩余的桥接组。
+// 移出的房间记为驳回建桥，再次发言时不会自动建立新的桥接，需重新批准或手动桥接。
+func (c *Core) DetachNode(node BridgeNode) (*BridgeGroup, error) {
+	group, err := c.Store.DetachNode(node.Platform, node.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("节点移出桥接", "node", node.Platform+":"+node.RoomID)
+	return group, nil
+}
+
+// MergeBridges 将桥接组 srcID 并入 dstID。
+func (c *Core) MergeBridges(dstID, srcID int64) (*BridgeGroup, error) {
+	group, err := c.Store.MergeBridges(dstID, srcID)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("合并桥接组", "dst", dstID, "src", srcID)
+	return group, nil
+}
+
+// DissolveBridge 解散桥接组。解散后房间再次发言时会按自动桥接规则重新处理。
+func (c *Core) DissolveBridge(bridgeID int64) error {
+	if err := c.Store.DeleteBridge(bridgeID); err != nil {
+		return err
+	}
+	slog.Info("解散桥接组", "bridge_id", bridgeID)
+	return nil
+}
+
+// ListBridgeRequests 返回指定状态的自动建桥请求，status 为空时返回全部。
+func (c *Core) ListBridgeRequests(status string) ([]BridgeRequest, error) {
+	return c.Store.ListBridgeRequests(status)
+}
+
+// ApproveBridge 批准房间的自动建桥请求并立即建桥。
+// 源平台驱动暂不可用时仅记录批准，房间下次发言时建桥。
+func (c *Core) ApproveBridge(ctx context.Context, node BridgeNode) (*BridgeGroup, error) {
+	if err := c.checkNode(node); err != nil {
+		return nil, err
+	}
+	if err := c.Store.SetBridgeRequest(node.Platform, node.RoomID, RequestApproved); err != nil {
+		return nil, err
+	}
+	slog.Info("批准建桥请求", "node", node.Platform+":"+node.RoomID)
+
+	if group := c.Store.GetBridge(node.Platform, node.RoomID); group != nil {
+		return group, nil
+	}
+	if _, ok := c.Registry.GetDriver(node.Platform); !ok {
+		return nil, nil
+	}
+	return c.Router.BridgeRoom(ctx, node)
+}
+
+// RejectBridge 驳回房间的自动建桥请求，之后该房间的消息将被忽略，直到重新批准或手动桥接。
+func (c *Core) RejectBridge(node BridgeNode) error {
+	if err := c.checkNode(node); err != nil {
+		return err
+	}
+	if err := c.Store.SetBridgeRequest(node.Platform, node.RoomID, RequestRejected); err != nil {
+		return err
+	}
+	slog.Info("驳回建桥请求", "node", node.Platform+":"+node.RoomID)
+	return nil
+}
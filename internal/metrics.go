@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// 路由与存储层的 Prometheus 指标，注册在默认注册表中，由管理接口的 /metrics 导出。
+// 驱动专属的指标（如 OneBot API 调用耗时）在各驱动包中定义。
+var (
+	eventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "relify_events_received_total",
+		Help: "收到的事件数，按源平台和事件类型区分。",
+	}, []string{"platform", "type"})
+
+	dispatchTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "relify_dispatch_total",
+		Help: "投递次数，按目标平台和结果（success、failure）区分，重试也计入。",
+	}, []string{"platform", "result"})
+
+	sendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "relify_send_duration_seconds",
+		Help:    "Driver.Send 调用耗时，按目标平台区分。",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"platform"})
+
+	echoHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "relify_echo_cache_hits_total",
+		Help: "被回声缓存过滤的事件数，按平台区分。",
+	}, []string{"platform"})
+
+	storeQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "relify_store_queue_length",
+		Help: "存储层异步写操作队列中等待执行的操作数。",
+	})
+
+	storeBatchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "relify_store_batch_commit_seconds",
+		Help:    "存储层批量事务的执行与提交耗时。",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+	})
+
+	storeBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "relify_store_batch_size",
+		Help:    "存储层每个批量事务包含的操作数。",
+		Buckets: []float64{1, 2, 5, 10, 20, 50, 100},
+	})
+)
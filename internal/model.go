@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"database/sql"
 	"time"
 )
 
@@ -19,6 +20,10 @@ const (
 	TypeEdit EventType = "edit"
 	// TypeReaction 代表互动/表态操作（如点赞）。配合 Event.RefID 指向被表态的消息。
 	TypeReaction EventType = "reaction"
+	// TypeTyping 代表正在输入状态。Extra["typing"] = false 表示停止输入。
+	TypeTyping EventType = "typing"
+	// TypeRead 代表已读回执。配合 Event.RefID 指向已读的最后一条消息。
+	TypeRead EventType = "read"
 )
 
 // SegmentType 定义了消息内容片段的具体类型。
@@ -41,6 +46,13 @@ const (
 	SegReaction SegmentType = "reaction"
 )
 
+// MentionAll 是提及全体成员（如 Matrix 的 @room、QQ 的 @全体成员）时 SegMention 片段的 ID。
+const MentionAll = "all"
+
+// MentionPlatform 是 SegMention 片段 Extra 中的键，值为被提及用户所在的平台。
+// 缺省表示被提及用户属于事件的源平台；驱动将本平台上代表其他平台用户的 Ghost 用户还原为原用户时设置。
+const MentionPlatform = "platform"
+
 // SenderType 定义了发送者的实体类型。
 type SenderType string
 
@@ -60,8 +72,10 @@ type Properties map[string]any
 type Sender struct {
 	// ID 是用户在源平台的唯一标识符。
 	ID string `json:"id"`
-	// Name 是用户的显示名称或昵称。
+	// Name 是用户的显示名称或昵称，可以是仅在当前房间有效的名称（如 QQ 群名片）。
 	Name string `json:"name"`
+	// Nickname 是用户的全局昵称，为空表示与 Name 相同。
+	Nickname string `json:"nickname,omitempty"`
 	// Type 标识发送者的类型（用户、机器人、系统）。
 	Type SenderType `json:"type"`
 	// Avatar 是用户的头像 URL。
@@ -97,7 +111,7 @@ type Segment struct {
 	Type SegmentType `json:"type"`
 
 	// ID 是通用标识符字段，含义取决于 Type：
-	// - SegMention: 被 @ 的用户 ID。
+	// - SegMention: 被 @ 的用户 ID，提及全体成员时为 MentionAll。
 	// - SegImage/File/Video: 文件的 ID (可选)。
 	// - SegReaction: 通常为空，但在某些平台可能代表特定 Reaction 实例 ID。
 	ID string `json:"id,omitempty"`
@@ -113,9 +127,18 @@ type Segment struct {
 
 	// Extra 存储特殊标志或额外数据。
 	// 例如：Type 为 SegReaction 时，Extra["remove"] = true 表示这是一个“取消表态”的操作。
+	// Type 为 SegText 时，Extra 中的 Style* 键表示文本样式（见 richtext.go）。
 	Extra Properties `json:"extra,omitempty"`
 }
 
+// MentionedPlatform 返回提及段中被提及用户所在的平台，未指定时为事件的源平台 source。
+func (s *Segment) MentionedPlatform(source string) string {
+	if p, _ := s.Extra[MentionPlatform].(string); p != "" {
+		return p
+	}
+	return source
+}
+
 // Event 代表一个在系统内部流转的标准化事件。
 // 所有的业务逻辑（消息、撤回、互动）统一使用此结构，通过 Type 和 RefID 区分意图。
 type Event struct {
@@ -143,8 +166,13 @@ type Event struct {
 	// - 消息回复 (TypeMessage + SegReply logic): 指向被回复的 Message ID。
 	// - 消息撤回 (TypeRevoke): 指向被撤回的 Message ID。
 	// - 表情互动 (TypeReaction): 指向被点赞/表态的 Message ID。
+	// - 已读回执 (TypeRead): 指向已读的最后一条 Message ID。
 	RefID string `json:"ref_id,omitempty"`
 
+	// ThreadID 是消息所属话题（线程）的根消息 ID，不属于话题时为空。
+	// 话题内的消息仍通过 RefID 指向直接回复的消息；仅为话题回退而引用的消息不计入 RefID。
+	ThreadID string `json:"thread_id,omitempty"`
+
 	// Extra 存储特定于平台的额外原始数据。
 	Extra Properties `json:"extra,omitempty"`
 }
@@ -160,6 +188,7 @@ func (e *Event) Reset() {
 	e.Sender = nil
 	e.Segments = e.Segments[:0]
 	e.RefID = ""
+	e.ThreadID = ""
 	e.Extra = nil
 }
 
@@ -172,16 +201,18 @@ type BridgeNode struct {
 
 // BridgeGroup 代表一组互联的房间（即一个桥接组）。
 type BridgeGroup struct {
-	ID    int64
-	Nodes []BridgeNode
+	ID    int64        `json:"id"`
+	Nodes []BridgeNode `json:"nodes"`
 }
 
 // RoomInfo 包含从驱动获取的房间基本信息。
+// 用于创建房间时，Encrypted 表示启用端到端加密，不支持加密的驱动忽略该字段。
 type RoomInfo struct {
-	ID     string `json:"id"`
-	Name   string `json:"name"`
-	Avatar string `json:"avatar,omitempty"`
-	Topic  string `json:"topic,omitempty"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Avatar    string `json:"avatar,omitempty"`
+	Topic     string `json:"topic,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"`
 }
 
 // RoutePolicy 定义了驱动的路由策略。
@@ -208,8 +239,14 @@ type API interface {
 	// FindMapping 查找源消息 ID 对应的目标平台消息 ID。
 	FindMapping(srcPlatform, srcMsgID, dstPlatform string) (string, bool)
 
+	// FindThread 查找源平台话题在目标平台上最新一条消息的 ID。
+	FindThread(srcPlatform, threadID, dstPlatform string) (string, bool)
+
 	// Receive 将从驱动接收到的标准化事件提交给核心路由器进行处理。
 	Receive(ctx context.Context, event *Event)
+
+	// DB 返回核心数据库连接，驱动可在其中持久化自身状态，数据表应以驱动名称为前缀以免冲突。
+	DB() *sql.DB
 }
 
 // Driver 接口定义了聊天平台适配器必须实现的方法。
@@ -236,11 +273,56 @@ type Driver interface {
 
 // Config 定义了应用程序的全局配置结构。
 type Config struct {
-	LogLevel  string                    `yaml:"log_level"`
-	Mode      string                    `yaml:"mode"`
-	Hub       string                    `yaml:"hub"`
-	RetentDay int                       `yaml:"retent_day"`
-	Platforms map[string]PlatformConfig `yaml:"platforms"`
+	LogLevel   string                    `yaml:"log_level"`
+	Mode       string                    `yaml:"mode"`
+	Hub        string                    `yaml:"hub"`
+	RetentDay  int                       `yaml:"retent_day"`
+	FailFast   bool                      `yaml:"fail_fast"`
+	AutoBridge AutoBridgeConfig          `yaml:"auto_bridge"`
+	Command    CommandConfig             `yaml:"command"`
+	Admin      AdminConfig               `yaml:"admin"`
+	Platforms  map[string]PlatformConfig `yaml:"platforms"`
+
+	lines map[string]int // 各键路径在配置文件中的行号，用于校验报告
+}
+
+// AutoBridgeConfig 定义了自动建桥的准入策略。
+// 规则按顺序匹配，第一条命中的规则生效；均未命中时依次使用平台默认值和全局默认值。
+type AutoBridgeConfig struct {
+	Default  string            `yaml:"default"`
+	Defaults map[string]string `yaml:"defaults,omitempty"`
+	Rules    []BridgeRule      `yaml:"rules,omitempty"`
+}
+
+// CommandConfig 定义了聊天内指令的配置。
+type CommandConfig struct {
+	// Prefix 是指令前缀（如 "!relify"），为空表示禁用聊天内指令。
+	Prefix string `yaml:"prefix"`
+	// Admins 是额外授予管理权限的用户列表，格式为 "平台:用户ID"。
+	// 改变桥接关系的指令（link、unlink）仅限这些用户执行。
+	Admins []string `yaml:"admins,omitempty"`
+}
+
+// AdminConfig 定义了 HTTP 管理接口的配置。
+type AdminConfig struct {
+	// Listen 是管理接口的监听地址（如 "127.0.0.1:6169"），为空表示禁用。
+	Listen string `yaml:"listen"`
+	// Token 是访问管理接口所需的 Bearer Token，启用管理接口时必填。
+	Token string `yaml:"token"`
+}
+
+// BridgeRule 定义了一条自动建桥规则，所有非空条件同时满足时命中。
+type BridgeRule struct {
+	// Platform 限定源平台，为空表示任意平台。
+	Platform string `yaml:"platform,omitempty"`
+	// ChatType 限定会话类型（如 group、private），为空表示任意类型。
+	ChatType string `yaml:"chat_type,omitempty"`
+	// Rooms 是房间 ID 匹配模式列表，支持通配符（如 "10*"）和 "re:" 前缀的正则表达式，为空表示任意房间。
+	Rooms []string `yaml:"rooms,omitempty"`
+	// Action 是命中后的动作：allow、deny 或 approve（需要管理员批准）。
+	Action string `yaml:"action"`
+	// Encrypt 表示为命中的房间自动创建镜像房间时启用端到端加密（仅对支持加密的驱动生效）。
+	Encrypt bool `yaml:"encrypt,omitempty"`
 }
 
 // PlatformConfig 定义了单个平台的配置。
@@ -249,3 +331,36 @@ type PlatformConfig struct {
 	Enabled bool       `yaml:"enabled"`
 	Config  Properties `yaml:"config"`
 }
+
+// DeliveryError 描述驱动发送失败时的错误类别，供路由器决定是否重试。
+// 驱动返回的普通错误一律视为可重试的临时错误。
+type DeliveryError struct {
+	// Err 是原始错误。
+	Err error
+	// Permanent 表示永久错误（如目标房间不存在、无权限），不再重试而是直接进入死信。
+	Permanent bool
+	// RetryAfter 是建议的最短重试等待时间（如被目标平台限流），为 0 时使用默认退避。
+	RetryAfter time.Duration
+}
+
+// Error 实现 error 接口。
+func (e *DeliveryError) Error() string { return e.Err.Error() }
+
+// Unwrap 返回原始错误，以支持 errors.Is / errors.As。
+func (e *DeliveryError) Unwrap() error { return e.Err }
+
+// PermanentError 将错误标记为不可重试的永久错误。
+func PermanentError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &DeliveryError{Err: err, Permanent: true}
+}
+
+// RetryAfterError 将错误标记为需要至少等待 d 之后再重试的临时错误。
+func RetryAfterError(err error, d time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &DeliveryError{Err: err, RetryAfter: d}
+}
@@ -0,0 +1,279 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// outboxMaxAttempts 是单条消息的最大投递次数，超过后进入死信表。
+	outboxMaxAttempts = 12
+	// outboxBaseDelay 是首次重试前的等待时间，之后按指数增长。
+	outboxBaseDelay = 2 * time.Second
+	// outboxMaxDelay 是两次重试之间的最长等待时间。
+	outboxMaxDelay = 10 * time.Minute
+)
+
+// OutboxItem 代表一条等待投递到目标节点的出站消息。
+type OutboxItem struct {
+	ID        int64
+	BridgeID  int64
+	Node      BridgeNode
+	Event     *Event
+	Attempts  int
+	NextAt    time.Time
+	LastError string
+	CreatedAt time.Time
+}
+
+// DeliverFunc 定义了出站队列实际投递一条消息的函数签名。
+type DeliverFunc func(ctx context.Context, item *OutboxItem) error
+
+// outboxQueue 是单个目标节点的有序待投递队列。
+type outboxQueue struct {
+	items   []*OutboxItem
+	running bool
+}
+
+// Outbox 是持久化的出站投递队列。
+// 它按目标节点（平台+房间）维护有序队列，保证同一节点的消息按入队顺序投递；
+// 失败的消息按指数退避重试，永久错误或超过最大次数的消息进入死信表。
+// 每条消息在首次投递前同步写入数据库，之后的状态变化通过 Store 的异步写队列持久化，
+// 重启后自动回放未完成的消息。
+type Outbox struct {
+	store   *Store
+	deliver DeliverFunc
+	mu      sync.Mutex
+	queues  map[string]*outboxQueue
+	seq     atomic.Int64
+	replay  int64 // 本次启动前分配的最大 ID，只有不大于它的记录需要回放
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewOutbox 创建一个新的出站队列，deliver 用于执行实际投递。
+func NewOutbox(store *Store, deliver DeliverFunc) *Outbox {
+	ctx, cancel := context.WithCancel(context.Background())
+	o := &Outbox{
+		store:   store,
+		deliver: deliver,
+		queues:  make(map[string]*outboxQueue),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	// ID 以当前时间为起点，系统时钟回拨时从已有的最大 ID 之后继续
+	o.replay = time.Now().UnixNano()
+	if last, err := store.LastOutboxID(); err != nil {
+		slog.Warn("读取待投递消息 ID 失败", "err", err)
+	} else if last > o.replay {
+		o.replay = last
+	}
+	o.seq.Store(o.replay)
+	return o
+}
+
+// Start 从数据库加载上次未投递完成的消息并开始回放。
+// 本次启动后写入的消息仍由投递序列处理，不重复回放。
+func (o *Outbox) Start() error {
+	items, err := o.store.LoadOutbox(o.replay)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, item := range items {
+		o.push(item)
+	}
+	if len(items) > 0 {
+		slog.Info("回放待投递消息", "count", len(items))
+	}
+	return nil
+}
+
+// Stop 停止所有投递协程并等待其退出。
+// 正在进行的投递会执行完毕，等待重试的消息保留在数据库中。
+func (o *Outbox) Stop() {
+	o.cancel()
+	o.wg.Wait()
+}
+
+// Pending 判断目标节点是否仍有待投递的消息。
+// 新消息在此情况下必须排队，不能越过积压的消息直接发送。
+func (o *Outbox) Pending(node *BridgeNode) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	q, ok := o.queues[node.Platform+":"+node.RoomID]
+	return ok && len(q.items) > 0
+}
+
+// Depths 返回每个目标节点当前的积压数量。
+func (o *Outbox) Depths() map[string]int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	depths := make(map[string]int, len(o.queues))
+	for key, q := range o.queues {
+		depths[key] = len(q.items)
+	}
+	return depths
+}
+
+// NewItem 为发往目标节点的消息分配一条出站记录，调用方须在首次投递前用 Store.SaveOutbox 持久化。
+func (o *Outbox) NewItem(bridgeID int64, node BridgeNode, event *Event) *OutboxItem {
+	now := time.Now()
+	return &OutboxItem{
+		ID:        o.seq.Add(1),
+		BridgeID:  bridgeID,
+		Node:      node,
+		Event:     event,
+		NextAt:    now,
+		CreatedAt: now,
+	}
+}
+
+// Enqueue 将一条已持久化的消息加入目标节点的队列。
+// cause 为首次直接投递失败的错误（为 nil 表示因积压而排队）：
+// 永久错误直接进入死信表，其余错误计为一次失败并按退避时间重试。
+func (o *Outbox) Enqueue(item *OutboxItem, cause error) {
+	if cause != nil {
+		item.Attempts++
+		item.LastError = cause.Error()
+		if isPermanent(cause) {
+			slog.Warn("投递消息失败，转入死信", "target", item.Node.Platform, "room", item.Node.RoomID, "err", cause)
+			o.store.DeleteOutbox(item.ID)
+			o.store.SaveDeadLetter(item)
+			return
+		}
+		item.NextAt = time.Now().Add(backoff(item.Attempts, cause))
+		o.store.UpdateOutbox(item)
+	}
+
+	o.mu.Lock()
+	o.push(item)
+	o.mu.Unlock()
+}
+
+// Done 删除首次直接投递成功的消息记录。
+func (o *Outbox) Done(item *OutboxItem) {
+	o.store.DeleteOutbox(item.ID)
+}
+
+// push 将消息追加到节点队列，并在需要时启动该节点的投递协程。调用方须持有锁。
+func (o *Outbox) push(item *OutboxItem) {
+	key := item.Node.Platform + ":" + item.Node.RoomID
+	q, ok := o.queues[key]
+	if !ok {
+		q = &outboxQueue{}
+		o.queues[key] = q
+	}
+	q.items = append(q.items, item)
+	if !q.running {
+		q.running = true
+		o.wg.Add(1)
+		go o.run(key, q)
+	}
+}
+
+// run 按顺序投递单个节点队列中的消息，队首消息成功或被丢弃后才处理下一条。
+func (o *Outbox) run(key string, q *outboxQueue) {
+	defer o.wg.Done()
+
+	for {
+		o.mu.Lock()
+		if len(q.items) == 0 {
+			q.running = false
+			delete(o.queues, key)
+			o.mu.Unlock()
+			return
+		}
+		item := q.items[0]
+		o.mu.Unlock()
+
+		if wait := time.Until(item.NextAt); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-o.ctx.Done():
+				timer.Stop()
+				o.mu.Lock()
+				q.running = false
+				o.mu.Unlock()
+				return
+			}
+		}
+		if o.ctx.Err() != nil {
+			o.mu.Lock()
+			q.running = false
+			o.mu.Unlock()
+			return
+		}
+
+		// 停止时不中断正在进行的投递，避免已发出的消息被记为失败
+		err := o.deliver(context.WithoutCancel(o.ctx), item)
+		item.Attempts++
+
+		switch {
+		case err == nil:
+			o.store.DeleteOutbox(item.ID)
+		case isPermanent(err) || item.Attempts >= outboxMaxAttempts:
+			item.LastError = err.Error()
+			slog.Warn("投递消息失败，转入死信",
+				"target", item.Node.Platform,
+				"room", item.Node.RoomID,
+				"attempts", item.Attempts,
+				"err", err,
+			)
+			o.store.DeleteOutbox(item.ID)
+			o.store.SaveDeadLetter(item)
+		default:
+			item.LastError = err.Error()
+			item.NextAt = time.Now().Add(backoff(item.Attempts, err))
+			slog.Debug("投递消息失败，等待重试",
+				"target", item.Node.Platform,
+				"room", item.Node.RoomID,
+				"attempts", item.Attempts,
+				"next_at", item.NextAt,
+				"err", err,
+			)
+			o.store.UpdateOutbox(item)
+			continue
+		}
+
+		o.mu.Lock()
+		q.items = q.items[1:]
+		o.mu.Unlock()
+	}
+}
+
+// isPermanent 判断错误是否被驱动标记为不可重试。
+func isPermanent(err error) bool {
+	var de *DeliveryError
+	return errors.As(err, &de) && de.Permanent
+}
+
+// backoff 计算第 attempts 次失败后的等待时间。
+// 以 outboxBaseDelay 为基数指数增长，不超过 outboxMaxDelay，且不短于驱动建议的 RetryAfter。
+func backoff(attempts int, err error) time.Duration {
+	delay := outboxBaseDelay
+	for i := 1; i < attempts && delay < outboxMaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, outboxMaxDelay)
+
+	var de *DeliveryError
+	if errors.As(err, &de) && de.RetryAfter > delay {
+		delay = de.RetryAfter
+	}
+	return delay
+}
+
+// errDriverUnavailable 在目标驱动未加载时返回，视为临时错误等待驱动恢复。
+func errDriverUnavailable(platform string) error {
+	return fmt.Errorf("驱动不可用: %s", platform)
+}
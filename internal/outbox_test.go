@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestStore 在临时目录中创建数据库，测试结束时关闭。
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := NewStore(filepath.Join(t.TempDir(), "relify.db"), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// flushStore 等待异步写队列中已提交的操作全部落盘。
+// 写队列按顺序执行，标记记录可见时之前的操作均已提交。
+func flushStore(t *testing.T, s *Store) {
+	t.Helper()
+	marker := fmt.Sprint(time.Now().UnixNano())
+	s.SaveMapping("flush", marker, "flush", []string{marker}, 0)
+	deadline := time.Now().Add(3 * time.Second)
+	for countRows(t, s, "SELECT COUNT(*) FROM mappings WHERE src_platform='flush' AND src_msg_id=?", marker) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("等待写队列落盘超时")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// countRows 返回计数查询的结果。
+func countRows(t *testing.T, s *Store, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := s.db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// waitIdle 等待出站队列中的消息全部处理完毕（投递成功或转入死信）。
+func waitIdle(t *testing.T, o *Outbox) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for len(o.Depths()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待出站队列清空超时: %v", o.Depths())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	transient := errors.New("timeout")
+	tests := []struct {
+		attempts int
+		err      error
+		want     time.Duration
+	}{
+		{1, transient, 2 * time.Second},
+		{2, transient, 4 * time.Second},
+		{3, transient, 8 * time.Second},
+		{9, transient, 512 * time.Second},
+		{10, transient, outboxMaxDelay},
+		{outboxMaxAttempts, transient, outboxMaxDelay},
+		{1, RetryAfterError(transient, 30*time.Second), 30 * time.Second},
+		{3, RetryAfterError(transient, time.Second), 8 * time.Second},
+		{10, RetryAfterError(transient, 20*time.Minute), 20 * time.Minute},
+		{1, fmt.Errorf("发送失败: %w", RetryAfterError(transient, time.Minute)), time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%v", tt.attempts, tt.err), func(t *testing.T) {
+			if got := backoff(tt.attempts, tt.err); got != tt.want {
+				t.Errorf("backoff(%d) = %v，期望 %v", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	base := errors.New("room not found")
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{base, false},
+		{PermanentError(base), true},
+		{fmt.Errorf("发送失败: %w", PermanentError(base)), true},
+		{RetryAfterError(base, time.Second), false},
+		{errDriverUnavailable("qq"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isPermanent(tt.err); got != tt.want {
+			t.Errorf("isPermanent(%v) = %v，期望 %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestOutboxDelivery(t *testing.T) {
+	node := BridgeNode{Platform: "qq", RoomID: "100"}
+	transient := errors.New("timeout")
+	tests := []struct {
+		name         string
+		cause        error // Enqueue 时的首次投递错误
+		attempts     int   // 回放消息已失败的次数，为 0 时通过 Enqueue 入队
+		result       error // deliver 的返回值
+		delivered    int   // 期望的 deliver 调用次数
+		dead         int   // 期望的死信数量
+		deadAttempts int   // 期望死信记录的失败次数
+	}{
+		{name: "投递成功", result: nil, delivered: 1},
+		{name: "首次投递永久错误", cause: PermanentError(transient), delivered: 0, dead: 1, deadAttempts: 1},
+		{name: "重试时永久错误", result: PermanentError(transient), delivered: 1, dead: 1, deadAttempts: 1},
+		{name: "超过最大次数", attempts: outboxMaxAttempts - 1, result: transient, delivered: 1, dead: 1, deadAttempts: outboxMaxAttempts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+
+			var mu sync.Mutex
+			calls := 0
+			o := NewOutbox(s, func(ctx context.Context, item *OutboxItem) error {
+				mu.Lock()
+				defer mu.Unlock()
+				calls++
+				return tt.result
+			})
+
+			event := &Event{ID: "m1", Type: TypeMessage, Platform: "matrix", RoomID: "!a"}
+			if tt.attempts > 0 {
+				now := time.Now()
+				if err := s.SaveOutbox(&OutboxItem{ID: 1, BridgeID: 1, Node: node, Event: event, Attempts: tt.attempts, NextAt: now, CreatedAt: now}); err != nil {
+					t.Fatal(err)
+				}
+				if err := o.Start(); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				item := o.NewItem(1, node, event)
+				if err := s.SaveOutbox(item); err != nil {
+					t.Fatal(err)
+				}
+				o.Enqueue(item, tt.cause)
+			}
+			waitIdle(t, o)
+			o.Stop()
+			flushStore(t, s)
+
+			if calls != tt.delivered {
+				t.Errorf("deliver 调用 %d 次，期望 %d", calls, tt.delivered)
+			}
+			if n := countRows(t, s, "SELECT COUNT(*) FROM outbox"); n != 0 {
+				t.Errorf("outbox 剩余 %d 条，期望 0", n)
+			}
+			if n := countRows(t, s, "SELECT COUNT(*) FROM dead_letters"); n != tt.dead {
+				t.Errorf("死信 %d 条，期望 %d", n, tt.dead)
+			}
+			if tt.dead > 0 {
+				if n := countRows(t, s, "SELECT COUNT(*) FROM dead_letters WHERE attempts=? AND platform=? AND room_id=?", tt.deadAttempts, node.Platform, node.RoomID); n != 1 {
+					t.Errorf("死信记录的失败次数不是 %d", tt.deadAttempts)
+				}
+			}
+		})
+	}
+}
+
+func TestOutboxOrder(t *testing.T) {
+	s := newTestStore(t)
+
+	var mu sync.Mutex
+	got := make(map[string][]string)
+	release := make(chan struct{})
+	o := NewOutbox(s, func(ctx context.Context, item *OutboxItem) error {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		got[item.Node.RoomID] = append(got[item.Node.RoomID], item.Event.ID)
+		return nil
+	})
+
+	want := map[string][]string{"a": {"1", "3", "4"}, "b": {"2", "5"}}
+	for i, room := range []string{"a", "b", "a", "a", "b"} {
+		node := BridgeNode{Platform: "qq", RoomID: room}
+		item := o.NewItem(1, node, &Event{ID: fmt.Sprint(i + 1)})
+		if err := s.SaveOutbox(item); err != nil {
+			t.Fatal(err)
+		}
+		o.Enqueue(item, nil)
+		if !o.Pending(&node) {
+			t.Errorf("节点 %s 入队后应有待投递消息", room)
+		}
+	}
+	close(release)
+	waitIdle(t, o)
+	o.Stop()
+	flushStore(t, s)
+
+	if n := countRows(t, s, "SELECT COUNT(*) FROM outbox"); n != 0 {
+		t.Errorf("outbox 剩余 %d 条，期望 0", n)
+	}
+
+	for room, ids := range want {
+		if !slices.Equal(got[room], ids) {
+			t.Errorf("房间 %s 投递顺序 = %v，期望 %v", room, got[room], ids)
+		}
+	}
+}
+
+func TestOutboxReplay(t *testing.T) {
+	s := newTestStore(t)
+	node := BridgeNode{Platform: "qq", RoomID: "100"}
+
+	// 上次运行中写入但未投递完成的消息
+	prev := NewOutbox(s, nil)
+	old := prev.NewItem(1, node, &Event{ID: "old"})
+	if err := s.SaveOutbox(old); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var got []string
+	o := NewOutbox(s, func(ctx context.Context, item *OutboxItem) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, item.Event.ID)
+		return nil
+	})
+	// 本次启动后写入、仍在投递序列中的消息
+	if err := s.SaveOutbox(o.NewItem(1, node, &Event{ID: "new"})); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, s, "SELECT COUNT(*) FROM outbox"); n != 2 {
+		t.Fatalf("outbox 有 %d 条，期望首次投递前已同步写入 2 条", n)
+	}
+
+	if err := o.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, o)
+	o.Stop()
+
+	if !slices.Equal(got, []string{"old"}) {
+		t.Errorf("回放 %v，期望只回放上次运行的消息", got)
+	}
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// BridgeAction 是自动建桥策略对某个房间的裁决结果。
+type BridgeAction string
+
+const (
+	// ActionAllow 允许自动建桥。
+	ActionAllow BridgeAction = "allow"
+	// ActionDeny 拒绝自动建桥，消息被忽略。
+	ActionDeny BridgeAction = "deny"
+	// ActionApprove 需要管理员批准，批准前消息被忽略。
+	ActionApprove BridgeAction = "approve"
+)
+
+// BridgeRequest 记录一个等待管理员批准的自动建桥请求。
+type BridgeRequest struct {
+	Platform    string `json:"platform"`
+	RoomID      string `json:"room_id"`
+	ChatType    string `json:"chat_type,omitempty"`
+	Status      string `json:"status"`
+	RequestedAt int64  `json:"requested_at"`
+}
+
+// 建桥请求的状态。
+const (
+	RequestPending  = "pending"
+	RequestApproved = "approved"
+	RequestRejected = "rejected"
+)
+
+// BridgePolicy 是编译后的自动建桥策略，可并发读取。
+type BridgePolicy struct {
+	fallback BridgeAction
+	defaults map[string]BridgeAction
+	rules    []bridgeRule
+}
+
+// bridgeRule 是编译后的单条规则。
+type bridgeRule struct {
+	platform string
+	chatType string
+	globs    []string
+	regexps  []*regexp.Regexp
+	action   BridgeAction
+	encrypt  bool
+}
+
+// NewBridgePolicy 编译自动建桥策略配置，校验动作名称和匹配模式。
+// 未配置全局默认值时为 allow，与未启用策略时的行为一致。
+func NewBridgePolicy(cfg AutoBridgeConfig) (*BridgePolicy, error) {
+	fallback, err := parseAction(cfg.Default, ActionAllow)
+	if err != nil {
+		return nil, fmt.Errorf("auto_bridge.default: %w", err)
+	}
+
+	p := &BridgePolicy{fallback: fallback, defaults: make(map[string]BridgeAction, len(cfg.Defaults))}
+	for plat, v := range cfg.Defaults {
+		if p.defaults[plat], err = parseAction(v, fallback); err != nil {
+			return nil, fmt.Errorf("auto_bridge.defaults.%s: %w", plat, err)
+		}
+	}
+
+	for i, rc := range cfg.Rules {
+		rule := bridgeRule{platform: rc.Platform, chatType: rc.ChatType, encrypt: rc.Encrypt}
+		if rule.action, err = parseAction(rc.Action, ""); err != nil {
+			return nil, fmt.Errorf("auto_bridge.rules[%d].action: %w", i, err)
+		}
+		for _, pattern := range rc.Rooms {
+			if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+				re, err := regexp.Compile(expr)
+				if err != nil {
+					return nil, fmt.Errorf("auto_bridge.rules[%d].rooms: %w", i, err)
+				}
+				rule.regexps = append(rule.regexps, re)
+				continue
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("auto_bridge.rules[%d].rooms: 无效的通配符 %q", i, pattern)
+			}
+			rule.globs = append(rule.globs, pattern)
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+// parseAction 解析动作名称，为空时返回 def；def 也为空表示该字段必填。
+func parseAction(s string, def BridgeAction) (BridgeAction, error) {
+	switch a := BridgeAction(strings.ToLower(strings.TrimSpace(s))); a {
+	case ActionAllow, ActionDeny, ActionApprove:
+		return a, nil
+	case "":
+		if def == "" {
+			return "", fmt.Errorf("动作不能为空")
+		}
+		return def, nil
+	default:
+		return "", fmt.Errorf("未知动作: %s（可选 allow、deny、approve）", s)
+	}
+}
+
+// Evaluate 根据事件的源平台、房间和会话类型给出自动建桥裁决。
+func (p *BridgePolicy) Evaluate(event *Event) BridgeAction {
+	if rule := p.find(event); rule != nil {
+		return rule.action
+	}
+	if a, ok := p.defaults[event.Platform]; ok {
+		return a
+	}
+	return p.fallback
+}
+
+// Encrypt 判断为该事件自动创建的镜像房间是否需要启用端到端加密，由第一条命中的规则决定。
+func (p *BridgePolicy) Encrypt(event *Event) bool {
+	rule := p.find(event)
+	return rule != nil && rule.encrypt
+}
+
+// find 返回第一条命中事件的规则，没有命中时返回 nil。
+func (p *BridgePolicy) find(event *Event) *bridgeRule {
+	chatType, _ := event.Extra["chat_type"].(string)
+	for i := range p.rules {
+		if p.rules[i].match(event.Platform, event.RoomID, chatType) {
+			return &p.rules[i]
+		}
+	}
+	return nil
+}
+
+// match 判断规则是否命中，所有非空条件都需满足。
+func (r *bridgeRule) match(platform, roomID, chatType string) bool {
+	if r.platform != "" && r.platform != platform {
+		return false
+	}
+	if r.chatType != "" && r.chatType != chatType {
+		return false
+	}
+	if len(r.globs) == 0 && len(r.regexps) == 0 {
+		return true
+	}
+	for _, g := range r.globs {
+		if ok, _ := path.Match(g, roomID); ok {
+			return true
+		}
+	}
+	for _, re := range r.regexps {
+		if re.MatchString(roomID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package internal
+
+import "testing"
+
+func TestBridgePolicyEvaluate(t *testing.T) {
+	cfg := AutoBridgeConfig{
+		Default:  "deny",
+		Defaults: map[string]string{"matrix": "approve"},
+		Rules: []BridgeRule{
+			{Platform: "qq", Rooms: []string{"10*"}, Action: "allow"},
+			{Platform: "qq", Rooms: []string{"re:^2[0-9]{3}$"}, Action: "approve", Encrypt: true},
+			{Platform: "qq", ChatType: "private", Action: "deny"},
+			{Platform: "qq", Rooms: []string{"3?", "re:^4"}, Action: "allow"},
+			{Rooms: []string{"!*:example.org"}, Action: "allow", Encrypt: true},
+		},
+	}
+	p, err := NewBridgePolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		platform string
+		roomID   string
+		chatType string
+		want     BridgeAction
+		encrypt  bool
+	}{
+		{"通配符前缀", "qq", "10086", "group", ActionAllow, false},
+		{"通配符不匹配中间", "qq", "110", "group", ActionDeny, false},
+		{"正则全匹配", "qq", "2024", "group", ActionApprove, true},
+		{"正则长度不符", "qq", "20245", "group", ActionDeny, false},
+		{"会话类型", "qq", "999", "private", ActionDeny, false},
+		{"规则按顺序命中", "qq", "10001", "private", ActionAllow, false},
+		{"单字符通配符", "qq", "31", "group", ActionAllow, false},
+		{"单字符通配符长度不符", "qq", "311", "group", ActionDeny, false},
+		{"同一规则的正则", "qq", "4567", "group", ActionAllow, false},
+		{"不限平台的规则", "matrix", "!abc:example.org", "", ActionAllow, true},
+		{"平台默认值", "matrix", "!abc:other.org", "", ActionApprove, false},
+		{"全局默认值", "telegram", "1", "", ActionDeny, false},
+		{"平台限定不匹配", "telegram", "10086", "", ActionDeny, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &Event{Platform: tt.platform, RoomID: tt.roomID}
+			if tt.chatType != "" {
+				event.Extra = map[string]any{"chat_type": tt.chatType}
+			}
+			if got := p.Evaluate(event); got != tt.want {
+				t.Errorf("Evaluate(%s:%s) = %s，期望 %s", tt.platform, tt.roomID, got, tt.want)
+			}
+			if got := p.Encrypt(event); got != tt.encrypt {
+				t.Errorf("Encrypt(%s:%s) = %v，期望 %v", tt.platform, tt.roomID, got, tt.encrypt)
+			}
+		})
+	}
+}
+
+func TestBridgePolicyDefaults(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  AutoBridgeConfig
+		want BridgeAction
+	}{
+		{"未配置", AutoBridgeConfig{}, ActionAllow},
+		{"全局默认值", AutoBridgeConfig{Default: "approve"}, ActionApprove},
+		{"大小写和空白", AutoBridgeConfig{Default: " Deny "}, ActionDeny},
+		{"平台默认值为空时回退全局", AutoBridgeConfig{Default: "deny", Defaults: map[string]string{"qq": ""}}, ActionDeny},
+		{"平台默认值", AutoBridgeConfig{Default: "deny", Defaults: map[string]string{"qq": "allow"}}, ActionAllow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewBridgePolicy(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.Evaluate(&Event{Platform: "qq", RoomID: "1"}); got != tt.want {
+				t.Errorf("Evaluate = %s，期望 %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBridgePolicyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  AutoBridgeConfig
+	}{
+		{"未知全局动作", AutoBridgeConfig{Default: "maybe"}},
+		{"未知平台动作", AutoBridgeConfig{Defaults: map[string]string{"qq": "block"}}},
+		{"规则缺少动作", AutoBridgeConfig{Rules: []BridgeRule{{Platform: "qq"}}}},
+		{"无效通配符", AutoBridgeConfig{Rules: []BridgeRule{{Rooms: []string{"[10"}, Action: "allow"}}}},
+		{"无效正则", AutoBridgeConfig{Rules: []BridgeRule{{Rooms: []string{"re:(10"}, Action: "allow"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewBridgePolicy(tt.cfg); err == nil {
+				t.Error("期望返回错误")
+			}
+		})
+	}
+}
+
+func TestAllowBridgeAfterDetach(t *testing.T) {
+	s := newTestStore(t)
+	r := NewRouter(&Config{}, NewRegistry(), s)
+	defer r.Stop()
+
+	detached := &Event{Platform: "qq", RoomID: "1"}
+	other := &Event{Platform: "qq", RoomID: "2"}
+	if _, err := s.CreateBridge([]BridgeNode{{Platform: "qq", RoomID: "1"}, {Platform: "matrix", RoomID: "!a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DetachNode("qq", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.allowBridge(detached) {
+		t.Error("移出桥接的房间不应自动建桥")
+	}
+	if !r.allowBridge(other) {
+		t.Error("其他房间应按策略自动建桥")
+	}
+
+	// 重新批准后恢复自动建桥
+	if err := s.SetBridgeRequest("qq", "1", RequestApproved); err != nil {
+		t.Fatal(err)
+	}
+	if !r.allowBridge(detached) {
+		t.Error("重新批准后应允许自动建桥")
+	}
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// watchInterval 是配置文件变更的检查间隔。
+const watchInterval = 2 * time.Second
+
+// Reload 从 ConfigPath 重新读取配置并应用到运行中的实例。
+// 新配置未通过校验时保持原配置不变并返回错误。生效方式：
+// - 日志级别、运行模式、中心平台、保留天数、自动建桥策略、聊天指令：原子替换，对之后的消息生效。
+// - 平台配置：仅停止、启动或重建发生变化的平台，未变化的驱动保持连接。
+// - 管理接口：需重启后生效。
+func (c *Core) Reload() error {
+	if c.ConfigPath == "" {
+		return fmt.Errorf("未设置配置文件路径")
+	}
+	config, err := LoadConfig(c.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if err := config.Check(); err != nil {
+		return err
+	}
+	policy, err := NewBridgePolicy(config.AutoBridge)
+	if err != nil {
+		return err
+	}
+
+	if !c.started.Load() {
+		return fmt.Errorf("实例尚未启动")
+	}
+
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	old := c.Config()
+	if !reflect.DeepEqual(old.Admin, config.Admin) {
+		// 管理接口可能正在处理本次重载请求，不能在此重启
+		slog.Warn("管理接口配置变更需重启后生效")
+		config.Admin = old.Admin
+	}
+
+	// 先替换路由设置，再启停驱动，新驱动收到的第一条消息即按新配置路由
+	level, _ := ParseLogLevel(config.LogLevel)
+	LogLevel.Set(level)
+	c.config.Store(config)
+	c.Router.SetConfig(config)
+	c.Router.SetPolicy(policy)
+	c.Store.SetRetention(config.RetentDay)
+
+	changed := c.reloadDrivers(old, config)
+
+	if config.Mode == "hub" {
+		if rep, _ := c.Report.Get(config.Hub); rep.Status != StatusLoaded {
+			slog.Warn("中心平台不可用", "hub", config.Hub)
+		}
+	}
+	slog.Info("重新加载配置完成", "path", c.ConfigPath, "platforms", changed)
+	return nil
+}
+
+// reloadDrivers 比较新旧平台配置，重建发生变化的平台，返回变化的平台名称。
+// 已移除或被禁用的平台会被停止；新增或配置变化的平台会重新实例化并初始化。
+func (c *Core) reloadDrivers(old, config *Config) []string {
+	names := make(map[string]struct{})
+	for name := range old.Platforms {
+		names[name] = struct{}{}
+	}
+	for name := range config.Platforms {
+		names[name] = struct{}{}
+	}
+
+	var changed []string
+	for name := range names {
+		oldConf, inOld := old.Platforms[name]
+		newConf, inNew := config.Platforms[name]
+		if inOld && inNew && reflect.DeepEqual(oldConf, newConf) {
+			// 配置未变化，但之前启动失败的平台也会重试
+			if rep, _ := c.Report.Get(name); rep.Status == StatusLoaded || rep.Status == StatusDisabled {
+				continue
+			}
+		}
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, name := range changed {
+		c.stopDriver(stopCtx, name)
+
+		newConf, ok := config.Platforms[name]
+		if !ok {
+			c.Report.remove(name)
+			slog.Info("平台已移除", "platform", name)
+			continue
+		}
+		if !c.loadDriver(name, newConf) {
+			if rep, _ := c.Report.Get(name); rep.Status != StatusDisabled {
+				slog.Warn("平台加载失败", "platform", name, "status", rep.Status, "err", rep.Error)
+			} else {
+				slog.Info("平台已禁用", "platform", name)
+			}
+			continue
+		}
+		drv, _ := c.Registry.GetDriver(name)
+		if err := c.initDriver(name, drv); err != nil {
+			slog.Warn("平台初始化失败", "platform", name, "err", err)
+			continue
+		}
+		slog.Info("平台已重新加载", "platform", name, "policy", c.Registry.GetRoutePolicy(name))
+	}
+	return changed
+}
+
+// watchConfig 定期检查配置文件的修改时间和大小，变化时自动重新加载。
+// 重新加载失败时保留原配置并记录错误，文件再次变化时重试。
+func (c *Core) watchConfig(ctx context.Context) {
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(c.ConfigPath)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+
+	lastMod, lastSize := stat()
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		mod, size := stat()
+		if size < 0 || (mod.Equal(lastMod) && size == lastSize) {
+			continue
+		}
+		lastMod, lastSize = mod, size
+
+		slog.Info("检测到配置文件变更", "path", c.ConfigPath)
+		if err := c.Reload(); err != nil {
+			slog.Error("重新加载配置失败，保留原配置", "err", err)
+		}
+	}
+}
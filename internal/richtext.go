@@ -0,0 +1,77 @@
+package internal
+
+import "strings"
+
+// 富文本样式，以 SegText 片段的 Extra 键表示，同一片段可以同时带有多种样式。
+// 支持格式的平台据此渲染；不支持的平台调用 Segment.PlainText 获得纯文本。
+const (
+	// StyleBold 粗体 (bool)。
+	StyleBold = "bold"
+	// StyleItalic 斜体 (bool)。
+	StyleItalic = "italic"
+	// StyleUnderline 下划线 (bool)。
+	StyleUnderline = "underline"
+	// StyleStrike 删除线 (bool)。
+	StyleStrike = "strike"
+	// StyleCode 行内代码 (bool)。
+	StyleCode = "code"
+	// StylePre 代码块 (bool)，语言保存在 StyleLang 中。
+	StylePre = "pre"
+	// StyleLang 代码块的语言 (string，可选)。
+	StyleLang = "lang"
+	// StyleQuote 引用 (bool)。
+	StyleQuote = "quote"
+	// StyleSpoiler 剧透/隐藏内容 (bool)。
+	StyleSpoiler = "spoiler"
+	// StyleLink 链接目标地址 (string)，Text 为链接文字。
+	StyleLink = "link"
+)
+
+// HasStyle 判断文本片段是否带有指定的布尔样式。
+func (s *Segment) HasStyle(style string) bool {
+	v, _ := s.Extra[style].(bool)
+	return v
+}
+
+// PlainText 将带样式的文本片段降级为纯文本，返回新的片段列表，其他类型的片段原样保留。
+// 只保留影响含义的样式：链接附带地址，剧透以 || 包裹，引用的每行以 "> " 开头，其余样式直接去除。
+func PlainText(segs []Segment) []Segment {
+	out := make([]Segment, len(segs))
+	lineStart := true // 当前位置是否位于行首
+	for i, s := range segs {
+		out[i] = s
+		if s.Type != SegText {
+			if s.Type == SegMention {
+				lineStart = false
+			}
+			continue
+		}
+
+		text := s.Text
+		if link, _ := s.Extra[StyleLink].(string); link != "" && link != text {
+			text += " (" + link + ")"
+		}
+		if s.HasStyle(StyleSpoiler) {
+			text = "||" + text + "||"
+		}
+		if s.HasStyle(StyleQuote) {
+			var b strings.Builder
+			for j, line := range strings.Split(text, "\n") {
+				if j > 0 {
+					b.WriteByte('\n')
+				}
+				if line != "" && (j > 0 || lineStart) {
+					b.WriteString("> ")
+				}
+				b.WriteString(line)
+			}
+			text = b.String()
+		}
+		if text != "" {
+			lineStart = strings.HasSuffix(text, "\n")
+		}
+		out[i].Text = text
+		out[i].Extra = nil
+	}
+	return out
+}
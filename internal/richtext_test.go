@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlainText(t *testing.T) {
+	text := func(s string, extra Properties) Segment {
+		return Segment{Type: SegText, Text: s, Extra: extra}
+	}
+	plain := func(s string) Segment { return Segment{Type: SegText, Text: s} }
+	mention := Segment{Type: SegMention, ID: "@a:x", Text: "Alice"}
+	image := Segment{Type: SegImage, File: &FileInfo{URL: "http://x/a.png"}}
+
+	tests := []struct {
+		name string
+		in   []Segment
+		want []Segment
+	}{
+		{
+			name: "无样式",
+			in:   []Segment{plain("hello")},
+			want: []Segment{plain("hello")},
+		},
+		{
+			name: "去除格式样式",
+			in:   []Segment{text("bold", Properties{StyleBold: true, StyleItalic: true}), text(" code", Properties{StyleCode: true})},
+			want: []Segment{plain("bold"), plain(" code")},
+		},
+		{
+			name: "链接附带地址",
+			in:   []Segment{text("docs", Properties{StyleLink: "https://example.org"})},
+			want: []Segment{plain("docs (https://example.org)")},
+		},
+		{
+			name: "链接文字与地址相同",
+			in:   []Segment{text("https://example.org", Properties{StyleLink: "https://example.org"})},
+			want: []Segment{plain("https://example.org")},
+		},
+		{
+			name: "剧透",
+			in:   []Segment{plain("结局是 "), text("秘密", Properties{StyleSpoiler: true})},
+			want: []Segment{plain("结局是 "), plain("||秘密||")},
+		},
+		{
+			name: "行首的多行引用",
+			in:   []Segment{text("第一行\n第二行", Properties{StyleQuote: true}), plain("\n回复")},
+			want: []Segment{plain("> 第一行\n> 第二行"), plain("\n回复")},
+		},
+		{
+			name: "行中的引用不加首行前缀",
+			in:   []Segment{plain("他说"), text("你好\n再见", Properties{StyleQuote: true})},
+			want: []Segment{plain("他说"), plain("你好\n> 再见")},
+		},
+		{
+			name: "换行后的引用",
+			in:   []Segment{plain("他说\n"), text("你好", Properties{StyleQuote: true})},
+			want: []Segment{plain("他说\n"), plain("> 你好")},
+		},
+		{
+			name: "提及后的引用不在行首",
+			in:   []Segment{mention, text("你好", Properties{StyleQuote: true})},
+			want: []Segment{mention, plain("你好")},
+		},
+		{
+			name: "其他片段原样保留",
+			in:   []Segment{image, text("图", Properties{StyleBold: true})},
+			want: []Segment{image, plain("图")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PlainText(tt.in)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlainText = %#v\n期望 %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlainTextKeepsInput(t *testing.T) {
+	in := []Segment{{Type: SegText, Text: "x", Extra: Properties{StyleLink: "https://example.org"}}}
+	PlainText(in)
+	if in[0].Text != "x" || in[0].Extra[StyleLink] != "https://example.org" {
+		t.Errorf("PlainText 修改了输入: %#v", in[0])
+	}
+}
@@ -2,9 +2,11 @@ package internal
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jellydator/ttlcache/v3"
@@ -14,20 +16,25 @@ import (
 // Router 负责管理跨平台的消息路由逻辑。
 // 它处理消息的分发、自动桥接的建立以及防止消息回环（Echo）。
 type Router struct {
-	config    *Config
+	config    atomic.Pointer[Config]
 	registry  *Registry
 	store     *Store
 	sf        singleflight.Group
 	echoCache *ttlcache.Cache[string, int64]
 	eventPool sync.Pool
 	workerSem chan struct{}
+	sequencer *Sequencer
+	outbox    *Outbox
+	policy    atomic.Pointer[BridgePolicy]
+	stopped   atomic.Bool
 }
 
 // NewRouter 创建并初始化一个新的 Router 实例。
 // 包含：
 // - 初始化回声检测缓存 (5分钟 TTL)。
 // - 设置 Event 对象池以复用内存。
-// - 初始化并发控制信号量。
+// - 初始化并发控制信号量及按目标节点串行的投递序列。
+// - 创建持久化的出站投递队列。
 func NewRouter(cfg *Config, reg *Registry, s *Store) *Router {
 	cache := ttlcache.New(
 		ttlcache.WithTTL[string, int64](5*time.Minute),
@@ -36,7 +43,6 @@ func NewRouter(cfg *Config, reg *Registry, s *Store) *Router {
 	go cache.Start()
 
 	router := &Router{
-		config:    cfg,
 		registry:  reg,
 		store:     s,
 		echoCache: cache,
@@ -45,27 +51,78 @@ func NewRouter(cfg *Config, reg *Registry, s *Store) *Router {
 		},
 		workerSem: make(chan struct{}, 1000),
 	}
+	router.sequencer = NewSequencer(router.workerSem)
+	router.outbox = NewOutbox(s, router.redeliver)
+	router.config.Store(cfg)
+	router.policy.Store(&BridgePolicy{fallback: ActionAllow})
 	return router
 }
 
-// Stop 停止路由器的后台任务，如缓存清理。
+// SetConfig 替换路由使用的配置（模式、中心平台、指令等），对之后收到的消息生效。
+func (r *Router) SetConfig(cfg *Config) {
+	r.config.Store(cfg)
+}
+
+// SetPolicy 替换自动建桥策略，对之后收到的消息生效。
+func (r *Router) SetPolicy(p *BridgePolicy) {
+	r.policy.Store(p)
+}
+
+// Start 启动路由器的后台任务，回放上次未投递完成的消息。
+// 应在驱动初始化完成后调用。
+func (r *Router) Start() error {
+	return r.outbox.Start()
+}
+
+// Stop 停止路由器的后台任务，如缓存清理和出站重试。
+// 先停止接收新消息，已提交的投递任务和正在进行的重试会先执行完毕，
+// 失败的消息留在出站队列中等待下次启动回放。须在停止驱动之前调用。
 func (r *Router) Stop() {
+	r.stopped.Store(true)
+	r.sequencer.Wait()
+	r.outbox.Stop()
 	if r.echoCache != nil {
 		r.echoCache.Stop()
 	}
 }
 
+// SendNotice 以 Relify 的身份向指定节点发送一条通知。
+func (r *Router) SendNotice(ctx context.Context, node BridgeNode, text string) ([]SendResult, error) {
+	drv, ok := r.registry.GetDriver(node.Platform)
+	if !ok {
+		return nil, errDriverUnavailable(node.Platform)
+	}
+	return r.sendNotice(ctx, drv, &node, text)
+}
+
+// QueueDepths 返回出站重试队列和投递序列中每个节点的积压数量。
+func (r *Router) QueueDepths() (outbox, sequencer map[string]int) {
+	return r.outbox.Depths(), r.sequencer.Depths()
+}
+
+// DB 实现 API 接口，返回核心数据库连接。
+func (r *Router) DB() *sql.DB {
+	return r.store.db
+}
+
 // FindMapping 实现 API 接口，用于查找消息 ID 映射关系。
 func (r *Router) FindMapping(srcPlat, srcMsg, dstPlat string) (string, bool) {
 	return r.store.FindMapping(srcPlat, srcMsg, dstPlat)
 }
 
+// FindThread 查找源平台话题在目标平台上最新一条消息的 ID。
+func (r *Router) FindThread(srcPlat, threadID, dstPlat string) (string, bool) {
+	return r.store.FindThread(srcPlat, threadID, dstPlat)
+}
+
 // Receive 是处理接收到的事件的主要入口点。
 // 流程：
 // 1. 记录调试日志。
 // 2. 检查回声缓存，过滤掉自己发出的消息。
-// 3. 获取或创建桥接组。
-// 4. 并发地将消息分发到桥接组中的其他节点。
+// 3. 识别聊天内指令。
+// 4. 获取或创建桥接组，跳过已静音的节点。
+// 5. 将消息写入出站表后按目标节点提交到投递序列，不等待投递完成。
+// 驱动应按事件发生顺序调用本方法，同一目标节点的投递顺序与调用顺序一致。
 func (r *Router) Receive(ctx context.Context, event *Event) {
 	senderID := ""
 	if event.Sender != nil {
@@ -81,17 +138,33 @@ func (r *Router) Receive(ctx context.Context, event *Event) {
 		"segments", len(event.Segments),
 	)
 
+	eventsReceived.WithLabelValues(event.Platform, string(event.Type)).Inc()
+
+	if r.stopped.Load() {
+		slog.Debug("路由器已停止，忽略消息", "platform", event.Platform, "msg_id", event.ID)
+		return
+	}
+
 	// 回声检测：如果消息ID在缓存中，说明是本系统转发产生的，应忽略
 	if item := r.echoCache.Get(event.Platform + ":" + event.ID); item != nil {
+		echoHits.WithLabelValues(event.Platform).Inc()
 		slog.Debug("过滤回声消息", "platform", event.Platform, "msg_id", event.ID)
 		return
 	}
 
+	// 聊天内指令由路由器处理，不转发
+	if r.handleCommand(ctx, event) {
+		return
+	}
+
 	// 获取桥接组
 	group := r.store.GetBridge(event.Platform, event.RoomID)
 
-	// 如果没有现有桥接，尝试建立新桥接
+	// 如果没有现有桥接，尝试建立新桥接（输入状态和已读回执不触发建桥）
 	if group == nil {
+		if isTransient(event) {
+			return
+		}
 		srcDriver, ok := r.registry.GetDriver(event.Platform)
 		if !ok {
 			return
@@ -102,35 +175,48 @@ func (r *Router) Receive(ctx context.Context, event *Event) {
 			slog.Warn("建立桥接失败", "platform", event.Platform, "room", event.RoomID, "err", err)
 			return
 		}
+		if group == nil {
+			return
+		}
 	}
 
-	// 并发分发消息
-	var wg sync.WaitGroup
+	// 按目标节点排队分发：同一节点串行投递，不同节点并发投递
+	for _, node := range group.Nodes {
+		if node.Platform == event.Platform && node.RoomID == event.RoomID && isMuted(&node) {
+			slog.Debug("源节点已静音", "platform", event.Platform, "room", event.RoomID)
+			return
+		}
+	}
+	var items []*OutboxItem
+	var drivers []Driver
 	for _, node := range group.Nodes {
-		// 跳过源平台
-		if node.Platform == event.Platform {
+		// 跳过源平台及已静音的节点
+		if node.Platform == event.Platform || isMuted(&node) {
 			continue
 		}
 		// 获取目标驱动
 		if drv, ok := r.registry.GetDriver(node.Platform); ok {
-			wg.Add(1)
-			// 申请信号量，控制并发度
-			r.workerSem <- struct{}{}
-
-			go func(n BridgeNode, d Driver) {
-				defer func() {
-					<-r.workerSem
-					wg.Done()
-				}()
-				r.Dispatch(ctx, d, event, &n, group.ID)
-			}(node, drv)
+			items = append(items, r.outbox.NewItem(group.ID, node, event))
+			drivers = append(drivers, drv)
 		}
 	}
-	wg.Wait()
+
+	// 提交投递前同步写入出站表，进程在投递完成前退出时由下次启动回放
+	if !isTransient(event) {
+		if err := r.store.SaveOutbox(items...); err != nil {
+			slog.Error("保存待投递消息失败", "platform", event.Platform, "msg_id", event.ID, "err", err)
+		}
+	}
+	for i, item := range items {
+		d := drivers[i]
+		r.sequencer.Submit(item.Node.Platform+":"+item.Node.RoomID, func() {
+			r.Dispatch(ctx, d, item)
+		})
+	}
 }
 
 // MatchAndBridge 执行自动桥接匹配逻辑。
-// 使用 singleflight 防止对同一房间的并发建桥请求。
+// 先按自动建桥策略裁决，被拒绝或等待批准时返回 nil。
 // 逻辑：
 // 1. 获取源房间信息。
 // 2. 确定目标平台列表（Hub 模式或 Mesh 模式）。
@@ -138,10 +224,60 @@ func (r *Router) Receive(ctx context.Context, event *Event) {
 // 4. 将所有节点保存为新的桥接组。
 func (r *Router) MatchAndBridge(ctx context.Context, event *Event, srcDriver Driver) (*BridgeGroup, error) {
 	// Hub 模式下，Hub 平台本身不触发建桥
-	if r.config.Mode == "hub" && event.Platform == r.config.Hub {
+	if cfg := r.config.Load(); cfg.Mode == "hub" && event.Platform == cfg.Hub {
 		return nil, nil
 	}
+	if !r.allowBridge(event) {
+		return nil, nil
+	}
+	return r.createBridge(ctx, event, srcDriver)
+}
+
+// BridgeRoom 跳过自动建桥策略，直接为指定房间建立桥接，用于管理员批准建桥请求。
+func (r *Router) BridgeRoom(ctx context.Context, node BridgeNode) (*BridgeGroup, error) {
+	srcDriver, ok := r.registry.GetDriver(node.Platform)
+	if !ok {
+		return nil, errDriverUnavailable(node.Platform)
+	}
+	return r.createBridge(ctx, &Event{Platform: node.Platform, RoomID: node.RoomID}, srcDriver)
+}
+
+// allowBridge 按自动建桥策略判断是否允许为事件所在房间建桥。
+// 需要批准的房间首次出现时登记建桥请求，批准后放行，未批准或被驳回时忽略。
+// 被驳回（包括被手动移出桥接）的房间即使策略允许也不会自动建桥。
+func (r *Router) allowBridge(event *Event) bool {
+	switch r.policy.Load().Evaluate(event) {
+	case ActionAllow:
+		status, err := r.store.BridgeRequestStatus(event.Platform, event.RoomID)
+		if err != nil {
+			slog.Warn("查询建桥请求失败", "platform", event.Platform, "room", event.RoomID, "err", err)
+			return false
+		}
+		if status == RequestRejected {
+			slog.Debug("房间已被驳回或移出桥接，不自动建桥", "platform", event.Platform, "room", event.RoomID)
+			return false
+		}
+		return true
+	case ActionApprove:
+		chatType, _ := event.Extra["chat_type"].(string)
+		status, created, err := r.store.RequestBridge(event.Platform, event.RoomID, chatType)
+		if err != nil {
+			slog.Warn("登记建桥请求失败", "platform", event.Platform, "room", event.RoomID, "err", err)
+			return false
+		}
+		if created {
+			slog.Info("收到建桥请求，等待批准", "platform", event.Platform, "room", event.RoomID, "chat_type", chatType)
+		}
+		return status == RequestApproved
+	default:
+		slog.Debug("策略拒绝建桥", "platform", event.Platform, "room", event.RoomID)
+		return false
+	}
+}
 
+// createBridge 为事件所在房间在目标平台创建房间并保存桥接组。
+// 使用 singleflight 防止对同一房间的并发建桥请求。
+func (r *Router) createBridge(ctx context.Context, event *Event, srcDriver Driver) (*BridgeGroup, error) {
 	key := event.Platform + ":" + event.RoomID
 
 	result, err, _ := r.sf.Do(key, func() (any, error) {
@@ -158,8 +294,8 @@ func (r *Router) MatchAndBridge(ctx context.Context, event *Event, srcDriver Dri
 		nodes := []BridgeNode{{Platform: event.Platform, RoomID: event.RoomID}}
 		var targetPlatforms []string
 
-		if r.config.Mode == "hub" {
-			targetPlatforms = []string{r.config.Hub}
+		if cfg := r.config.Load(); cfg.Mode == "hub" {
+			targetPlatforms = []string{cfg.Hub}
 		} else {
 			for name := range r.registry.GetAllDrivers() {
 				if name != event.Platform {
@@ -181,9 +317,10 @@ func (r *Router) MatchAndBridge(ctx context.Context, event *Event, srcDriver Dri
 				targetRoomID, err = destDriver.CreateRoom(ctx, nil)
 			} else {
 				targetRoomID, err = destDriver.CreateRoom(ctx, &RoomInfo{
-					Name:   fmt.Sprintf("[%s]%s", event.Platform, roomInfo.Name),
-					Avatar: roomInfo.Avatar,
-					Topic:  "Relify Bridge",
+					Name:      fmt.Sprintf("[%s]%s", event.Platform, roomInfo.Name),
+					Avatar:    roomInfo.Avatar,
+					Topic:     "Relify Bridge",
+					Encrypted: r.policy.Load().Encrypt(event),
 				})
 			}
 
@@ -211,12 +348,26 @@ func (r *Router) MatchAndBridge(ctx context.Context, event *Event, srcDriver Dri
 	return result.(*BridgeGroup), nil
 }
 
-// Dispatch 将事件处理并发送到目标驱动。
+// Dispatch 将出站记录中的事件处理并发送到目标驱动。
 // 流程：
-// 1. 从对象池获取 Event 对象并复制源数据。
-// 2. 调用目标驱动的 Send 方法。
-// 3. 如果发送成功且是消息类型，保存 ID 映射关系并更新回声缓存。
-func (r *Router) Dispatch(ctx context.Context, destDriver Driver, srcEvent *Event, node *BridgeNode, bridgeID int64) {
+// 1. 如果目标节点有积压的出站消息，直接排队以保证顺序。
+// 2. 从对象池获取 Event 对象并复制源数据。
+// 3. 调用目标驱动的 Send 方法。
+// 4. 如果发送成功且是消息类型，保存 ID 映射关系并更新回声缓存，删除出站记录。
+// 5. 如果发送失败，交由出站队列重试或转入死信。
+// 输入状态和已读回执过时即失去意义，不写入出站表，目标节点有积压或发送失败时直接丢弃。
+func (r *Router) Dispatch(ctx context.Context, destDriver Driver, item *OutboxItem) {
+	srcEvent, node := item.Event, &item.Node
+	transient := isTransient(srcEvent)
+	if r.outbox.Pending(node) {
+		if transient {
+			return
+		}
+		item.Event = r.cloneEvent(srcEvent)
+		r.outbox.Enqueue(item, nil)
+		return
+	}
+
 	outEvent := r.eventPool.Get().(*Event)
 	defer func() {
 		outEvent.Reset()
@@ -225,12 +376,41 @@ func (r *Router) Dispatch(ctx context.Context, destDriver Driver, srcEvent *Even
 
 	r.copyEvent(srcEvent, outEvent)
 
-	results, err := destDriver.Send(ctx, node, outEvent)
-
-	if err != nil {
+	if err := r.deliver(ctx, destDriver, outEvent, node, item.BridgeID); err != nil {
+		if transient {
+			slog.Debug("投递状态事件失败", "err", err, "target", node.Platform, "room", node.RoomID, "type", srcEvent.Type)
+			return
+		}
 		slog.Warn("投递消息失败", "err", err, "target", node.Platform, "room", node.RoomID)
+		item.Event = r.cloneEvent(srcEvent)
+		r.outbox.Enqueue(item, err)
 		return
 	}
+	if !transient {
+		r.outbox.Done(item)
+	}
+}
+
+// redeliver 是出站队列的投递函数，用于重试积压或失败的消息。
+func (r *Router) redeliver(ctx context.Context, item *OutboxItem) error {
+	destDriver, ok := r.registry.GetDriver(item.Node.Platform)
+	if !ok {
+		return errDriverUnavailable(item.Node.Platform)
+	}
+	return r.deliver(ctx, destDriver, item.Event, &item.Node, item.BridgeID)
+}
+
+// deliver 按目标节点的转发格式调用目标驱动发送事件，并在成功后保存 ID 映射关系、更新回声缓存。
+// 驱动返回的错误原样返回，由调用方决定是否重试。
+func (r *Router) deliver(ctx context.Context, destDriver Driver, event *Event, node *BridgeNode, bridgeID int64) error {
+	start := time.Now()
+	results, err := destDriver.Send(ctx, node, formatEvent(event, r.currentNode(node)))
+	sendDuration.WithLabelValues(node.Platform).Observe(time.Since(start).Seconds())
+	if err != nil {
+		dispatchTotal.WithLabelValues(node.Platform, "failure").Inc()
+		return err
+	}
+	dispatchTotal.WithLabelValues(node.Platform, "success").Inc()
 
 	// 收集成功发送的消息ID
 	var newIDs []string
@@ -242,8 +422,11 @@ func (r *Router) Dispatch(ctx context.Context, destDriver Driver, srcEvent *Even
 		}
 	}
 
-	if len(newIDs) > 0 && srcEvent.Type == TypeMessage {
-		r.store.SaveMapping(srcEvent.Platform, srcEvent.ID, node.Platform, newIDs, bridgeID)
+	if len(newIDs) > 0 && event.Type == TypeMessage {
+		r.store.SaveMapping(event.Platform, event.ID, node.Platform, newIDs, bridgeID)
+		if event.ThreadID != "" {
+			r.store.SaveThread(event.Platform, event.ThreadID, node.Platform, newIDs[len(newIDs)-1])
+		}
 
 		slog.Debug("投递消息成功",
 			"to_platform", node.Platform,
@@ -257,6 +440,39 @@ func (r *Router) Dispatch(ctx context.Context, destDriver Driver, srcEvent *Even
 			r.echoCache.Set(node.Platform+":"+nid, now, ttlcache.DefaultTTL)
 		}
 	}
+	return nil
+}
+
+// currentNode 返回节点在当前桥接组中的最新配置，节点已移出桥接时原样返回。
+// 出站队列中的节点不携带配置，且配置可能在排队期间被指令修改。
+func (r *Router) currentNode(node *BridgeNode) *BridgeNode {
+	if g := r.store.GetBridge(node.Platform, node.RoomID); g != nil {
+		for i := range g.Nodes {
+			if g.Nodes[i].Platform == node.Platform && g.Nodes[i].RoomID == node.RoomID {
+				return &g.Nodes[i]
+			}
+		}
+	}
+	return node
+}
+
+// isMuted 判断节点是否已被静音。
+func isMuted(node *BridgeNode) bool {
+	muted, _ := node.Config[nodeMuted].(bool)
+	return muted
+}
+
+// isTransient 判断事件是否为瞬时状态事件（输入状态、已读回执）。
+func isTransient(event *Event) bool {
+	return event.Type == TypeTyping || event.Type == TypeRead
+}
+
+// cloneEvent 深度复制一个事件到新分配的对象中（不使用对象池）。
+// 用于需要长期持有事件的场景，如出站队列。
+func (r *Router) cloneEvent(src *Event) *Event {
+	dst := &Event{}
+	r.copyEvent(src, dst)
+	return dst
 }
 
 // copyEvent 将源事件的数据深度复制到目标事件对象中。
@@ -268,13 +484,15 @@ func (r *Router) copyEvent(src *Event, dst *Event) {
 	dst.Platform = src.Platform
 	dst.RoomID = src.RoomID
 	dst.RefID = src.RefID
+	dst.ThreadID = src.ThreadID
 
 	if src.Sender != nil {
 		dst.Sender = &Sender{
-			ID:     src.Sender.ID,
-			Name:   src.Sender.Name,
-			Type:   src.Sender.Type,
-			Avatar: src.Sender.Avatar,
+			ID:       src.Sender.ID,
+			Name:     src.Sender.Name,
+			Nickname: src.Sender.Nickname,
+			Type:     src.Sender.Type,
+			Avatar:   src.Sender.Avatar,
 		}
 		if len(src.Sender.Role) > 0 {
 			dst.Sender.Role = make(Properties, len(src.Sender.Role))
@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigSchema 是驱动配置的类型化结构，由驱动在注册时提供。
+// Core 将平台的 config 段解码到新建的 ConfigSchema 后调用 Validate 收集问题。
+type ConfigSchema interface {
+	// Validate 检查配置取值，每发现一处问题调用一次 report。
+	// path 是相对于平台 config 段的键路径（如 "appservice.token"），为空表示整个 config 段。
+	Validate(report func(path, msg string))
+}
+
+// SchemaFactory 创建一个空的驱动配置结构，用于解码和校验。
+type SchemaFactory func() ConfigSchema
+
+// ConfigIssue 描述配置中的一处问题。
+type ConfigIssue struct {
+	// Path 是问题所在的 YAML 路径，如 "platforms.qq.config.protocol"。
+	Path string `json:"path"`
+	// Line 是问题所在的行号，配置不是从文件加载或路径不存在时取最近的上级键所在行，未知时为 0。
+	Line int `json:"line,omitempty"`
+	// Msg 是问题描述。
+	Msg string `json:"msg"`
+}
+
+// String 以 "路径 (第 N 行): 描述" 的格式返回问题。
+func (i ConfigIssue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("%s (第 %d 行): %s", i.Path, i.Line, i.Msg)
+	}
+	return fmt.Sprintf("%s: %s", i.Path, i.Msg)
+}
+
+// ConfigError 汇总配置校验发现的全部问题。
+type ConfigError struct {
+	Issues []ConfigIssue
+}
+
+// Error 返回全部问题，每行一条。
+func (e *ConfigError) Error() string {
+	lines := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		lines[i] = issue.String()
+	}
+	return fmt.Sprintf("配置存在 %d 处问题:\n%s", len(e.Issues), strings.Join(lines, "\n"))
+}
+
+// issueCollector 收集校验问题，并根据 YAML 行号表补全行号。
+type issueCollector struct {
+	lines  map[string]int
+	issues []ConfigIssue
+}
+
+// add 记录一处问题。
+func (ic *issueCollector) add(path, format string, args ...any) {
+	ic.issues = append(ic.issues, ConfigIssue{Path: path, Line: ic.line(path), Msg: fmt.Sprintf(format, args...)})
+}
+
+// line 返回路径所在行号，路径不存在时逐级回退到上级键。
+func (ic *issueCollector) line(path string) int {
+	for path != "" {
+		if n, ok := ic.lines[path]; ok {
+			return n
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+// err 在存在问题时返回 *ConfigError，否则返回 nil。
+func (ic *issueCollector) err() error {
+	if len(ic.issues) == 0 {
+		return nil
+	}
+	return &ConfigError{Issues: ic.issues}
+}
+
+// validateSchema 将驱动配置解码到新建的 schema 并校验，问题以 prefix 为前缀记录。
+// 存在类型错误时逐个键找出全部类型错误，其余字段照常解码，之后始终执行 Validate。
+func validateSchema(ic *issueCollector, prefix string, props Properties, factory SchemaFactory) {
+	schema := factory()
+	b, err := json.Marshal(props)
+	if err == nil {
+		err = json.Unmarshal(b, schema)
+	}
+	var typeErr *json.UnmarshalTypeError
+	typed := make(map[string]bool) // 已报告类型错误的路径
+	switch {
+	case errors.As(err, &typeErr):
+		// Unmarshal 跳过类型不符的字段继续解码，但只返回第一处错误
+		start := len(ic.issues)
+		collectTypeErrors(ic, prefix, factory, nil, props)
+		for _, issue := range ic.issues[start:] {
+			typed[issue.Path] = true
+		}
+	case err != nil:
+		ic.add(prefix, "%v", err)
+		return
+	}
+
+	schema.Validate(func(path, msg string) {
+		if path != "" {
+			path = prefix + "." + path
+		} else {
+			path = prefix
+		}
+		// 类型错误的字段未被解码，不再重复报告由此引起的取值问题
+		if !typed[path] {
+			ic.add(path, "%s", msg)
+		}
+	})
+}
+
+// collectTypeErrors 逐个键单独解码 obj 中的配置项，记录每一处类型错误。
+// 值本身类型正确、错误出在其内部的对象继续逐键深入，使同一对象中的多处错误都能报告。
+func collectTypeErrors(ic *issueCollector, prefix string, factory SchemaFactory, path []string, obj map[string]any) {
+	for _, key := range slices.Sorted(maps.Keys(obj)) {
+		keyPath := append(slices.Clip(path), key)
+		b, err := json.Marshal(nestValue(keyPath, obj[key]))
+		if err == nil {
+			err = json.Unmarshal(b, factory())
+		}
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			continue
+		}
+		field := strings.Join(keyPath, ".")
+		if typeErr.Field != field {
+			switch child := obj[key].(type) {
+			case Properties:
+				collectTypeErrors(ic, prefix, factory, keyPath, child)
+				continue
+			case map[string]any:
+				collectTypeErrors(ic, prefix, factory, keyPath, child)
+				continue
+			}
+		}
+		ic.add(prefix+"."+field, "类型错误: 需要 %s，实际为 %s", typeErr.Type, typeErr.Value)
+	}
+}
+
+// nestValue 将值包装到 path 指定的嵌套对象中，如 ["a", "b"] 得到 {"a": {"b": v}}。
+func nestValue(path []string, v any) any {
+	for i := len(path) - 1; i >= 0; i-- {
+		v = map[string]any{path[i]: v}
+	}
+	return v
+}
+
+// yamlLines 遍历 YAML 文档，返回每个键路径所在的行号。
+// 映射的键以 "." 连接，列表元素以 "[i]" 表示，与校验问题的路径格式一致。
+func yamlLines(data []byte) map[string]int {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	lines := make(map[string]int)
+	walkYAML(doc.Content[0], "", lines)
+	return lines
+}
+
+// walkYAML 递归记录节点下所有键路径的行号。
+func walkYAML(n *yaml.Node, path string, lines map[string]int) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if path != "" {
+				key = path + "." + key
+			}
+			lines[key] = n.Content[i].Line
+			walkYAML(n.Content[i+1], key, lines)
+		}
+	case yaml.SequenceNode:
+		for i, child := range n.Content {
+			key := fmt.Sprintf("%s[%d]", path, i)
+			lines[key] = child.Line
+			walkYAML(child, key, lines)
+		}
+	case yaml.AliasNode:
+		if n.Alias != nil {
+			walkYAML(n.Alias, path, lines)
+		}
+	}
+}
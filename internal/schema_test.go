@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testSchema 是用于测试的驱动配置结构。
+type testSchema struct {
+	URL     string `json:"url"`
+	Port    int    `json:"port"`
+	Enabled bool   `json:"enabled"`
+	Nested  struct {
+		Token string   `json:"token"`
+		TTL   int      `json:"ttl"`
+		IPs   []string `json:"ips"`
+	} `json:"nested"`
+}
+
+// Validate 要求 url 与 nested.token 非空、port 为正数。
+func (s *testSchema) Validate(report func(path, msg string)) {
+	if s.URL == "" {
+		report("url", "必须设置")
+	}
+	if s.Port <= 0 {
+		report("port", "必须为正数")
+	}
+	if s.Nested.Token == "" {
+		report("nested.token", "必须设置")
+	}
+	if s.URL == "" && s.Nested.Token == "" {
+		report("", "配置为空")
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	factory := func() ConfigSchema { return &testSchema{} }
+	const prefix = "platforms.test.config"
+
+	tests := []struct {
+		name  string
+		props Properties
+		want  []string // 期望的问题路径，按报告顺序
+	}{
+		{
+			name:  "无问题",
+			props: Properties{"url": "http://x", "port": 80, "nested": map[string]any{"token": "t"}},
+			want:  nil,
+		},
+		{
+			name:  "只有取值问题",
+			props: Properties{"url": "http://x", "nested": map[string]any{}},
+			want:  []string{prefix + ".port", prefix + ".nested.token"},
+		},
+		{
+			name:  "类型错误不重复报告取值问题",
+			props: Properties{"url": "http://x", "port": "80", "nested": map[string]any{"token": "t"}},
+			want:  []string{prefix + ".port"},
+		},
+		{
+			name: "收集全部类型错误并继续校验",
+			props: Properties{
+				"port":    "80",
+				"enabled": "yes",
+				"nested":  map[string]any{"token": 1, "ttl": "1h", "ips": []any{"127.0.0.1"}},
+			},
+			want: []string{
+				prefix + ".enabled",
+				prefix + ".nested.token",
+				prefix + ".nested.ttl",
+				prefix + ".port",
+				prefix + ".url",
+				prefix,
+			},
+		},
+		{
+			name:  "嵌套对象整体类型错误",
+			props: Properties{"url": "http://x", "port": 80, "nested": "token"},
+			want:  []string{prefix + ".nested", prefix + ".nested.token"},
+		},
+		{
+			name:  "整个配置段的问题",
+			props: Properties{"port": 80},
+			want:  []string{prefix + ".url", prefix + ".nested.token", prefix},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ic := &issueCollector{}
+			validateSchema(ic, prefix, tt.props, factory)
+			var got []string
+			for _, issue := range ic.issues {
+				got = append(got, issue.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("问题路径 = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestYAMLLines(t *testing.T) {
+	data := []byte(`log_level: info
+platforms:
+  qq:
+    driver: qq
+    config:
+      url: ws://localhost
+      tokens:
+        - a
+        - b
+  matrix:
+    driver: matrix
+defaults: &defaults
+  port: 80
+copy: *defaults
+`)
+	want := map[string]int{
+		"log_level":                     1,
+		"platforms":                     2,
+		"platforms.qq":                  3,
+		"platforms.qq.driver":           4,
+		"platforms.qq.config":           5,
+		"platforms.qq.config.url":       6,
+		"platforms.qq.config.tokens":    7,
+		"platforms.qq.config.tokens[0]": 8,
+		"platforms.qq.config.tokens[1]": 9,
+		"platforms.matrix":              10,
+		"platforms.matrix.driver":       11,
+		"defaults":                      12,
+		"defaults.port":                 13,
+		"copy":                          14,
+		"copy.port":                     13,
+	}
+
+	got := yamlLines(data)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("yamlLines = %v，期望 %v", got, want)
+	}
+
+	for _, bad := range []string{"", "key: [unclosed"} {
+		if got := yamlLines([]byte(bad)); got != nil {
+			t.Errorf("yamlLines(%q) = %v，期望 nil", bad, got)
+		}
+	}
+}
+
+func TestIssueCollectorLine(t *testing.T) {
+	ic := &issueCollector{lines: map[string]int{
+		"platforms":                     2,
+		"platforms.qq":                  3,
+		"platforms.qq.config":           5,
+		"platforms.qq.config.tokens":    7,
+		"platforms.qq.config.tokens[1]": 9,
+	}}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"platforms.qq.config", 5},
+		{"platforms.qq.config.tokens[1]", 9},
+		{"platforms.qq.config.tokens[2]", 7},
+		{"platforms.qq.config.appservice.token", 5},
+		{"platforms.matrix.config", 2},
+		{"mode", 0},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		if got := ic.line(tt.path); got != tt.want {
+			t.Errorf("line(%q) = %d，期望 %d", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestConfigIssueString(t *testing.T) {
+	tests := []struct {
+		issue ConfigIssue
+		want  string
+	}{
+		{ConfigIssue{Path: "mode", Line: 3, Msg: "未知运行模式"}, "mode (第 3 行): 未知运行模式"},
+		{ConfigIssue{Path: "mode", Msg: "未知运行模式"}, "mode: 未知运行模式"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.issue.String(); got != tt.want {
+			t.Errorf("String() = %q，期望 %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package internal
+
+import "sync"
+
+// sequenceQueue 是单个键的待执行任务队列。
+type sequenceQueue struct {
+	tasks []func()
+}
+
+// Sequencer 按键串行执行任务，不同键之间并发执行。
+// 路由器以目标节点（平台+房间）为键提交投递任务，从而保证同一房间的消息、
+// 编辑和撤回按接收顺序送达，同时通过共享的信号量限制全局并发度。
+type Sequencer struct {
+	mu     sync.Mutex
+	queues map[string]*sequenceQueue
+	sem    chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewSequencer 创建一个新的 Sequencer，sem 用于限制同时执行的任务数。
+func NewSequencer(sem chan struct{}) *Sequencer {
+	return &Sequencer{
+		queues: make(map[string]*sequenceQueue),
+		sem:    sem,
+	}
+}
+
+// Submit 将任务追加到指定键的队列末尾。
+// 该方法不会阻塞，任务将在同键的前序任务全部完成后执行。
+func (s *Sequencer) Submit(key string, task func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.wg.Add(1)
+	if q, ok := s.queues[key]; ok {
+		q.tasks = append(q.tasks, task)
+		return
+	}
+
+	q := &sequenceQueue{tasks: []func(){task}}
+	s.queues[key] = q
+	go s.run(key, q)
+}
+
+// run 依次执行单个键队列中的任务，队列清空后退出。
+func (s *Sequencer) run(key string, q *sequenceQueue) {
+	for {
+		s.mu.Lock()
+		if len(q.tasks) == 0 {
+			delete(s.queues, key)
+			s.mu.Unlock()
+			return
+		}
+		task := q.tasks[0]
+		q.tasks = q.tasks[1:]
+		s.mu.Unlock()
+
+		// 申请信号量，控制跨房间的并发度
+		s.sem <- struct{}{}
+		task()
+		<-s.sem
+		s.wg.Done()
+	}
+}
+
+// Depths 返回每个键当前排队（不含执行中）的任务数。
+func (s *Sequencer) Depths() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	depths := make(map[string]int, len(s.queues))
+	for key, q := range s.queues {
+		depths[key] = len(q.tasks)
+	}
+	return depths
+}
+
+// Wait 等待所有已提交的任务执行完毕。
+func (s *Sequencer) Wait() {
+	s.wg.Wait()
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSequencerOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		sem    int
+		submit []string // 按顺序提交的任务键
+	}{
+		{"单个键", 4, []string{"a", "a", "a", "a", "a"}},
+		{"多个键交错", 4, []string{"a", "b", "a", "c", "b", "a", "c", "c"}},
+		{"并发度为一", 1, []string{"a", "b", "a", "b", "a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSequencer(make(chan struct{}, tt.sem))
+
+			var mu sync.Mutex
+			got := make(map[string][]int)
+			want := make(map[string][]int)
+			for i, key := range tt.submit {
+				want[key] = append(want[key], i)
+				s.Submit(key, func() {
+					// 让出调度，使乱序执行更容易暴露
+					time.Sleep(time.Millisecond)
+					mu.Lock()
+					got[key] = append(got[key], i)
+					mu.Unlock()
+				})
+			}
+			s.Wait()
+
+			for key, seq := range want {
+				if !slices.Equal(got[key], seq) {
+					t.Errorf("键 %s 的执行顺序 = %v，期望 %v", key, got[key], seq)
+				}
+			}
+			if depths := s.Depths(); len(depths) != 0 {
+				t.Errorf("任务完成后仍有队列: %v", depths)
+			}
+		})
+	}
+}
+
+func TestSequencerConcurrency(t *testing.T) {
+	tests := []struct {
+		sem  int
+		keys int
+	}{
+		{1, 4},
+		{2, 8},
+		{4, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("sem=%d,keys=%d", tt.sem, tt.keys), func(t *testing.T) {
+			s := NewSequencer(make(chan struct{}, tt.sem))
+
+			var running, peak atomic.Int32
+			for i := range tt.keys * 3 {
+				s.Submit(fmt.Sprintf("room%d", i%tt.keys), func() {
+					n := running.Add(1)
+					for {
+						p := peak.Load()
+						if n <= p || peak.CompareAndSwap(p, n) {
+							break
+						}
+					}
+					time.Sleep(2 * time.Millisecond)
+					running.Add(-1)
+				})
+			}
+			s.Wait()
+
+			if p := int(peak.Load()); p > tt.sem {
+				t.Errorf("最大并发 = %d，超过信号量容量 %d", p, tt.sem)
+			}
+		})
+	}
+}
+
+func TestSequencerSameKeyNotConcurrent(t *testing.T) {
+	s := NewSequencer(make(chan struct{}, 8))
+
+	var running atomic.Int32
+	var overlap atomic.Bool
+	for range 20 {
+		s.Submit("room", func() {
+			if running.Add(1) > 1 {
+				overlap.Store(true)
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+		})
+	}
+	s.Wait()
+
+	if overlap.Load() {
+		t.Error("同一键的任务并发执行")
+	}
+}
@@ -1,11 +1,14 @@
 package internal
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jellydator/ttlcache/v3"
@@ -21,27 +24,46 @@ type Operation func(*sql.Tx) error
 // - 基于通道的异步写操作队列。
 // - 批量事务提交以优化 I/O 性能。
 // - 内存缓存 (TTL Cache) 用于加速热点数据读取。
+// - 近期消息映射缓存，使尚未提交的映射立即可查。
 // - 启动时的数据预热。
 type Store struct {
 	db         *sql.DB
 	cache      *ttlcache.Cache[string, *BridgeGroup]
+	recent     *ttlcache.Cache[string, string]
+	bridgeMu   sync.Mutex
+	retention  atomic.Int64 // 消息映射保留天数
 	operations chan Operation
 	stopChan   chan struct{}
 	waitGroup  sync.WaitGroup
 }
 
+// SQLiteDSN 返回启用 WAL 日志和 5 秒忙等待的 SQLite 连接字符串。
+// modernc.org/sqlite 只识别 _pragma 参数，每个新连接都会执行这些 PRAGMA；
+// busy_timeout 放在最前，使切换日志模式时也会等待其他连接释放锁。
+func SQLiteDSN(path string) string {
+	return path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+}
+
 // NewStore 初始化并返回一个新的 Store 实例。
 // 该函数会执行以下操作：
 // 1. 打开 SQLite 数据库连接并配置 WAL 模式。
-// 2. 创建必要的数据表和索引 (bridges, mappings)。
+// 2. 创建必要的数据表和索引 (bridges, mappings, threads, outbox, dead_letters, bridge_requests)。
 // 3. 启动后台 worker 协程用于处理写操作。
 // 4. 启动后台定时任务用于清理过期的消息映射。
 // 5. 执行缓存预热。
 func NewStore(path string, retentionDays int) (*Store, error) {
-	db, err := sql.Open("sqlite", fmt.Sprintf("%s?_journal=WAL&_timeout=5000", path))
+	db, err := sql.Open("sqlite", SQLiteDSN(path))
 	if err != nil {
 		return nil, err
 	}
+	// 内存数据库等不支持 WAL 的情况下仍可运行，但并发写入更容易遇到 SQLITE_BUSY
+	var mode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		db.Close()
+		return nil, err
+	} else if mode != "wal" {
+		slog.Warn("数据库未启用 WAL 模式", "path", path, "journal_mode", mode)
+	}
 
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS bridges (
@@ -62,6 +84,44 @@ func NewStore(path string, retentionDays int) (*Store, error) {
 			PRIMARY KEY (src_platform, src_msg_id, dst_platform, dst_msg_id)
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_mapping_time ON mappings(timestamp)`,
+		`CREATE TABLE IF NOT EXISTS threads (
+			src_platform TEXT,
+			thread_id TEXT,
+			dst_platform TEXT,
+			dst_msg_id TEXT,
+			timestamp INTEGER,
+			PRIMARY KEY (src_platform, thread_id, dst_platform)
+		)`,
+		`CREATE TABLE IF NOT EXISTS outbox (
+			id INTEGER PRIMARY KEY,
+			bridge_id INTEGER,
+			platform TEXT,
+			room_id TEXT,
+			event TEXT,
+			attempts INTEGER,
+			next_at INTEGER,
+			last_error TEXT,
+			created_at INTEGER
+		)`,
+		`CREATE TABLE IF NOT EXISTS dead_letters (
+			id INTEGER PRIMARY KEY,
+			bridge_id INTEGER,
+			platform TEXT,
+			room_id TEXT,
+			event TEXT,
+			attempts INTEGER,
+			last_error TEXT,
+			created_at INTEGER,
+			failed_at INTEGER
+		)`,
+		`CREATE TABLE IF NOT EXISTS bridge_requests (
+			platform TEXT,
+			room_id TEXT,
+			chat_type TEXT,
+			status TEXT,
+			requested_at INTEGER,
+			PRIMARY KEY (platform, room_id)
+		)`,
 	}
 
 	for _, q := range queries {
@@ -76,13 +136,23 @@ func NewStore(path string, retentionDays int) (*Store, error) {
 		ttlcache.WithDisableTouchOnHit[string, *BridgeGroup](),
 	)
 
+	// 近期映射缓存：映射通过异步队列批量写入，紧随其后的编辑/撤回需要立即查到
+	recent := ttlcache.New(
+		ttlcache.WithTTL[string, string](10*time.Minute),
+		ttlcache.WithDisableTouchOnHit[string, string](),
+	)
+	go recent.Start()
+
 	store := &Store{
 		db:         db,
 		cache:      cache,
+		recent:     recent,
 		operations: make(chan Operation, 2000),
 		stopChan:   make(chan struct{}),
 	}
 
+	store.retention.Store(int64(retentionDays))
+
 	if err := store.preload(); err != nil {
 		slog.Warn("缓存预热失败", "err", err)
 	}
@@ -93,9 +163,12 @@ func NewStore(path string, retentionDays int) (*Store, error) {
 	go func() {
 		ticker := time.NewTicker(1 * time.Hour)
 		for range ticker.C {
-			expireTime := time.Now().Add(time.Duration(-retentionDays) * 24 * time.Hour).Unix()
+			expireTime := time.Now().Add(time.Duration(-store.retention.Load()) * 24 * time.Hour).Unix()
 			store.PushOperation(func(tx *sql.Tx) error {
-				_, err := tx.Exec("DELETE FROM mappings WHERE timestamp < ?", expireTime)
+				if _, err := tx.Exec("DELETE FROM mappings WHERE timestamp < ?", expireTime); err != nil {
+					return err
+				}
+				_, err := tx.Exec("DELETE FROM threads WHERE timestamp < ?", expireTime)
 				return err
 			})
 		}
@@ -146,11 +219,22 @@ func (s *Store) preload() error {
 // 它会停止缓存，关闭 worker 通道，等待所有待处理的写操作完成，最后关闭数据库连接。
 func (s *Store) Close() error {
 	s.cache.Stop()
+	s.recent.Stop()
 	close(s.stopChan)
 	s.waitGroup.Wait()
 	return s.db.Close()
 }
 
+// SetRetention 设置消息映射的保留天数，在下一次定时清理时生效。
+func (s *Store) SetRetention(days int) {
+	s.retention.Store(int64(days))
+}
+
+// Ping 检查数据库连接是否可用。
+func (s *Store) Ping() error {
+	return s.db.Ping()
+}
+
 // worker 是一个后台协程，负责从操作队列中提取请求并批量执行。
 // 它会在累积一定数量的操作或达到时间间隔后，在一个数据库事务中提交这些操作。
 func (s *Store) worker() {
@@ -163,6 +247,7 @@ func (s *Store) worker() {
 		if len(batch) == 0 {
 			return
 		}
+		start := time.Now()
 		if tx, err := s.db.Begin(); err == nil {
 			for _, op := range batch {
 				if err := op(tx); err != nil {
@@ -175,6 +260,9 @@ func (s *Store) worker() {
 		} else {
 			slog.Error("开启事务失败", "err", err)
 		}
+		storeBatchDuration.Observe(time.Since(start).Seconds())
+		storeBatchSize.Observe(float64(len(batch)))
+		storeQueueLength.Set(float64(len(s.operations)))
 		batch = batch[:0]
 	}
 
@@ -198,6 +286,7 @@ func (s *Store) worker() {
 // 该操作是非阻塞的，除非队列已满。
 func (s *Store) PushOperation(op Operation) {
 	s.operations <- op
+	storeQueueLength.Set(float64(len(s.operations)))
 }
 
 // SaveMapping 异步保存源消息 ID 与目标消息 ID 之间的映射关系。
@@ -206,6 +295,12 @@ func (s *Store) SaveMapping(srcPlat, srcMsgID, dstPlat string, dstMsgIDs []strin
 	if len(dstMsgIDs) == 0 {
 		return
 	}
+	// 正向记录最新的目标消息，反向记录每条目标消息对应的源消息
+	s.recent.Set(srcPlat+":"+srcMsgID+">"+dstPlat, dstMsgIDs[len(dstMsgIDs)-1], ttlcache.DefaultTTL)
+	for _, dstMsgID := range dstMsgIDs {
+		s.recent.Set(dstPlat+":"+dstMsgID+">"+srcPlat, srcMsgID, ttlcache.DefaultTTL)
+	}
+
 	ts := time.Now().Unix()
 	s.PushOperation(func(tx *sql.Tx) error {
 		for _, dstMsgID := range dstMsgIDs {
@@ -218,15 +313,60 @@ func (s *Store) SaveMapping(srcPlat, srcMsgID, dstPlat string, dstMsgIDs []strin
 	})
 }
 
+// SaveThread 记录话题在目标平台上最新的一条消息。
+// 与 SaveMapping 相同，写入通过异步队列进行，近期记录同时保存在内存缓存中。
+func (s *Store) SaveThread(srcPlat, threadID, dstPlat, dstMsgID string) {
+	s.recent.Set("thread:"+srcPlat+":"+threadID+">"+dstPlat, dstMsgID, ttlcache.DefaultTTL)
+
+	ts := time.Now().Unix()
+	s.PushOperation(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			`INSERT INTO threads (src_platform, thread_id, dst_platform, dst_msg_id, timestamp) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (src_platform, thread_id, dst_platform) DO UPDATE SET dst_msg_id = excluded.dst_msg_id, timestamp = excluded.timestamp`,
+			srcPlat, threadID, dstPlat, dstMsgID, ts,
+		)
+		return err
+	})
+}
+
+// FindThread 查找话题在目标平台上最新的一条消息 ID。
+// 返回目标消息 ID 和一个布尔值（表示是否找到）。
+func (s *Store) FindThread(srcPlat, threadID, dstPlat string) (string, bool) {
+	if item := s.recent.Get("thread:" + srcPlat + ":" + threadID + ">" + dstPlat); item != nil {
+		return item.Value(), true
+	}
+
+	var dstMsgID string
+	err := s.db.QueryRow("SELECT dst_msg_id FROM threads WHERE src_platform=? AND thread_id=? AND dst_platform=?",
+		srcPlat, threadID, dstPlat).Scan(&dstMsgID)
+	return dstMsgID, err == nil
+}
+
 // FindMapping 根据源平台、源消息 ID 和目标平台，查找对应的目标消息 ID。
+// 查找顺序：
+// 1. 正向：消息由 srcPlat 发出并转发到 dstPlat。
+// 2. 反向：消息由 dstPlat 发出，srcMsgID 是其在 srcPlat 上的副本。
+// 3. 旁路：消息由第三方平台发出，同时转发到了 srcPlat 和 dstPlat。
+// 近期保存的映射优先从内存缓存中查找。
 // 返回目标消息 ID 和一个布尔值（表示是否找到）。
 func (s *Store) FindMapping(srcPlat, srcMsgID, dstPlat string) (string, bool) {
-	var dstMsgID string
-	err := s.db.QueryRow(
+	if item := s.recent.Get(srcPlat + ":" + srcMsgID + ">" + dstPlat); item != nil {
+		return item.Value(), true
+	}
+
+	queries := []string{
 		"SELECT dst_msg_id FROM mappings WHERE src_platform=? AND src_msg_id=? AND dst_platform=? ORDER BY rowid DESC LIMIT 1",
-		srcPlat, srcMsgID, dstPlat,
-	).Scan(&dstMsgID)
-	return dstMsgID, err == nil
+		"SELECT src_msg_id FROM mappings WHERE dst_platform=? AND dst_msg_id=? AND src_platform=? ORDER BY rowid LIMIT 1",
+		`SELECT b.dst_msg_id FROM mappings a JOIN mappings b ON a.src_platform = b.src_platform AND a.src_msg_id = b.src_msg_id
+			WHERE a.dst_platform=? AND a.dst_msg_id=? AND b.dst_platform=? ORDER BY b.rowid LIMIT 1`,
+	}
+	for _, q := range queries {
+		var dstMsgID string
+		if err := s.db.QueryRow(q, srcPlat, srcMsgID, dstPlat).Scan(&dstMsgID); err == nil {
+			return dstMsgID, true
+		}
+	}
+	return "", false
 }
 
 // GetBridge 从缓存中检索指定平台和房间所属的桥接组信息。
@@ -241,6 +381,9 @@ func (s *Store) GetBridge(platform, roomID string) *BridgeGroup {
 // CreateBridge 在数据库中注册一个新的桥接组，并同步更新内存缓存。
 // 该操作在事务中执行，确保数据一致性。
 func (s *Store) CreateBridge(nodes []BridgeNode) (*BridgeGroup, error) {
+	s.bridgeMu.Lock()
+	defer s.bridgeMu.Unlock()
+
 	tx, err := s.db.Begin()
 	if err != nil {
 		return nil, err
@@ -267,3 +410,500 @@ func (s *Store) CreateBridge(nodes []BridgeNode) (*BridgeGroup, error) {
 
 	return group, nil
 }
+
+// ListBridges 从数据库读取所有桥接组，按组 ID 排序。
+func (s *Store) ListBridges() ([]*BridgeGroup, error) {
+	rows, err := s.db.Query("SELECT id, platform, room_id, config FROM bridges ORDER BY id, rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []*BridgeGroup
+	for rows.Next() {
+		var id int64
+		var node BridgeNode
+		var c string
+		if err := rows.Scan(&id, &node.Platform, &node.RoomID, &c); err != nil {
+			return nil, err
+		}
+		if c != "" {
+			json.Unmarshal([]byte(c), &node.Config)
+		}
+		if len(groups) == 0 || groups[len(groups)-1].ID != id {
+			groups = append(groups, &BridgeGroup{ID: id})
+		}
+		groups[len(groups)-1].Nodes = append(groups[len(groups)-1].Nodes, node)
+	}
+	return groups, rows.Err()
+}
+
+// GetBridgeByID 从数据库读取指定 ID 的桥接组，不存在时返回 nil。
+func (s *Store) GetBridgeByID(id int64) (*BridgeGroup, error) {
+	return s.loadGroup(s.db, id)
+}
+
+// LinkNodes 在同一事务中将多个房间桥接为一组。
+// - 所有节点都未桥接：创建新的桥接组。
+// - 已桥接的节点都属于同一桥接组：将其余节点加入该组。
+// - 已桥接的节点分属不同桥接组：返回错误，应使用 MergeBridges。
+// 任一节点写入失败时整个操作回滚，不会留下部分创建的桥接组。
+func (s *Store) LinkNodes(nodes []BridgeNode) (*BridgeGroup, error) {
+	return s.mutateBridges(func(tx *sql.Tx) ([]int64, []BridgeNode, error) {
+		var bridgeID int64
+		var pending []BridgeNode
+		for _, node := range nodes {
+			g := s.GetBridge(node.Platform, node.RoomID)
+			switch {
+			case g == nil:
+				pending = append(pending, node)
+			case bridgeID == 0:
+				bridgeID = g.ID
+			case g.ID != bridgeID:
+				return nil, nil, fmt.Errorf("房间已分属不同桥接组: %d, %d", bridgeID, g.ID)
+			}
+		}
+		if bridgeID == 0 {
+			bridgeID = time.Now().UnixNano()
+		}
+		for _, node := range pending {
+			bytes, _ := json.Marshal(node.Config)
+			if _, err := tx.Exec("INSERT INTO bridges (id, platform, room_id, config) VALUES (?, ?, ?, ?)", bridgeID, node.Platform, node.RoomID, string(bytes)); err != nil {
+				return nil, nil, err
+			}
+		}
+		return []int64{bridgeID}, nil, nil
+	})
+}
+
+// AttachNode 将一个节点加入已存在的桥接组，并同步更新内存缓存。
+// 节点不能已属于其他桥接组。
+func (s *Store) AttachNode(bridgeID int64, node BridgeNode) (*BridgeGroup, error) {
+	return s.mutateBridges(func(tx *sql.Tx) ([]int64, []BridgeNode, error) {
+		if g := s.GetBridge(node.Platform, node.RoomID); g != nil {
+			if g.ID == bridgeID {
+				return []int64{bridgeID}, nil, nil
+			}
+			return nil, nil, fmt.Errorf("节点已属于桥接组 %d", g.ID)
+		}
+		if g, err := s.loadGroup(tx, bridgeID); err != nil {
+			return nil, nil, err
+		} else if g == nil {
+			return nil, nil, fmt.Errorf("桥接组不存在: %d", bridgeID)
+		}
+		bytes, _ := json.Marshal(node.Config)
+		if _, err := tx.Exec("INSERT INTO bridges (id, platform, room_id, config) VALUES (?, ?, ?, ?)", bridgeID, node.Platform, node.RoomID, string(bytes)); err != nil {
+			return nil, nil, err
+		}
+		return []int64{bridgeID}, nil, nil
+	})
+}
+
+// DetachNode 将节点从其所在的桥接组中移除，并同步更新内存缓存。
+// 桥接组的其余节点保持不变（即使只剩一个节点），以便之后重新加入。
+// 同时将该房间的建桥请求标记为驳回，避免房间再次发言时被自动建桥，直到重新批准或手动桥接。
+func (s *Store) DetachNode(platform, roomID string) (*BridgeGroup, error) {
+	g := s.GetBridge(platform, roomID)
+	if g == nil {
+		return nil, fmt.Errorf("节点未桥接: %s:%s", platform, roomID)
+	}
+	return s.mutateBridges(func(tx *sql.Tx) ([]int64, []BridgeNode, error) {
+		if _, err := tx.Exec("DELETE FROM bridges WHERE platform=? AND room_id=?", platform, roomID); err != nil {
+			return nil, nil, err
+		}
+		if _, err := tx.Exec(upsertBridgeRequest, platform, roomID, RequestRejected, time.Now().Unix()); err != nil {
+			return nil, nil, err
+		}
+		return []int64{g.ID}, []BridgeNode{{Platform: platform, RoomID: roomID}}, nil
+	})
+}
+
+// MergeBridges 将桥接组 srcID 的所有节点并入桥接组 dstID，并删除 srcID。
+func (s *Store) MergeBridges(dstID, srcID int64) (*BridgeGroup, error) {
+	if dstID == srcID {
+		return nil, fmt.Errorf("不能合并同一个桥接组")
+	}
+	return s.mutateBridges(func(tx *sql.Tx) ([]int64, []BridgeNode, error) {
+		for _, id := range []int64{dstID, srcID} {
+			if g, err := s.loadGroup(tx, id); err != nil {
+				return nil, nil, err
+			} else if g == nil {
+				return nil, nil, fmt.Errorf("桥接组不存在: %d", id)
+			}
+		}
+		if _, err := tx.Exec("UPDATE bridges SET id=? WHERE id=?", dstID, srcID); err != nil {
+			return nil, nil, err
+		}
+		return []int64{dstID}, nil, nil
+	})
+}
+
+// DeleteBridge 解散桥接组，删除其全部节点并同步更新内存缓存。
+// 已保存的消息映射不受影响，直至过期清理。
+func (s *Store) DeleteBridge(bridgeID int64) error {
+	_, err := s.mutateBridges(func(tx *sql.Tx) ([]int64, []BridgeNode, error) {
+		g, err := s.loadGroup(tx, bridgeID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if g == nil {
+			return nil, nil, fmt.Errorf("桥接组不存在: %d", bridgeID)
+		}
+		if _, err := tx.Exec("DELETE FROM bridges WHERE id=?", bridgeID); err != nil {
+			return nil, nil, err
+		}
+		return nil, g.Nodes, nil
+	})
+	return err
+}
+
+// SetNodeConfig 设置已桥接节点的单项配置（如静音、转发格式），value 为 nil 时删除该项。
+func (s *Store) SetNodeConfig(platform, roomID, key string, value any) (*BridgeGroup, error) {
+	return s.mutateBridges(func(tx *sql.Tx) ([]int64, []BridgeNode, error) {
+		g := s.GetBridge(platform, roomID)
+		if g == nil {
+			return nil, nil, fmt.Errorf("节点未桥接: %s:%s", platform, roomID)
+		}
+
+		// 缓存中的节点配置不可变，复制后修改
+		conf := make(Properties)
+		for _, node := range g.Nodes {
+			if node.Platform == platform && node.RoomID == roomID {
+				for k, v := range node.Config {
+					conf[k] = v
+				}
+			}
+		}
+		if value == nil {
+			delete(conf, key)
+		} else {
+			conf[key] = value
+		}
+
+		bytes, _ := json.Marshal(conf)
+		if _, err := tx.Exec("UPDATE bridges SET config=? WHERE platform=? AND room_id=?", string(bytes), platform, roomID); err != nil {
+			return nil, nil, err
+		}
+		return []int64{g.ID}, nil, nil
+	})
+}
+
+// mutateBridges 在事务中执行桥接关系的修改，并在提交后刷新内存缓存。
+// fn 返回需要重新加载的桥接组 ID 以及被移出桥接的节点。
+// 缓存中的 BridgeGroup 视为不可变对象，修改后总是替换为新对象，避免与路由读取产生竞争。
+// 返回第一个重新加载的桥接组（如有）。
+func (s *Store) mutateBridges(fn func(tx *sql.Tx) ([]int64, []BridgeNode, error)) (*BridgeGroup, error) {
+	s.bridgeMu.Lock()
+	defer s.bridgeMu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	reload, removed, err := fn(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*BridgeGroup, 0, len(reload))
+	for _, id := range reload {
+		g, err := s.loadGroup(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if g != nil {
+			groups = append(groups, g)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, node := range removed {
+		s.cache.Delete(node.Platform + ":" + node.RoomID)
+	}
+	for _, g := range groups {
+		for _, node := range g.Nodes {
+			s.cache.Set(node.Platform+":"+node.RoomID, g, ttlcache.NoTTL)
+		}
+	}
+
+	if len(groups) > 0 {
+		return groups[0], nil
+	}
+	return nil, nil
+}
+
+// loadGroup 读取指定桥接组的全部节点，不存在时返回 nil。
+func (s *Store) loadGroup(q interface {
+	Query(string, ...any) (*sql.Rows, error)
+}, id int64) (*BridgeGroup, error) {
+	rows, err := q.Query("SELECT platform, room_id, config FROM bridges WHERE id=? ORDER BY rowid", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	group := &BridgeGroup{ID: id}
+	for rows.Next() {
+		var node BridgeNode
+		var c string
+		if err := rows.Scan(&node.Platform, &node.RoomID, &c); err != nil {
+			return nil, err
+		}
+		if c != "" {
+			json.Unmarshal([]byte(c), &node.Config)
+		}
+		group.Nodes = append(group.Nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(group.Nodes) == 0 {
+		return nil, nil
+	}
+	return group, nil
+}
+
+// SaveOutbox 在同一事务中同步持久化新的待投递消息。
+// 消息须在首次投递前落盘，进程在投递完成前退出时才能在下次启动时回放。
+func (s *Store) SaveOutbox(items ...*OutboxItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, item := range items {
+		data, err := json.Marshal(item.Event)
+		if err != nil {
+			return fmt.Errorf("序列化待投递消息失败: %w", err)
+		}
+		if _, err := tx.Exec(
+			"INSERT OR REPLACE INTO outbox (id, bridge_id, platform, room_id, event, attempts, next_at, last_error, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			item.ID, item.BridgeID, item.Node.Platform, item.Node.RoomID, string(data), item.Attempts, item.NextAt.UnixMilli(), item.LastError, item.CreatedAt.UnixMilli(),
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LastOutboxID 返回待投递消息的最大 ID，表为空时返回 0。
+func (s *Store) LastOutboxID() (int64, error) {
+	var id int64
+	err := s.db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM outbox").Scan(&id)
+	return id, err
+}
+
+// UpdateOutbox 异步更新待投递消息的重试状态。
+func (s *Store) UpdateOutbox(item *OutboxItem) {
+	id, attempts, nextAt, lastErr := item.ID, item.Attempts, item.NextAt.UnixMilli(), item.LastError
+	s.PushOperation(func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE outbox SET attempts=?, next_at=?, last_error=? WHERE id=?", attempts, nextAt, lastErr, id)
+		return err
+	})
+}
+
+// DeleteOutbox 异步删除一条已完成（投递成功或已转入死信）的待投递消息。
+func (s *Store) DeleteOutbox(id int64) {
+	s.PushOperation(func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM outbox WHERE id=?", id)
+		return err
+	})
+}
+
+// SaveDeadLetter 异步将最终投递失败的消息写入死信表，供人工排查或重放。
+func (s *Store) SaveDeadLetter(item *OutboxItem) {
+	data, err := json.Marshal(item.Event)
+	if err != nil {
+		slog.Error("序列化死信消息失败", "err", err)
+		return
+	}
+	failedAt := time.Now().UnixMilli()
+	s.PushOperation(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"INSERT OR REPLACE INTO dead_letters (id, bridge_id, platform, room_id, event, attempts, last_error, created_at, failed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			item.ID, item.BridgeID, item.Node.Platform, item.Node.RoomID, string(data), item.Attempts, item.LastError, item.CreatedAt.UnixMilli(), failedAt,
+		)
+		return err
+	})
+}
+
+// LoadOutbox 按入队顺序读取 ID 不大于 maxID 的未完成待投递消息，用于重启后回放。
+func (s *Store) LoadOutbox(maxID int64) ([]*OutboxItem, error) {
+	rows, err := s.db.Query("SELECT id, bridge_id, platform, room_id, event, attempts, next_at, last_error, created_at FROM outbox WHERE id <= ? ORDER BY id", maxID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*OutboxItem
+	for rows.Next() {
+		var item OutboxItem
+		var data string
+		var nextAt, createdAt int64
+		if err := rows.Scan(&item.ID, &item.BridgeID, &item.Node.Platform, &item.Node.RoomID, &data, &item.Attempts, &nextAt, &item.LastError, &createdAt); err != nil {
+			continue
+		}
+		item.Event = &Event{}
+		if err := json.Unmarshal([]byte(data), item.Event); err != nil {
+			slog.Warn("解析待投递消息失败", "id", item.ID, "err", err)
+			continue
+		}
+		item.NextAt = time.UnixMilli(nextAt)
+		item.CreatedAt = time.UnixMilli(createdAt)
+		items = append(items, &item)
+	}
+	return items, rows.Err()
+}
+
+// RequestBridge 查询房间的建桥请求状态，不存在时登记为待批准。
+// 返回请求状态以及是否为本次新登记的请求。
+func (s *Store) RequestBridge(platform, roomID, chatType string) (string, bool, error) {
+	var status string
+	err := s.db.QueryRow("SELECT status FROM bridge_requests WHERE platform = ? AND room_id = ?", platform, roomID).Scan(&status)
+	if err == nil {
+		return status, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", false, err
+	}
+
+	// 同步写入，避免同一房间的后续消息在队列提交前重复登记
+	res, err := s.db.Exec(
+		"INSERT OR IGNORE INTO bridge_requests (platform, room_id, chat_type, status, requested_at) VALUES (?, ?, ?, ?, ?)",
+		platform, roomID, chatType, RequestPending, time.Now().Unix(),
+	)
+	if err != nil {
+		return "", false, err
+	}
+	n, _ := res.RowsAffected()
+	return RequestPending, n > 0, nil
+}
+
+// ListBridgeRequests 返回指定状态的建桥请求，status 为空时返回全部，按登记时间排序。
+func (s *Store) ListBridgeRequests(status string) ([]BridgeRequest, error) {
+	rows, err := s.db.Query(
+		"SELECT platform, room_id, chat_type, status, requested_at FROM bridge_requests WHERE ? = '' OR status = ? ORDER BY requested_at",
+		status, status,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []BridgeRequest
+	for rows.Next() {
+		var req BridgeRequest
+		if err := rows.Scan(&req.Platform, &req.RoomID, &req.ChatType, &req.Status, &req.RequestedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, req)
+	}
+	return list, rows.Err()
+}
+
+// BridgeRequestStatus 查询房间的建桥请求状态，没有请求时返回空字符串。
+func (s *Store) BridgeRequestStatus(platform, roomID string) (string, error) {
+	var status string
+	err := s.db.QueryRow("SELECT status FROM bridge_requests WHERE platform = ? AND room_id = ?", platform, roomID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return status, err
+}
+
+// upsertBridgeRequest 设置房间的建桥请求状态，请求不存在时新建。
+const upsertBridgeRequest = `INSERT INTO bridge_requests (platform, room_id, chat_type, status, requested_at) VALUES (?, ?, '', ?, ?)
+	ON CONFLICT (platform, room_id) DO UPDATE SET status = excluded.status`
+
+// SetBridgeRequest 设置房间的建桥请求状态，请求不存在时新建。
+func (s *Store) SetBridgeRequest(platform, roomID, status string) error {
+	_, err := s.db.Exec(upsertBridgeRequest, platform, roomID, status, time.Now().Unix())
+	return err
+}
+
+// exportTables 是 Export 导出的数据表，按依赖顺序排列。
+var exportTables = []string{"bridges", "bridge_requests", "mappings", "threads", "outbox", "dead_letters"}
+
+// Vacuum 清除过期的消息映射并整理数据库文件，回收已删除数据占用的空间。
+// 返回清除的映射数量。
+func (s *Store) Vacuum() (int64, error) {
+	expireTime := time.Now().Add(time.Duration(-s.retention.Load()) * 24 * time.Hour).Unix()
+	res, err := s.db.Exec("DELETE FROM mappings WHERE timestamp < ?", expireTime)
+	if err != nil {
+		return 0, err
+	}
+	removed, _ := res.RowsAffected()
+	if _, err := s.db.Exec("DELETE FROM threads WHERE timestamp < ?", expireTime); err != nil {
+		return removed, err
+	}
+	if _, err := s.db.Exec("VACUUM"); err != nil {
+		return removed, err
+	}
+	return removed, nil
+}
+
+// Export 将全部数据表以 JSON 格式写入 w，格式为 {"表名": [{"列名": 值, ...}, ...]}。
+// 导出在同一个只读事务中进行，得到一致的快照。
+func (s *Store) Export(w io.Writer) error {
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	dump := make(map[string][]map[string]any, len(exportTables))
+	for _, table := range exportTables {
+		rows, err := tx.Query("SELECT * FROM " + table)
+		if err != nil {
+			return err
+		}
+		list, err := scanRows(rows)
+		if err != nil {
+			return err
+		}
+		dump[table] = list
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dump)
+}
+
+// scanRows 将查询结果逐行读取为列名到值的映射，并关闭 rows。
+func scanRows(rows *sql.Rows) ([]map[string]any, error) {
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	list := []map[string]any{}
+	for rows.Next() {
+		values := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]any, len(cols))
+		for i, col := range cols {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[col] = values[i]
+		}
+		list = append(list, row)
+	}
+	return list, rows.Err()
+}